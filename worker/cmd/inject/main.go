@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/OkadaSatoshi/codingworker/worker/internal/queue"
 	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
 )
 
@@ -19,13 +20,15 @@ func main() {
 	body := flag.String("body", "", "Task body")
 	jsonFile := flag.String("json", "", "JSON file containing message")
 	output := flag.String("output", "", "Output file path (default: stdout)")
+	queueDir := flag.String("queue-dir", "", "Enqueue the message into a file queue directory")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: inject [options]\n\n")
 		fmt.Fprintf(os.Stderr, "Generate a test message JSON for the CodingWorker.\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintf(os.Stderr, "  inject -repo owner/repo -issue 1 -title \"Create hello.go\" -body \"Create a hello world program\"\n")
-		fmt.Fprintf(os.Stderr, "  inject -json message.json\n\n")
+		fmt.Fprintf(os.Stderr, "  inject -json message.json\n")
+		fmt.Fprintf(os.Stderr, "  inject -json message.json -queue-dir /tmp/codingworker/queue\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 	}
@@ -61,6 +64,19 @@ func main() {
 		}
 	}
 
+	// Enqueue directly for a worker using the file backend
+	if *queueDir != "" {
+		q, err := queue.NewFile(*queueDir, 0, 0)
+		if err != nil {
+			log.Fatalf("Failed to open queue directory: %v", err)
+		}
+		if err := q.Inject(msg); err != nil {
+			log.Fatalf("Failed to enqueue message: %v", err)
+		}
+		fmt.Printf("Message enqueued to %s\n", *queueDir)
+		return
+	}
+
 	// Generate JSON output
 	data, err := json.MarshalIndent(msg, "", "  ")
 	if err != nil {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/artifact"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// artifactCommentLimit keeps the artifact comment under GitHub's 65536
+// character limit for comment bodies
+const artifactCommentLimit = 60000
+
+// tailNoteSize bounds the note artifact.Tail puts before a cut artifact
+var tailNoteSize = len(fmt.Sprintf("... (%d bytes omitted)\n", artifactCommentLimit*1000))
+
+// startArtifacts creates the task's artifact directory. Returns nil (saving
+// nothing) if it cannot be created.
+func (w *Worker) startArtifacts(msg *sqs.Message) *artifact.Store {
+	store, err := artifact.New(w.config.Worker.ArtifactDir, jobKey(msg))
+	if err != nil {
+		slog.Error("Failed to create artifact directory", "error", err)
+		return nil
+	}
+	slog.Info("Saving task artifacts", "dir", store.Dir())
+	return store
+}
+
+// postArtifacts posts the task's artifacts as a collapsible comment when
+// worker.artifact_comment is set. It goes to the PR the task opened, if
+// any, else to the issue (or the PR, for follow-ups).
+func (w *Worker) postArtifacts(ctx context.Context, msg *sqs.Message, store *artifact.Store, prURL string) {
+	if !w.config.Worker.ArtifactComment || len(store.Entries()) == 0 {
+		return
+	}
+	target := commentTarget(msg)
+	if n, err := strconv.Atoi(path.Base(prURL)); err == nil && !msg.IsFollowUp() {
+		target = n
+	}
+	if err := w.github.AddComment(ctx, msg.Repository, target, buildArtifactComment(store.Entries())); err != nil {
+		slog.Error("Failed to post artifact comment", "error", err)
+	}
+}
+
+// buildArtifactComment renders each artifact in a <details> block. Long
+// artifacts keep their tail, where errors usually are, so the comment fits
+// in artifactCommentLimit: what is left after the fixed text is shared
+// among the entries, and entries that would still not fit are left out.
+func buildArtifactComment(entries []artifact.Entry) string {
+	var b strings.Builder
+	b.WriteString("## 📎 CodingWorker: 実行ログ\n\n")
+	b.WriteString("エージェントへのプロンプト・出力・差分と検証コマンドの出力です。長いものは末尾のみ載せています。\n")
+
+	budget := artifactCommentLimit - b.Len()
+	for _, entry := range entries {
+		budget -= len(artifactBlock(entry.Name, "")) + tailNoteSize
+	}
+	perEntry := max(budget/len(entries), 0)
+
+	for i, entry := range entries {
+		data, err := os.ReadFile(entry.Path)
+		if err != nil {
+			slog.Warn("Failed to read artifact", "path", entry.Path, "error", err)
+			continue
+		}
+		block := artifactBlock(entry.Name, strings.TrimRight(artifact.Tail(string(data), perEntry), "\n"))
+		if b.Len()+len(block) > artifactCommentLimit {
+			fmt.Fprintf(&b, "\n他 %d 件は省略しました（アーティファクトディレクトリを参照）。\n", len(entries)-i)
+			break
+		}
+		b.WriteString(block)
+	}
+	return b.String()
+}
+
+// artifactBlock renders one artifact as a collapsible code block
+func artifactBlock(name, content string) string {
+	lang := ""
+	if strings.HasSuffix(name, ".patch") {
+		lang = "diff"
+	}
+	// Four backticks so that fences inside prompts and output stay intact
+	return fmt.Sprintf("\n<details><summary>%s</summary>\n\n````%s\n%s\n````\n\n</details>\n", name, lang, content)
+}
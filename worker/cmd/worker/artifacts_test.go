@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/artifact"
+)
+
+func TestBuildArtifactComment_FitsLimit(t *testing.T) {
+	entries := func(n, size int) []artifact.Entry {
+		dir := t.TempDir()
+		var list []artifact.Entry
+		for i := range n {
+			name := fmt.Sprintf("%03d-verify-test-go-test-with-a-long-step-name.log", i)
+			path := filepath.Join(dir, name)
+			os.WriteFile(path, []byte(strings.Repeat("FAIL: TestSomething\n", size/20)), 0644)
+			list = append(list, artifact.Entry{Name: name, Path: path})
+		}
+		return list
+	}
+
+	for _, tc := range []struct {
+		name    string
+		entries []artifact.Entry
+		omitted bool
+	}{
+		{"few large", entries(3, 100000), false},
+		{"many large", entries(300, 10000), false},
+		{"too many to list", entries(2000, 100), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := buildArtifactComment(tc.entries)
+			if len(body) > artifactCommentLimit {
+				t.Errorf("comment is %d bytes, limit %d", len(body), artifactCommentLimit)
+			}
+			if strings.Contains(body, "件は省略しました") != tc.omitted {
+				t.Errorf("omission note present = %v, want %v", !tc.omitted, tc.omitted)
+			}
+			if !strings.Contains(body, "<summary>000-") {
+				t.Error("expected the first artifact to be included")
+			}
+		})
+	}
+}
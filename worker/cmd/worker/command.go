@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/journal"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// errCancelled is the cancellation cause of a task stopped by /codingworker cancel
+var errCancelled = errors.New("cancelled by /codingworker cancel")
+
+// defaultCancelPollInterval is used by watchCancel when neither the progress
+// nor the heartbeat interval is set
+const defaultCancelPollInterval = time.Minute
+
+// cancelled reports whether ctx was cancelled by a cancel command
+func cancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errCancelled)
+}
+
+// aborted reports whether ctx was cancelled by shutdown. An aborted task
+// keeps its clone and journal entry so it can resume on redelivery.
+func aborted(ctx context.Context) bool {
+	return ctx.Err() != nil && !cancelled(ctx)
+}
+
+// runningTask is a task in progress on this worker
+type runningTask struct {
+	msg     *sqs.Message
+	started time.Time
+	cancel  context.CancelCauseFunc
+}
+
+// track registers a running task so commands can find it. Returns nil if a
+// task for the same issue is already running.
+func (w *Worker) track(msg *sqs.Message, cancel context.CancelCauseFunc) *runningTask {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for task := range w.running {
+		if task.msg.Repository == msg.Repository && task.msg.IssueNumber == msg.IssueNumber {
+			return nil
+		}
+	}
+	if w.running == nil {
+		w.running = make(map[*runningTask]struct{})
+	}
+	task := &runningTask{msg: msg, started: time.Now(), cancel: cancel}
+	w.running[task] = struct{}{}
+	return task
+}
+
+func (w *Worker) untrack(task *runningTask) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.running, task)
+}
+
+// runningFor returns the tasks running on this worker for an issue
+// (the issue's task and any follow-ups on its PR)
+func (w *Worker) runningFor(repository string, issueNumber int) []*runningTask {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var tasks []*runningTask
+	for task := range w.running {
+		if task.msg.Repository == repository && task.msg.IssueNumber == issueNumber {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// needsSlot reports whether handling msg runs a task. Other commands are
+// handled even while every task slot is busy.
+func needsSlot(msg *sqs.Message) bool {
+	return !msg.IsCommand() || msg.Command == sqs.CommandRetry || msg.Command == sqs.CommandModel
+}
+
+// handleCommand executes a "/codingworker <command>" from an issue comment.
+// retry and model run the issue's task again on this message; the other
+// commands answer right away.
+func (w *Worker) handleCommand(ctx context.Context, msg *sqs.Message) error {
+	slog.Info("Processing command",
+		"issue_number", msg.IssueNumber,
+		"repository", msg.Repository,
+		"command", msg.Command,
+		"arg", msg.CommandArg,
+		"author", msg.CommentAuthor,
+	)
+
+	var reply string
+	switch msg.Command {
+	case sqs.CommandRetry, sqs.CommandModel:
+		task, why, err := w.commandTask(ctx, msg)
+		switch {
+		case err != nil:
+			// Answer instead of leaving the command invisible until its
+			// lease expires; the user can send it again
+			slog.Error("Failed to prepare command task", "command", msg.Command, "error", err)
+			reply = fmt.Sprintf("コマンドを実行できませんでした:\n```\n%v\n```", redact.Error(err))
+		case task != nil:
+			return w.runTask(ctx, task)
+		default:
+			reply = why
+		}
+	case sqs.CommandCancel:
+		reply = w.cancelTasks(ctx, msg)
+	case sqs.CommandStatus:
+		reply = w.buildStatusComment(msg)
+	default:
+		reply = fmt.Sprintf("不明なコマンドです: `%s`\n\n使用できるコマンド: `retry`, `cancel`, `model <name>`, `status`", msg.Command)
+	}
+	if reply != "" {
+		w.replyToCommand(ctx, msg, reply)
+	}
+
+	if err := w.queue.Delete(ctx, msg.ReceiptHandle); err != nil {
+		return fmt.Errorf("message deletion failed: %w", err)
+	}
+	return nil
+}
+
+// commandTask builds the task message for retry and model from the issue as
+// it is now. Returns a reply instead when the task can't run.
+func (w *Worker) commandTask(ctx context.Context, msg *sqs.Message) (*sqs.Message, string, error) {
+	if len(w.runningFor(msg.Repository, msg.IssueNumber)) > 0 {
+		return nil, "このIssueのタスクは実行中です。やり直す場合は先に `/codingworker cancel` してください。", nil
+	}
+
+	model := ""
+	if msg.Command == sqs.CommandModel {
+		if _, err := w.aider.WithModel(msg.CommandArg); err != nil {
+			return nil, fmt.Sprintf("モデルを指定できません: %v", err), nil
+		}
+		model = msg.CommandArg
+	}
+
+	issue, err := w.github.GetIssue(ctx, msg.Repository, msg.IssueNumber)
+	if err != nil {
+		return nil, "", err
+	}
+	labels := make([]string, 0, len(issue.Labels))
+	for _, label := range issue.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	// The command message carries the task: its lease and created_at are
+	// the task's, so a redelivery resumes it like any other task
+	return &sqs.Message{
+		Type:          sqs.TypeIssue,
+		IssueNumber:   msg.IssueNumber,
+		Repository:    msg.Repository,
+		Title:         issue.Title,
+		Body:          issue.Body,
+		Labels:        labels,
+		CreatedAt:     msg.CreatedAt,
+		ReceiptHandle: msg.ReceiptHandle,
+		Model:         model,
+	}, "", nil
+}
+
+// cancelTasks aborts the issue's tasks. Tasks on this worker stop right
+// away; for tasks on other workers the issue gets the cancel label, which the
+// worker running the task watches for (see watchCancel). The task reports the
+// cancellation itself, so the reply is empty when one was found here.
+func (w *Worker) cancelTasks(ctx context.Context, msg *sqs.Message) string {
+	tasks := w.runningFor(msg.Repository, msg.IssueNumber)
+	for _, task := range tasks {
+		slog.Info("Cancelling task", "issue_number", msg.IssueNumber, "requested_by", msg.CommentAuthor)
+		task.cancel(errCancelled)
+	}
+	if len(tasks) > 0 {
+		return ""
+	}
+
+	slog.Info("Requesting cancellation from other workers", "issue_number", msg.IssueNumber, "requested_by", msg.CommentAuthor)
+	if err := w.github.UpdateLabels(ctx, msg.Repository, msg.IssueNumber, []string{sqs.LabelCancel}, nil); err != nil {
+		return fmt.Sprintf("キャンセルを要求できませんでした:\n```\n%v\n```", redact.Error(err))
+	}
+	return fmt.Sprintf("このWorkerで実行中のタスクはありません。他のWorkerで実行中のタスクは `%s` ラベルを確認して停止します。", sqs.LabelCancel)
+}
+
+// watchCancel cancels the task when its issue gets the cancel label, i.e.
+// when the cancel command was handled by another worker. The issue is
+// checked every worker.progress_interval_seconds (the heartbeat interval
+// when progress comments are off, a minute when neither is set) until ctx
+// is done.
+func (w *Worker) watchCancel(ctx context.Context, msg *sqs.Message, cancel context.CancelCauseFunc) {
+	interval := time.Duration(w.config.Worker.ProgressInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Duration(w.config.SQS.HeartbeatInterval) * time.Second
+	}
+	if interval <= 0 {
+		interval = defaultCancelPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		issue, err := w.github.GetIssue(ctx, msg.Repository, msg.IssueNumber)
+		if err != nil {
+			slog.Warn("Failed to check issue for cancellation", "issue_number", msg.IssueNumber, "error", err)
+			continue
+		}
+		for _, label := range issue.Labels {
+			if label.Name == sqs.LabelCancel {
+				slog.Info("Cancel label found, cancelling task", "issue_number", msg.IssueNumber)
+				cancel(errCancelled)
+				return
+			}
+		}
+	}
+}
+
+// clearCancel removes the cancel label from the task's issue. Errors are
+// logged but never fail the task.
+func (w *Worker) clearCancel(ctx context.Context, msg *sqs.Message) {
+	if err := w.github.UpdateLabels(ctx, msg.Repository, msg.IssueNumber, nil, []string{sqs.LabelCancel}); err != nil {
+		slog.Error("Failed to remove cancel label", "issue_number", msg.IssueNumber, "error", err)
+	}
+}
+
+// buildStatusComment describes the issue's task from this worker's view
+func (w *Worker) buildStatusComment(msg *sqs.Message) string {
+	job, ok := w.journal.Get(journal.Key(msg.Repository, msg.IssueNumber))
+	tasks := w.runningFor(msg.Repository, msg.IssueNumber)
+
+	if len(tasks) > 0 {
+		status := fmt.Sprintf("**状態**: 実行中\n**経過時間**: %s", time.Since(tasks[0].started).Round(time.Second))
+		if ok && job.Status == journal.StatusRunning && job.Stage != "" {
+			status += fmt.Sprintf("\n**完了したステージ**: %s", job.Stage)
+		}
+		if model := tasks[0].msg.Model; model != "" {
+			status += fmt.Sprintf("\n**指定モデル**: %s", model)
+		}
+		return status
+	}
+	if !ok {
+		return "このIssueのタスクの記録はありません。"
+	}
+
+	status := fmt.Sprintf("**状態**: %s\n**更新日時**: %s", job.Status, job.UpdatedAt.Format("2006-01-02 15:04:05"))
+	if job.Stage != "" {
+		status += fmt.Sprintf("\n**完了したステージ**: %s", job.Stage)
+	}
+	if job.Model != "" {
+		status += fmt.Sprintf("\n**生成モデル**: %s", job.Model)
+	}
+	if job.PRURL != "" {
+		status += fmt.Sprintf("\n**PR**: %s", job.PRURL)
+	}
+	if job.Error != "" {
+		status += fmt.Sprintf("\n**エラー内容**:\n```\n%s\n```", job.Error)
+	}
+	return status
+}
+
+// replyToCommand answers a command on its issue. Errors are logged but never
+// fail the command.
+func (w *Worker) replyToCommand(ctx context.Context, msg *sqs.Message, reply string) {
+	body := fmt.Sprintf("## 🤖 CodingWorker: `/codingworker %s`\n\n%s\n", msg.Command, reply)
+	if err := w.github.AddComment(ctx, msg.Repository, msg.IssueNumber, body); err != nil {
+		slog.Error("Failed to reply to command", "command", msg.Command, "error", err)
+	}
+}
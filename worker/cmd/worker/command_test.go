@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+func TestHandleCommand_TaskError(t *testing.T) {
+	w, q, gh := newTestWorker(t, "", "", newGatedAgent())
+	gh.issueErr = errors.New("github api error: status 502")
+
+	msg := &sqs.Message{
+		Type:          sqs.TypeCommand,
+		IssueNumber:   1,
+		Repository:    "owner/repo",
+		Command:       sqs.CommandRetry,
+		CommentAuthor: "alice",
+	}
+	q.Inject(msg)
+	received, _ := q.Receive(context.Background())
+
+	if err := w.handleCommand(context.Background(), received); err != nil {
+		t.Fatalf("handleCommand failed: %v", err)
+	}
+
+	// The user hears about the failure and the command is not left in flight
+	if len(gh.comments) != 1 || !strings.Contains(gh.comments[0], "status 502") {
+		t.Errorf("expected a reply with the error, got %q", gh.comments)
+	}
+	if deleted, _ := q.counts(); deleted != 1 {
+		t.Errorf("expected the command message deleted, got %d", deleted)
+	}
+}
+
+func TestCancel_TaskOnAnotherWorker(t *testing.T) {
+	a := newGatedAgent()
+	w, q, gh := newTestWorker(t, "  progress_interval_seconds: 1", "", a)
+	// The worker that receives the cancel command runs nothing
+	other, _, _ := newTestWorker(t, "", "", newGatedAgent())
+	other.github = gh
+
+	// A cancel label left over from an earlier run is cleared on start
+	gh.UpdateLabels(context.Background(), "owner/repo", 1, []string{sqs.LabelCancel}, nil)
+	inject(t, q, 1)
+	stop := startWorker(t, w)
+	waitFor(t, "the task to run", func() bool { return a.running() == 1 })
+	if gh.hasLabel(1, sqs.LabelCancel) {
+		t.Fatal("expected the stale cancel label to be removed")
+	}
+
+	reply := other.cancelTasks(context.Background(), &sqs.Message{IssueNumber: 1, Repository: "owner/repo", Command: sqs.CommandCancel})
+	if !strings.Contains(reply, sqs.LabelCancel) {
+		t.Errorf("unexpected reply: %q", reply)
+	}
+
+	waitFor(t, "the task to be cancelled", func() bool { deleted, _ := q.counts(); return deleted == 1 })
+	stop(false)
+
+	if _, prs := gh.state(); len(prs) != 0 {
+		t.Errorf("expected no PR from a cancelled task, got %v", prs)
+	}
+	if gh.hasLabel(1, sqs.LabelCancel) {
+		t.Error("expected the cancel label to be removed after cancelling")
+	}
+	found := false
+	for _, c := range gh.comments {
+		found = found || strings.Contains(c, "キャンセルしました")
+	}
+	if !found {
+		t.Errorf("expected a cancellation comment, got %q", gh.comments)
+	}
+}
+
+func TestWatchCancel_NoInterval(t *testing.T) {
+	w, _, _ := newTestWorker(t, "  progress_interval_seconds: -1", "", newGatedAgent())
+	w.config.SQS.HeartbeatInterval = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Returns instead of panicking on a zero ticker interval
+	w.watchCancel(ctx, &sqs.Message{IssueNumber: 1, Repository: "owner/repo"}, func(error) {})
+}
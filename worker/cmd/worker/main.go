@@ -10,11 +10,17 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/OkadaSatoshi/codingworker/worker/internal/agent"
 	"github.com/OkadaSatoshi/codingworker/worker/internal/aider"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/artifact"
 	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
 	"github.com/OkadaSatoshi/codingworker/worker/internal/github"
-	"github.com/OkadaSatoshi/codingworker/worker/internal/retry"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/journal"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/ollama"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/queue"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
 	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
 )
 
@@ -27,11 +33,11 @@ var (
 func main() {
 	flag.Parse()
 
-	// Setup structured logging
+	// Setup structured logging (secrets are scrubbed from every record)
 	level := parseLogLevel(*logLevel)
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	logger := slog.New(redact.NewHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: level,
-	}))
+	})))
 	slog.SetDefault(logger)
 
 	// Load configuration
@@ -42,13 +48,63 @@ func main() {
 	}
 
 	// Initialize components
-	sqsClient := sqs.NewClient(cfg.SQS)
-	aiderRunner := aider.NewRunner(cfg.Aider)
-	ghClient := github.NewClient(cfg.GitHub)
+	taskQueue, err := queue.New(cfg.Queue, cfg.SQS)
+	if err != nil {
+		slog.Error("Failed to create queue", "error", err)
+		os.Exit(1)
+	}
+	aiderRunner := aider.NewRunner(cfg.Aider, agent.NewDirect(cfg.Agent.Direct))
+	if !aiderRunner.HasBackend(cfg.Agent.Backend) {
+		slog.Error("Unknown agent backend", "backend", cfg.Agent.Backend)
+		os.Exit(1)
+	}
+	for label, backend := range cfg.Agent.LabelBackends {
+		if !aiderRunner.HasBackend(backend) {
+			slog.Error("Unknown agent backend", "label", label, "backend", backend)
+			os.Exit(1)
+		}
+	}
+	if err := aiderRunner.CheckPrompts(); err != nil {
+		slog.Error("Invalid prompt template", "error", err)
+		os.Exit(1)
+	}
+	ghClient, err := github.NewClient(cfg.GitHub)
+	if err != nil {
+		slog.Error("Failed to create GitHub client", "error", err)
+		os.Exit(1)
+	}
+
+	// Check Ollama up front: missing models are a configuration error, while
+	// an unreachable server only pauses consumption until it comes back
+	var ollamaClient *ollama.Client
+	if models := ollama.Models(cfg.Aider.Models); len(models) > 0 {
+		ollamaClient = ollama.NewClient(cfg.Ollama, models)
+		var missing *ollama.MissingModelsError
+		switch err := ollamaClient.Ready(context.Background()); {
+		case errors.As(err, &missing):
+			slog.Error("Ollama models not available (pull them or set ollama.auto_pull)", "models", missing.Models)
+			os.Exit(1)
+		case err != nil:
+			slog.Warn("Ollama is not ready at startup", "base_url", cfg.Ollama.BaseURL, "error", err)
+		default:
+			slog.Info("Ollama is ready", "base_url", cfg.Ollama.BaseURL, "models", models)
+		}
+	}
+
+	// Open the job journal and settle jobs a previous run left behind
+	jobJournal, err := journal.Open(cfg.Worker.JournalPath, time.Duration(cfg.Worker.JournalRetention)*time.Hour)
+	if err != nil {
+		slog.Error("Failed to open job journal", "path", cfg.Worker.JournalPath, "error", err)
+		os.Exit(1)
+	}
+	defer jobJournal.Close()
+
+	// Drop task artifacts past their retention
+	artifact.Prune(cfg.Worker.ArtifactDir, time.Duration(cfg.Worker.ArtifactRetention)*time.Hour)
 
 	// Inject test message if provided
 	if *testMessage != "" {
-		if err := injectTestMessage(sqsClient, *testMessage); err != nil {
+		if err := injectTestMessage(taskQueue, *testMessage); err != nil {
 			slog.Error("Failed to inject test message", "error", err)
 			os.Exit(1)
 		}
@@ -56,33 +112,46 @@ func main() {
 
 	// Create worker
 	w := &Worker{
-		sqs:    sqsClient,
-		aider:  aiderRunner,
-		github: ghClient,
-		config: cfg,
+		queue:   taskQueue,
+		aider:   aiderRunner,
+		github:  ghClient,
+		ollama:  ollamaClient,
+		journal: jobJournal,
+		config:  cfg,
 	}
+	w.recoverJobs()
 
-	// Setup graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Setup two-phase graceful shutdown:
+	// 1st signal stops receiving and drains in-flight tasks,
+	// 2nd signal aborts them.
+	ctx, stopReceiving := context.WithCancel(context.Background())
+	defer stopReceiving()
+	abortCtx, abortTasks := context.WithCancel(context.Background())
+	defer abortTasks()
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
 		sig := <-sigCh
-		slog.Info("Received signal, shutting down", "signal", sig)
-		cancel()
+		slog.Info("Received signal, finishing in-flight tasks (send again to abort)",
+			"signal", sig,
+			"grace_period_seconds", cfg.Worker.DrainTimeout,
+		)
+		stopReceiving()
+
+		sig = <-sigCh
+		slog.Warn("Received second signal, aborting in-flight tasks", "signal", sig)
+		abortTasks()
 	}()
 
 	// Start worker
 	slog.Info("Starting CodingWorker",
 		"config", *configPath,
-		"mock_mode", cfg.SQS.UseMock,
-		"queue_length", sqsClient.QueueLength(),
+		"queue_backend", cfg.Queue.Backend,
 	)
 
-	if err := w.Run(ctx); err != nil {
+	if err := w.Run(ctx, abortCtx); err != nil {
 		slog.Error("Worker error", "error", err)
 		os.Exit(1)
 	}
@@ -105,7 +174,12 @@ func parseLogLevel(level string) slog.Level {
 	}
 }
 
-func injectTestMessage(client *sqs.Client, path string) error {
+func injectTestMessage(q queue.Queue, path string) error {
+	injector, ok := q.(queue.Injector)
+	if !ok {
+		return fmt.Errorf("queue backend does not accept test messages")
+	}
+
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("failed to read test message file: %w", err)
@@ -116,131 +190,5 @@ func injectTestMessage(client *sqs.Client, path string) error {
 		return fmt.Errorf("failed to parse test message: %w", err)
 	}
 
-	return client.InjectTestMessage(&msg)
-}
-
-type Worker struct {
-	sqs    *sqs.Client
-	aider  *aider.Runner
-	github *github.Client
-	config *config.Config
-}
-
-func (w *Worker) Run(ctx context.Context) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			if err := w.processNextMessage(ctx); err != nil {
-				slog.Error("Failed to process message", "error", err)
-			}
-		}
-	}
-}
-
-func (w *Worker) processNextMessage(ctx context.Context) error {
-	// 1. Receive message from SQS
-	msg, err := w.sqs.ReceiveMessage(ctx)
-	if err != nil {
-		return err
-	}
-	if msg == nil {
-		return nil // No message available
-	}
-
-	slog.Info("Processing task",
-		"issue_number", msg.IssueNumber,
-		"repository", msg.Repository,
-		"title", msg.Title,
-	)
-
-	// Execute with retry policy (uses config max_retries, fixed 10s backoff)
-	policy := retry.NewPolicy(w.config.Worker.MaxRetries)
-	var prURL string
-
-	result := policy.Do(ctx, func() error {
-		var err error
-		prURL, err = w.processTask(ctx, msg)
-		return err
-	})
-
-	if result.LastErr != nil {
-		slog.Error("Task failed after retries",
-			"issue_number", msg.IssueNumber,
-			"attempts", result.Attempts,
-			"error", result.LastErr,
-		)
-
-		// Post failure comment to Issue
-		comment := w.buildFailureComment(result.LastErr, result.Attempts)
-		if err := w.github.AddComment(ctx, msg.Repository, msg.IssueNumber, comment); err != nil {
-			slog.Error("Failed to post failure comment", "error", err)
-		}
-
-		// Delete message from SQS (don't retry indefinitely)
-		if err := w.sqs.DeleteMessage(ctx, msg.ReceiptHandle); err != nil {
-			slog.Error("Failed to delete message after failure", "error", err)
-		}
-
-		return result.LastErr
-	}
-
-	slog.Info("PR created", "url", prURL)
-
-	// Delete message from SQS
-	if err := w.sqs.DeleteMessage(ctx, msg.ReceiptHandle); err != nil {
-		return fmt.Errorf("message deletion failed: %w", err)
-	}
-
-	slog.Info("Task completed successfully",
-		"issue_number", msg.IssueNumber,
-		"pr_url", prURL,
-		"attempts", result.Attempts,
-	)
-
-	return nil
-}
-
-// buildFailureComment creates a comment body for failed tasks
-func (w *Worker) buildFailureComment(err error, attempts int) string {
-	return fmt.Sprintf(`## ⚠️ CodingWorker: タスク処理に失敗しました
-
-**試行回数**: %d
-**エラー内容**:
-`+"```"+`
-%v
-`+"```"+`
-
----
-このコメントは CodingWorker によって自動生成されました。
-`, attempts, err)
-}
-
-// processTask executes the actual work (clone, aider, push, PR)
-func (w *Worker) processTask(ctx context.Context, msg *sqs.Message) (string, error) {
-	// 2. Clone repository and create branch
-	workDir, err := w.github.CloneAndBranch(ctx, msg.Repository, msg.IssueNumber)
-	if err != nil {
-		return "", fmt.Errorf("clone failed: %w", err)
-	}
-	defer os.RemoveAll(workDir)
-
-	// 3. Run Aider to generate code (2-pass: implementation + tests)
-	if err := w.aider.RunWithTests(ctx, workDir, msg.Title, msg.Body); err != nil {
-		// Timeout errors are transient (can retry with fresh clone)
-		if errors.Is(err, context.DeadlineExceeded) {
-			return "", &retry.TransientError{Err: fmt.Errorf("aider timed out: %w", err)}
-		}
-		// Other Aider failures (after internal fix attempts) are permanent
-		return "", &retry.PermanentError{Err: fmt.Errorf("aider failed: %w", err)}
-	}
-
-	// 4. Push and create PR
-	prURL, err := w.github.PushAndCreatePR(ctx, workDir, msg)
-	if err != nil {
-		return "", fmt.Errorf("pr creation failed: %w", err)
-	}
-
-	return prURL, nil
+	return injector.Inject(&msg)
 }
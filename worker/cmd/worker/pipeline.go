@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/agent"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/aider"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/github"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/journal"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/retry"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// Pipeline stages. Each stage is retried on its own, so a transient failure
+// in push or PR creation doesn't throw away the generated code.
+const (
+	stageClone       = "clone"
+	stageGenerate    = "generate"
+	stagePush        = "push"
+	stagePullRequest = "pull_request"
+	stageReply       = "reply" // Follow-ups: report the new commit on the PR
+)
+
+// checkpoint holds the output of the stages completed so far. It is saved to
+// the job journal after every stage so an interrupted task can resume.
+type checkpoint struct {
+	stage    string         // Last completed stage
+	attempts map[string]int // Attempts per stage
+	workDir  string
+	base     string        // Commit after clone; generation restarts from it
+	result   *aider.Result // Set once code generation succeeded
+	branch   string        // Set once the branch is pushed
+	prURL    string        // Follow-ups: URL of the reply comment
+}
+
+// stageError reports the stage that failed a task
+type stageError struct {
+	Stage    string
+	Attempts int
+	Err      error
+}
+
+func (e *stageError) Error() string {
+	return fmt.Sprintf("%s failed after %d attempt(s): %v", e.Stage, e.Attempts, e.Err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.Err
+}
+
+// processTask executes the pipeline (clone, generate, push, PR), retrying
+// each stage against the checkpoint left by the previous ones. Follow-up
+// messages run the same stages against the existing PR branch.
+func (w *Worker) processTask(ctx context.Context, msg *sqs.Message, progress *progressComment) (string, error) {
+	cp := w.resume(msg)
+	defer func() {
+		// Keep the clone of a task aborted by shutdown so it can resume
+		if cp.workDir != "" && !aborted(ctx) {
+			os.RemoveAll(cp.workDir)
+		}
+	}()
+
+	type stage struct {
+		name       string
+		maxRetries int
+		run        func() error
+	}
+	retries := w.config.Worker.StageRetries
+	stages := []stage{
+		{stageClone, *retries.Clone, func() error { return w.clone(ctx, msg, cp) }},
+		{stageGenerate, *retries.Generate, func() error { return w.generate(ctx, msg, cp) }},
+		{stagePush, *retries.Push, func() error { return w.push(ctx, msg, cp) }},
+		{stagePullRequest, *retries.PullRequest, func() error { return w.createPR(ctx, msg, cp) }},
+	}
+	if msg.IsFollowUp() {
+		// Add a commit to the PR's branch instead of opening a new PR
+		stages = []stage{
+			{stageClone, *retries.Clone, func() error { return w.checkoutBranch(ctx, msg, cp) }},
+			{stageGenerate, *retries.Generate, func() error { return w.generateFollowUp(ctx, msg, cp) }},
+			{stagePush, *retries.Push, func() error { return w.push(ctx, msg, cp) }},
+			{stageReply, *retries.PullRequest, func() error { return w.reply(ctx, msg, cp) }},
+		}
+	}
+
+	// Skip the stages a resumed job already completed
+	start := 0
+	for i, stage := range stages {
+		if stage.name == cp.stage {
+			start = i + 1
+		}
+	}
+
+	names := make([]string, len(stages))
+	for i, stage := range stages {
+		names[i] = stage.name
+	}
+	progress.plan(names, start)
+
+	for _, stage := range stages[start:] {
+		policy := retryPolicy(w.config.Worker)
+		policy.MaxRetries = stage.maxRetries
+
+		attempt := 0
+		result := policy.Do(ctx, func() error {
+			attempt++
+			progress.stageStarted(attempt)
+			return stage.run()
+		})
+		cp.attempts[stage.name] += result.Attempts
+		if result.LastErr != nil {
+			err := &stageError{Stage: stage.name, Attempts: result.Attempts, Err: result.LastErr}
+			switch {
+			case cancelled(ctx):
+				w.record(msg, cp, journal.StatusCancelled, err)
+			case ctx.Err() == nil:
+				w.record(msg, cp, journal.StatusFailed, err)
+			}
+			return "", err
+		}
+		if result.Attempts > 1 {
+			slog.Info("Stage succeeded after retry",
+				"issue_number", msg.IssueNumber,
+				"stage", stage.name,
+				"attempts", result.Attempts,
+			)
+		}
+		cp.stage = stage.name
+		progress.stageDone()
+		w.record(msg, cp, journal.StatusRunning, nil)
+	}
+
+	w.record(msg, cp, journal.StatusDone, nil)
+	return cp.prURL, nil
+}
+
+// clone clones the repository and creates the task branch
+func (w *Worker) clone(ctx context.Context, msg *sqs.Message, cp *checkpoint) error {
+	workDir, err := w.github.CloneAndBranch(ctx, msg.Repository, msg.IssueNumber)
+	if err != nil {
+		return fmt.Errorf("clone failed: %w", err)
+	}
+	return setWorkDir(ctx, cp, workDir)
+}
+
+// checkoutBranch clones the repository at the branch of the PR under review
+func (w *Worker) checkoutBranch(ctx context.Context, msg *sqs.Message, cp *checkpoint) error {
+	workDir, err := w.github.CheckoutExistingBranch(ctx, msg.Repository, msg.IssueNumber, msg.BranchName)
+	if err != nil {
+		return fmt.Errorf("clone failed: %w", err)
+	}
+	return setWorkDir(ctx, cp, workDir)
+}
+
+// setWorkDir records a fresh clone and the commit generation starts from
+func setWorkDir(ctx context.Context, cp *checkpoint, workDir string) error {
+	base, err := agent.Head(ctx, workDir)
+	if err != nil {
+		os.RemoveAll(workDir)
+		return err
+	}
+	cp.workDir, cp.base = workDir, base
+	return nil
+}
+
+// generate runs the coding agent (2-pass: implementation + tests)
+func (w *Worker) generate(ctx context.Context, msg *sqs.Message, cp *checkpoint) error {
+	// Start from the clone: a failed attempt may have left partial edits
+	if err := agent.Reset(ctx, cp.workDir, cp.base); err != nil {
+		return err
+	}
+
+	// Agent backend from the task labels, falling back to agent.backend
+	runner, err := w.runner(msg)
+	if err != nil {
+		return err
+	}
+	backend := agent.SelectBackend(w.config.Agent, msg.Labels)
+	result, err := runner.RunWithTests(ctx, backend, cp.workDir, task(msg))
+	if err != nil {
+		return classifyAgentError(err)
+	}
+	cp.result = result
+	return nil
+}
+
+// generateFollowUp runs the coding agent on the review feedback, then the
+// full verification loop
+func (w *Worker) generateFollowUp(ctx context.Context, msg *sqs.Message, cp *checkpoint) error {
+	if err := agent.Reset(ctx, cp.workDir, cp.base); err != nil {
+		return err
+	}
+
+	// The workflow forwards feedback only from users with write access;
+	// check again, and filter the line comments the same way, so nobody
+	// else's text reaches the prompt
+	writers := make(map[string]bool)
+	allowed, err := w.canWrite(ctx, msg.Repository, msg.CommentAuthor, writers)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &retry.PermanentError{Err: fmt.Errorf("%q does not have write access to %s", msg.CommentAuthor, msg.Repository)}
+	}
+
+	listed, err := w.github.ListReviewComments(ctx, msg.Repository, msg.PRNumber, msg.ReviewID)
+	if err != nil {
+		return err
+	}
+	var comments []github.ReviewComment
+	for _, comment := range listed {
+		if isBot(comment.User.Login) {
+			continue
+		}
+		allowed, err := w.canWrite(ctx, msg.Repository, comment.User.Login, writers)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			slog.Info("Skipping review comment from a user without write access",
+				"pr_number", msg.PRNumber,
+				"comment_id", comment.ID,
+				"author", comment.User.Login,
+			)
+			continue
+		}
+		comments = append(comments, comment)
+	}
+	prompt := buildReviewPrompt(cp.workDir, msg, comments)
+	if prompt == "" {
+		return &retry.PermanentError{Err: fmt.Errorf("no review feedback to address")}
+	}
+
+	backend := agent.SelectBackend(w.config.Agent, msg.Labels)
+	result, err := w.aider.RunFollowUp(ctx, backend, cp.workDir, task(msg), prompt)
+	if err != nil {
+		return classifyAgentError(err)
+	}
+	cp.result = result
+	return nil
+}
+
+// canWrite reports whether user has write access to repository, asking
+// GitHub once per user; known caches the answers
+func (w *Worker) canWrite(ctx context.Context, repository, user string, known map[string]bool) (bool, error) {
+	if allowed, ok := known[user]; ok {
+		return allowed, nil
+	}
+	allowed, err := w.github.CanWrite(ctx, repository, user)
+	if err != nil {
+		return false, err
+	}
+	known[user] = allowed
+	return allowed, nil
+}
+
+// task describes the issue of msg for prompt templates
+func task(msg *sqs.Message) aider.Task {
+	return aider.Task{Title: msg.Title, Body: msg.Body, Labels: msg.Labels, Repository: msg.Repository}
+}
+
+// runner returns the agent runner for msg, restricted to the model chosen
+// with /codingworker model
+func (w *Worker) runner(msg *sqs.Message) (*aider.Runner, error) {
+	if msg.Model == "" {
+		return w.aider, nil
+	}
+	runner, err := w.aider.WithModel(msg.Model)
+	if err != nil {
+		return nil, &retry.PermanentError{Err: err}
+	}
+	return runner, nil
+}
+
+// classifyAgentError marks agent failures for the stage retry policy
+func classifyAgentError(err error) error {
+	// Timeout errors are transient (can retry from the clone)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &retry.TransientError{Err: fmt.Errorf("aider timed out: %w", err)}
+	}
+	// Other Aider failures (after internal fix attempts) are permanent
+	return &retry.PermanentError{Err: fmt.Errorf("aider failed: %w", err)}
+}
+
+// push commits the generated code and pushes the task branch
+func (w *Worker) push(ctx context.Context, msg *sqs.Message, cp *checkpoint) error {
+	branch, err := w.github.Push(ctx, cp.workDir, cp.base, msg)
+	if err != nil {
+		return fmt.Errorf("push failed: %w", err)
+	}
+	cp.branch = branch
+	return nil
+}
+
+// createPR opens the pull request for the pushed branch
+func (w *Worker) createPR(ctx context.Context, msg *sqs.Message, cp *checkpoint) error {
+	prURL, err := w.github.CreatePR(ctx, cp.branch, msg, prDetails(cp.result))
+	if err != nil {
+		return fmt.Errorf("pr creation failed: %w", err)
+	}
+	cp.prURL = prURL
+	return nil
+}
+
+// reply reports the follow-up commit on the pull request
+func (w *Worker) reply(ctx context.Context, msg *sqs.Message, cp *checkpoint) error {
+	commentURL, err := w.github.ReplyToReview(ctx, msg, prDetails(cp.result))
+	if err != nil {
+		return fmt.Errorf("reply failed: %w", err)
+	}
+	cp.prURL = commentURL
+	return nil
+}
+
+// retryPolicy creates the stage retry policy from the worker config
+func retryPolicy(cfg config.WorkerConfig) *retry.Policy {
+	return &retry.Policy{
+		MaxRetries:     cfg.MaxRetries,
+		InitialBackoff: time.Duration(cfg.Retry.InitialBackoff) * time.Second,
+		MaxBackoff:     time.Duration(cfg.Retry.MaxBackoff) * time.Second,
+		Multiplier:     cfg.Retry.Multiplier,
+		Jitter:         cfg.Retry.Jitter,
+	}
+}
+
+// prDetails maps the runner's result to the PR body's view of it. The
+// result is nil when a job resumed past generate without one in the journal;
+// the PR body then leaves the details empty.
+func prDetails(result *aider.Result) github.PRDetails {
+	if result == nil {
+		return github.PRDetails{}
+	}
+	details := github.PRDetails{
+		Agent:       result.Agent,
+		Model:       result.Model,
+		Models:      result.Models,
+		Profile:     result.Profile,
+		Invocations: result.Invocations,
+	}
+	for _, p := range result.Passes {
+		details.Passes = append(details.Passes, github.PassStats{
+			Pass:        prPass(p.Pass),
+			Invocations: p.Invocations,
+			FixAttempts: p.FixAttempts,
+		})
+	}
+	for _, step := range result.Steps {
+		details.Steps = append(details.Steps, github.StepResult{
+			Pass:     prPass(step.Pass),
+			Stage:    step.Stage,
+			Name:     step.Name,
+			Run:      step.Run,
+			Passed:   step.Passed,
+			Duration: step.Duration,
+		})
+	}
+	for _, f := range result.Files {
+		details.Files = append(details.Files, github.FileStat{
+			Path:    f.Path,
+			Added:   f.Added,
+			Deleted: f.Deleted,
+			Binary:  f.Binary,
+		})
+	}
+	return details
+}
+
+// prPass maps a runner pass to its name in the PR body
+func prPass(pass string) string {
+	switch pass {
+	case aider.PassImplementation:
+		return github.PassImplementation
+	case aider.PassTests:
+		return github.PassTests
+	case aider.PassFollowUp:
+		return github.PassFollowUp
+	default:
+		return pass
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/aider"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// Final states of a progress comment
+const (
+	progressDone      = "✅ CodingWorker: 完了しました"
+	progressFailed    = "❌ CodingWorker: 失敗しました"
+	progressCancelled = "🛑 CodingWorker: キャンセルされました"
+	progressAborted   = "⏸️ CodingWorker: 中断しました（再配信後に再開します）"
+)
+
+// stageLabels are the names shown for pipeline stages
+var stageLabels = map[string]string{
+	stageClone:       "clone",
+	stageGenerate:    "コード生成・検証",
+	stagePush:        "push",
+	stagePullRequest: "PR 作成",
+	stageReply:       "PR へ報告",
+}
+
+// progressComment is a comment on the issue (or PR, for follow-ups) that is
+// edited in place as the task runs. A nil *progressComment does nothing.
+type progressComment struct {
+	w       *Worker
+	msg     *sqs.Message
+	id      int64
+	started time.Time
+
+	mu      sync.Mutex
+	stages  []string // Pipeline stages, in order
+	done    int      // Number of completed stages
+	attempt int      // Attempt of the current stage
+	run     aider.Progress
+	final   string // Set when the task has ended
+
+	kick chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startProgress posts the progress comment and starts refreshing it every
+// worker.progress_interval_seconds. Returns nil when progress comments are
+// disabled or the comment could not be posted.
+func (w *Worker) startProgress(ctx context.Context, msg *sqs.Message) *progressComment {
+	interval := time.Duration(w.config.Worker.ProgressInterval) * time.Second
+	if interval <= 0 {
+		return nil
+	}
+
+	p := &progressComment{
+		w:       w,
+		msg:     msg,
+		started: time.Now(),
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	comment, err := w.github.CreateComment(ctx, msg.Repository, commentTarget(msg), p.render())
+	if err != nil {
+		slog.Error("Failed to post progress comment", "error", err)
+		return nil
+	}
+	p.id = comment.ID
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			case <-p.kick:
+			}
+			p.update()
+		}
+	}()
+	return p
+}
+
+// plan sets the pipeline stages; the first done of them were completed by
+// an earlier run
+func (p *progressComment) plan(stages []string, done int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.stages, p.done = stages, done
+	p.mu.Unlock()
+}
+
+// stageStarted records an attempt of the next stage and refreshes the comment
+func (p *progressComment) stageStarted(attempt int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.attempt = attempt
+	p.mu.Unlock()
+	p.refresh()
+}
+
+// stageDone records that the current stage completed
+func (p *progressComment) stageDone() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.done++
+	p.attempt = 0
+	p.mu.Unlock()
+}
+
+// report records the agent run's progress; it is an aider.ProgressFunc
+func (p *progressComment) report(progress aider.Progress) {
+	p.mu.Lock()
+	p.run = progress
+	p.mu.Unlock()
+	p.refresh()
+}
+
+// finish stops refreshing and edits the comment to show how the task ended
+func (p *progressComment) finish(final string) {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+
+	p.mu.Lock()
+	p.final = final
+	p.mu.Unlock()
+	p.update()
+}
+
+// refresh asks the refresh loop to edit the comment now
+func (p *progressComment) refresh() {
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+}
+
+// update edits the comment. It runs after the task's context may be
+// cancelled, so it uses its own timeout. Errors are logged only.
+func (p *progressComment) update() {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	if err := p.w.github.UpdateComment(ctx, p.msg.Repository, p.id, p.render()); err != nil {
+		slog.Warn("Failed to update progress comment", "error", err)
+	}
+}
+
+func (p *progressComment) render() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	if p.final != "" {
+		fmt.Fprintf(&b, "## %s\n\n", p.final)
+	} else {
+		b.WriteString("## ⏳ CodingWorker: 処理中\n\n")
+		if step := p.step(); step != "" {
+			fmt.Fprintf(&b, "**現在のステップ**: %s\n", step)
+		}
+	}
+	if p.run.Model != "" {
+		fmt.Fprintf(&b, "**モデル**: %s\n", p.run.Model)
+	}
+	fmt.Fprintf(&b, "**経過時間**: %s\n", time.Since(p.started).Round(time.Second))
+
+	if len(p.stages) > 0 {
+		b.WriteString("\n")
+	}
+	for i, stage := range p.stages {
+		mark := "⬜"
+		switch {
+		case i < p.done:
+			mark = "✅"
+		case i == p.done && p.final == "":
+			mark = "⏳"
+		case i == p.done && p.final == progressFailed:
+			mark = "❌"
+		case i == p.done:
+			mark = "⏹️"
+		}
+		fmt.Fprintf(&b, "- %s %s\n", mark, stageLabels[stage])
+	}
+	return b.String()
+}
+
+// step describes the current stage and, during generation, the agent's
+// pass and verification attempt. Called with mu held.
+func (p *progressComment) step() string {
+	if p.done >= len(p.stages) {
+		return ""
+	}
+	stage := p.stages[p.done]
+	step := stageLabels[stage]
+	if p.attempt > 1 {
+		step += fmt.Sprintf("（リトライ %d 回目）", p.attempt-1)
+	}
+	if stage != stageGenerate || p.run.Pass == "" {
+		return step
+	}
+
+	passes := map[string]string{
+		aider.PassImplementation: "Pass 1 (実装)",
+		aider.PassTests:          "Pass 2 (テスト作成)",
+		aider.PassFollowUp:       "レビュー対応",
+	}
+	steps := map[string]string{
+		aider.StepAgent: "エージェント実行中",
+		"build":         "ビルド検証",
+		"lint":          "Lint",
+		"test":          "テスト実行",
+	}
+	detail := passes[p.run.Pass] + ": " + steps[p.run.Step]
+	if p.run.Attempt > 0 {
+		detail += fmt.Sprintf(" %d/%d", p.run.Attempt, p.run.MaxAttempts)
+	}
+	return step + " — " + detail
+}
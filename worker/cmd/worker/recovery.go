@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/aider"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/journal"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// recoverJobs settles jobs interrupted by a crash or abort. Jobs whose clone
+// survived stay resumable until their message is redelivered; the rest are
+// abandoned. Leftover clones of this journal's other jobs are removed; the
+// clone directory may be shared, so clones the journal does not list are
+// left alone.
+func (w *Worker) recoverJobs() {
+	retention := time.Duration(w.config.Worker.JournalRetention) * time.Hour
+	owned := make(map[string]bool)
+
+	for _, job := range w.journal.Interrupted() {
+		if job.WorkDir != "" && time.Since(job.UpdatedAt) <= retention && dirExists(job.WorkDir) {
+			owned[filepath.Clean(job.WorkDir)] = true
+			slog.Info("Interrupted job will resume when its message is redelivered",
+				"job", job.Key,
+				"stage", job.Stage,
+				"work_dir", job.WorkDir,
+			)
+			continue
+		}
+
+		slog.Warn("Abandoning interrupted job", "job", job.Key, "stage", job.Stage)
+		if job.WorkDir != "" {
+			os.RemoveAll(job.WorkDir)
+		}
+		job.Status = journal.StatusAbandoned
+		if err := w.journal.Record(job); err != nil {
+			slog.Error("Failed to record job", "job", job.Key, "error", err)
+		}
+	}
+
+	for _, job := range w.journal.Jobs() {
+		if job.WorkDir == "" || owned[filepath.Clean(job.WorkDir)] || !dirExists(job.WorkDir) {
+			continue
+		}
+		slog.Info("Removing orphaned work directory", "job", job.Key, "work_dir", job.WorkDir)
+		if err := os.RemoveAll(job.WorkDir); err != nil {
+			slog.Error("Failed to remove orphaned work directory", "work_dir", job.WorkDir, "error", err)
+		}
+	}
+}
+
+// resume returns the checkpoint to continue a task from. Only the message
+// that started a job can resume it: a redelivery of a finished job skips
+// every stage (so no duplicate PR is opened), while an interrupted job for
+// an older message of the same issue is abandoned.
+func (w *Worker) resume(msg *sqs.Message) *checkpoint {
+	cp := &checkpoint{attempts: make(map[string]int)}
+	job, ok := w.journal.Get(jobKey(msg))
+	if !ok {
+		return cp
+	}
+
+	// A follow-up job belongs to one comment or review, whatever the message
+	sameMessage := job.CreatedAt == msg.CreatedAt || msg.IsFollowUp()
+	switch {
+	case job.Status == journal.StatusDone && sameMessage:
+		slog.Info("Task already completed, skipping redelivered message",
+			"job", job.Key,
+			"pr_url", job.PRURL,
+		)
+	case job.Status != journal.StatusRunning:
+		return cp
+	case !sameMessage || !dirExists(job.WorkDir):
+		slog.Warn("Abandoning interrupted job", "job", job.Key, "stage", job.Stage)
+		if job.WorkDir != "" {
+			os.RemoveAll(job.WorkDir)
+		}
+		job.Status = journal.StatusAbandoned
+		if err := w.journal.Record(job); err != nil {
+			slog.Error("Failed to record job", "job", job.Key, "error", err)
+		}
+		return cp
+	default:
+		slog.Info("Resuming interrupted job",
+			"job", job.Key,
+			"completed_stage", job.Stage,
+			"work_dir", job.WorkDir,
+		)
+	}
+
+	cp.stage = job.Stage
+	for stage, n := range job.Attempts {
+		cp.attempts[stage] = n
+	}
+	cp.workDir, cp.base, cp.branch, cp.prURL = job.WorkDir, job.Base, job.Branch, job.PRURL
+	if len(job.Result) > 0 {
+		cp.result = &aider.Result{}
+		if err := json.Unmarshal(job.Result, cp.result); err != nil {
+			// Without the result the PR body can't be built: regenerate
+			slog.Warn("Failed to restore generation result", "job", job.Key, "error", err)
+			cp.result = nil
+			if !job.Finished() {
+				cp.stage = stageClone
+			}
+		}
+	}
+	return cp
+}
+
+// record saves the task's checkpoint to the journal. Journal errors are
+// logged but never fail the task.
+func (w *Worker) record(msg *sqs.Message, cp *checkpoint, status string, taskErr error) {
+	job := journal.Job{
+		Key:         jobKey(msg),
+		Repository:  msg.Repository,
+		IssueNumber: msg.IssueNumber,
+		CreatedAt:   msg.CreatedAt,
+		Status:      status,
+		Stage:       cp.stage,
+		Attempts:    cp.attempts,
+		WorkDir:     cp.workDir,
+		Base:        cp.base,
+		Branch:      cp.branch,
+		PRURL:       cp.prURL,
+	}
+	if cp.result != nil {
+		job.Model = cp.result.Model
+		if data, err := json.Marshal(cp.result); err == nil {
+			job.Result = data
+		}
+	}
+	if taskErr != nil {
+		job.Error = redact.Error(taskErr).Error()
+	}
+
+	if err := w.journal.Record(job); err != nil {
+		slog.Error("Failed to record job", "job", job.Key, "error", err)
+	}
+}
+
+// jobKey returns the journal key of the task for msg
+func jobKey(msg *sqs.Message) string {
+	if msg.IsFollowUp() {
+		trigger := msg.CommentID
+		if trigger == 0 {
+			trigger = msg.ReviewID
+		}
+		return journal.FollowUpKey(msg.Repository, msg.PRNumber, trigger)
+	}
+	return journal.Key(msg.Repository, msg.IssueNumber)
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
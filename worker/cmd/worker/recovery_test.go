@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/journal"
+)
+
+func TestRecoverJobs_SharedCloneDir(t *testing.T) {
+	w, _, gh := newTestWorker(t, "", "", newGatedAgent())
+	dir := func(name string) string {
+		path := filepath.Join(gh.baseDir, name)
+		os.MkdirAll(path, 0755)
+		return path
+	}
+	resumable := dir("issue-1-a")
+	leftover := dir("issue-2-b")
+	foreign := dir("issue-3-c") // Another worker's live clone
+
+	now := time.Now()
+	w.journal.Record(journal.Job{Key: journal.Key("owner/repo", 1), Status: journal.StatusRunning, WorkDir: resumable, UpdatedAt: now})
+	w.journal.Record(journal.Job{Key: journal.Key("owner/repo", 2), Status: journal.StatusDone, WorkDir: leftover, UpdatedAt: now})
+
+	w.recoverJobs()
+
+	for path, want := range map[string]bool{resumable: true, leftover: false, foreign: true} {
+		if dirExists(path) != want {
+			t.Errorf("%s: exists = %v, want %v", filepath.Base(path), !want, want)
+		}
+	}
+}
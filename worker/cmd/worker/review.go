@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/github"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// fixCommand is the PR comment prefix that requests a follow-up run
+const fixCommand = "/codingworker fix"
+
+// reviewContextLines is the number of lines shown on each side of a
+// commented line
+const reviewContextLines = 5
+
+// isBot reports whether a GitHub login belongs to a bot. Bot comments never
+// trigger or feed a follow-up, so the worker can't loop on its own output.
+func isBot(login string) bool {
+	return strings.HasSuffix(login, "[bot]")
+}
+
+// buildReviewPrompt turns the review feedback on a PR into an agent prompt:
+// the text of the triggering comment or review, then each line comment with
+// the code around it. The caller filters the comments by author. Returns ""
+// when there is nothing to address.
+func buildReviewPrompt(workDir string, msg *sqs.Message, comments []github.ReviewComment) string {
+	var b strings.Builder
+
+	request := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(msg.Body), fixCommand))
+	if request != "" {
+		fmt.Fprintf(&b, "## Reviewer request\n%s\n", request)
+	}
+
+	// Snippets are read through the root so a commented symlink can't put a
+	// host file in the prompt
+	root, err := os.OpenRoot(workDir)
+	if err == nil {
+		defer root.Close()
+	}
+	for _, comment := range comments {
+		fmt.Fprintf(&b, "\n## %s:%d\n%s\n", comment.Path, comment.Line, strings.TrimSpace(comment.Body))
+		if snippet := fileContext(root, comment.Path, comment.Line); snippet != "" {
+			fmt.Fprintf(&b, "\n```\n%s```\n", snippet)
+		}
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+	return "Address the following review feedback on this pull request. " +
+		"Change only what the feedback asks for.\n\n" + strings.TrimLeft(b.String(), "\n")
+}
+
+// fileContext returns the lines around line in a file of the clone, numbered
+// and with the commented line marked. Returns "" if the file can't be read
+// or is outside the clone.
+func fileContext(root *os.Root, path string, line int) string {
+	if root == nil || !filepath.IsLocal(path) {
+		return ""
+	}
+	data, err := root.ReadFile(filepath.FromSlash(path))
+	if err != nil {
+		return ""
+	}
+
+	lines := strings.Split(string(data), "\n")
+	first := max(line-reviewContextLines, 1)
+	last := min(line+reviewContextLines, len(lines))
+
+	var b strings.Builder
+	for n := first; n <= last; n++ {
+		marker := " "
+		if n == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s%4d | %s\n", marker, n, lines[n-1])
+	}
+	return b.String()
+}
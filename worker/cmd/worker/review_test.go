@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/github"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+func TestBuildReviewPrompt_SkipsSymlinkEscape(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "credentials")
+	os.WriteFile(secret, []byte("aws_secret_access_key = hunter2\n"), 0600)
+
+	workDir := t.TempDir()
+	os.WriteFile(filepath.Join(workDir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	if err := os.Symlink(secret, filepath.Join(workDir, "notes.txt")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	comments := []github.ReviewComment{
+		{Path: "main.go", Line: 3, Body: "log something"},
+		{Path: "notes.txt", Line: 1, Body: "fix this line"},
+	}
+	prompt := buildReviewPrompt(workDir, &sqs.Message{Body: "/codingworker fix"}, comments)
+
+	if !strings.Contains(prompt, ">   3 | func main() {}") {
+		t.Errorf("prompt is missing the main.go snippet:\n%s", prompt)
+	}
+	if strings.Contains(prompt, "hunter2") {
+		t.Errorf("prompt contains a file outside the clone:\n%s", prompt)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/aider"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/artifact"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/github"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/journal"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/ollama"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/queue"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// gitHub is the part of *github.Client the worker uses
+type gitHub interface {
+	CloneAndBranch(ctx context.Context, repository string, issueNumber int) (string, error)
+	CheckoutExistingBranch(ctx context.Context, repository string, issueNumber int, branchName string) (string, error)
+	Push(ctx context.Context, workDir, base string, msg *sqs.Message) (string, error)
+	CreatePR(ctx context.Context, branchName string, msg *sqs.Message, details github.PRDetails) (string, error)
+	ReplyToReview(ctx context.Context, msg *sqs.Message, details github.PRDetails) (string, error)
+	GetIssue(ctx context.Context, repository string, number int) (*github.Issue, error)
+	CanWrite(ctx context.Context, repository, user string) (bool, error)
+	ListReviewComments(ctx context.Context, repository string, number int, reviewID int64) ([]github.ReviewComment, error)
+	AddComment(ctx context.Context, repository string, issueNumber int, body string) error
+	CreateComment(ctx context.Context, repository string, issueNumber int, body string) (*github.IssueComment, error)
+	UpdateComment(ctx context.Context, repository string, commentID int64, body string) error
+	UpdateLabels(ctx context.Context, repository string, issueNumber int, add, remove []string) error
+}
+
+var _ gitHub = (*github.Client)(nil)
+
+// Worker receives tasks from the queue and processes them
+type Worker struct {
+	queue   queue.Queue
+	aider   *aider.Runner
+	github  gitHub
+	ollama  *ollama.Client // nil when no Ollama models are configured
+	journal *journal.Journal
+	config  *config.Config
+
+	mu      sync.Mutex
+	running map[*runningTask]struct{} // Tasks in progress, for commands
+}
+
+// Run receives and processes messages with up to worker.concurrency tasks in
+// flight. Shutdown is two-phase: when ctx is cancelled it stops receiving and
+// lets in-flight tasks finish until the drain deadline; tasks are aborted when
+// abortCtx is cancelled or the deadline passes.
+func (w *Worker) Run(ctx, abortCtx context.Context) error {
+	taskCtx, cancelTasks := context.WithCancel(abortCtx)
+	defer cancelTasks()
+
+	slots := make(chan struct{}, w.config.Worker.Concurrency)
+	// One more message may be received while every slot is busy, so
+	// commands (e.g. cancel) still arrive during long tasks
+	control := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+
+	for ctx.Err() == nil {
+		// Wait for a free slot before receiving
+		slot := acquireSlot(ctx, slots, control)
+		if slot == nil {
+			continue
+		}
+
+		// Leave messages in the queue while Ollama can't serve them
+		if !w.waitForOllama(ctx) {
+			<-slot
+			continue
+		}
+
+		// 1. Receive message from the queue
+		msg, err := w.queue.Receive(ctx)
+		if err != nil || msg == nil {
+			<-slot
+			if err != nil && ctx.Err() == nil {
+				slog.Error("Failed to receive message", "error", err)
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// A task received on the control slot holds it until a task
+			// slot frees, so at most one extra task waits on this worker
+			if slot == control && needsSlot(msg) {
+				ok := w.waitForSlot(ctx, taskCtx, msg, slots)
+				<-control
+				if !ok {
+					return
+				}
+				slot = slots
+			}
+			defer func() { <-slot }()
+
+			if err := w.processMessage(taskCtx, msg); err != nil {
+				slog.Error("Failed to process message", "issue_number", msg.IssueNumber, "error", err)
+			}
+		}()
+	}
+
+	w.drain(&wg, cancelTasks)
+	return nil
+}
+
+// acquireSlot takes a free task slot, or the control slot when every task
+// slot is busy. Returns nil if ctx is cancelled.
+func acquireSlot(ctx context.Context, slots, control chan struct{}) chan struct{} {
+	select {
+	case slots <- struct{}{}:
+		return slots
+	default:
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case slots <- struct{}{}:
+		return slots
+	case control <- struct{}{}:
+		return control
+	}
+}
+
+// waitForSlot holds a task received while every slot was busy until a slot
+// frees, keeping its lease. On shutdown the message is released for
+// redelivery and false is returned.
+func (w *Worker) waitForSlot(ctx, taskCtx context.Context, msg *sqs.Message, slots chan struct{}) bool {
+	slog.Info("All task slots busy, holding task until one frees", "issue_number", msg.IssueNumber)
+	heartbeat := queue.StartHeartbeat(taskCtx, w.queue, msg.ReceiptHandle,
+		time.Duration(w.config.SQS.HeartbeatInterval)*time.Second,
+		time.Duration(w.config.SQS.VisibilityTimeout)*time.Second,
+	)
+	defer heartbeat.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+	}
+
+	heartbeat.Stop()
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(taskCtx), releaseTimeout)
+	defer cancel()
+	if err := w.queue.Nack(releaseCtx, msg.ReceiptHandle); err != nil {
+		slog.Error("Failed to release held message", "error", err)
+	}
+	return false
+}
+
+// waitForOllama blocks while Ollama is unreachable or missing models, so
+// tasks are not received only to fail. Returns false if ctx is cancelled.
+func (w *Worker) waitForOllama(ctx context.Context) bool {
+	if w.ollama == nil {
+		return true
+	}
+
+	interval := time.Duration(w.config.Ollama.HealthCheckInterval) * time.Second
+	paused := false
+	for {
+		err := w.ollama.Ready(ctx)
+		if err == nil {
+			if paused {
+				slog.Info("Ollama is ready, resuming message consumption")
+			}
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		if !paused {
+			slog.Warn("Ollama is not ready, pausing message consumption",
+				"error", err,
+				"retry_interval_seconds", w.config.Ollama.HealthCheckInterval,
+			)
+			paused = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(interval):
+		}
+	}
+}
+
+// drain waits for in-flight tasks, cancelling them once the drain deadline passes
+func (w *Worker) drain(wg *sync.WaitGroup, cancelTasks context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timeout := time.Duration(w.config.Worker.DrainTimeout) * time.Second
+	slog.Info("Waiting for in-flight tasks", "drain_timeout", timeout)
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+		slog.Warn("Drain deadline exceeded, aborting in-flight tasks")
+		cancelTasks()
+	}
+	<-done
+}
+
+// releaseTimeout bounds lease release after a task was aborted
+const releaseTimeout = 10 * time.Second
+
+// processMessage routes a received message: commands to handleCommand,
+// everything else to runTask
+func (w *Worker) processMessage(ctx context.Context, msg *sqs.Message) error {
+	// Never act on a bot's comment (e.g. a CI failure report on our own PR)
+	if (msg.IsFollowUp() || msg.IsCommand()) && isBot(msg.CommentAuthor) {
+		slog.Info("Ignoring message from a bot", "type", msg.Type, "author", msg.CommentAuthor)
+		if err := w.queue.Delete(ctx, msg.ReceiptHandle); err != nil {
+			return fmt.Errorf("message deletion failed: %w", err)
+		}
+		return nil
+	}
+
+	if msg.IsCommand() {
+		return w.handleCommand(ctx, msg)
+	}
+	return w.runTask(ctx, msg)
+}
+
+// runTask runs a task to completion and settles its message
+func (w *Worker) runTask(ctx context.Context, msg *sqs.Message) error {
+	slog.Info("Processing task",
+		"issue_number", msg.IssueNumber,
+		"repository", msg.Repository,
+		"title", msg.Title,
+		"type", msg.Type,
+	)
+
+	// A cancel command aborts the task through this context
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	running := w.track(msg, cancel)
+	if running == nil {
+		// A duplicate delivery or a re-label while the issue's task runs:
+		// two tasks must not work on the same issue at once
+		return w.postpone(ctx, msg)
+	}
+	defer w.untrack(running)
+
+	// Show on the issue that a worker picked up the task. A cancel left over
+	// from an earlier run must not stop this one.
+	w.updateLabels(ctx, msg, []string{sqs.LabelInProgress}, []string{sqs.LabelFailed})
+	w.clearCancel(ctx, msg)
+	go w.watchCancel(ctx, msg, cancel)
+
+	// Keep the message invisible to other workers while the task runs
+	heartbeat := queue.StartHeartbeat(ctx, w.queue, msg.ReceiptHandle,
+		time.Duration(w.config.SQS.HeartbeatInterval)*time.Second,
+		time.Duration(w.config.SQS.VisibilityTimeout)*time.Second,
+	)
+	defer heartbeat.Stop()
+
+	// Keep a progress comment up to date while the pipeline runs
+	progress := w.startProgress(ctx, msg)
+	if progress != nil {
+		ctx = aider.WithProgress(ctx, progress.report)
+	}
+
+	// Save agent transcripts, diffs and verification output
+	store := w.startArtifacts(msg)
+	ctx = artifact.WithStore(ctx, store)
+
+	// Execute the pipeline; each stage retries on its own
+	prURL, err := w.processTask(ctx, msg, progress)
+
+	if err != nil && cancelled(ctx) {
+		progress.finish(progressCancelled)
+		heartbeat.Stop()
+		return w.finishCancelled(msg, err)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		// Aborted by shutdown: release the lease so the task is redelivered
+		// promptly instead of after the visibility timeout.
+		progress.finish(progressAborted)
+		heartbeat.Stop()
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+		defer cancel()
+		if err := w.queue.Nack(releaseCtx, msg.ReceiptHandle); err != nil {
+			slog.Error("Failed to release message after abort", "error", err)
+		}
+		w.updateLabels(releaseCtx, msg, nil, []string{sqs.LabelInProgress})
+		return fmt.Errorf("task aborted: %w", ctx.Err())
+	}
+
+	if err != nil {
+		stageErr := &stageError{Err: err}
+		errors.As(err, &stageErr)
+		slog.Error("Task failed after retries",
+			"issue_number", msg.IssueNumber,
+			"stage", stageErr.Stage,
+			"attempts", stageErr.Attempts,
+			"error", err,
+		)
+		progress.finish(progressFailed)
+		w.postArtifacts(ctx, msg, store, "")
+
+		// Post failure comment to the Issue (or the PR for follow-ups)
+		comment := w.buildFailureComment(stageErr.Err, stageErr.Stage, stageErr.Attempts)
+		if err := w.github.AddComment(ctx, msg.Repository, commentTarget(msg), comment); err != nil {
+			slog.Error("Failed to post failure comment", "error", err)
+		}
+		w.updateLabels(ctx, msg, []string{sqs.LabelFailed}, []string{sqs.LabelInProgress})
+
+		// Delete message from the queue (don't retry indefinitely)
+		heartbeat.Stop()
+		if err := w.queue.Delete(ctx, msg.ReceiptHandle); err != nil {
+			slog.Error("Failed to delete message after failure", "error", err)
+		}
+
+		return err
+	}
+
+	progress.finish(progressDone)
+	w.postArtifacts(ctx, msg, store, prURL)
+	if msg.IsFollowUp() {
+		slog.Info("Follow-up pushed", "pr_number", msg.PRNumber, "url", prURL)
+	} else {
+		slog.Info("PR created", "url", prURL)
+	}
+	w.updateLabels(ctx, msg, []string{sqs.LabelDone}, []string{sqs.LabelTrigger, sqs.LabelInProgress})
+
+	// Delete message from the queue
+	heartbeat.Stop()
+	if err := w.queue.Delete(ctx, msg.ReceiptHandle); err != nil {
+		return fmt.Errorf("message deletion failed: %w", err)
+	}
+
+	slog.Info("Task completed successfully",
+		"issue_number", msg.IssueNumber,
+		"pr_url", prURL,
+	)
+
+	return nil
+}
+
+// postpone hides a message whose issue already has a task running on this
+// worker until the next heartbeat interval, then lets it be redelivered.
+// Releasing it at once would have it received again right away.
+func (w *Worker) postpone(ctx context.Context, msg *sqs.Message) error {
+	delay := time.Duration(w.config.SQS.HeartbeatInterval) * time.Second
+	slog.Info("Task for this issue already running, postponing message",
+		"issue_number", msg.IssueNumber,
+		"repository", msg.Repository,
+		"delay", delay,
+	)
+	if err := w.queue.ExtendLease(ctx, msg.ReceiptHandle, delay); err != nil {
+		return fmt.Errorf("failed to postpone message: %w", err)
+	}
+	return nil
+}
+
+// finishCancelled reports a task stopped by /codingworker cancel and deletes
+// its message, so the task is not redelivered
+func (w *Worker) finishCancelled(msg *sqs.Message, err error) error {
+	// The task's context is done: settle with a fresh one
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	stageErr := &stageError{}
+	errors.As(err, &stageErr)
+	slog.Info("Task cancelled", "issue_number", msg.IssueNumber, "stage", stageErr.Stage)
+
+	comment := fmt.Sprintf("## 🛑 CodingWorker: タスクをキャンセルしました\n\n**中断したステージ**: %s\n", stageErr.Stage)
+	if err := w.github.AddComment(ctx, msg.Repository, commentTarget(msg), comment); err != nil {
+		slog.Error("Failed to post cancellation comment", "error", err)
+	}
+	w.updateLabels(ctx, msg, nil, []string{sqs.LabelInProgress})
+	w.clearCancel(ctx, msg)
+
+	if err := w.queue.Delete(ctx, msg.ReceiptHandle); err != nil {
+		return fmt.Errorf("message deletion failed: %w", err)
+	}
+	return nil
+}
+
+// updateLabels moves the issue through the label state machine.
+// Label errors are logged but never fail the task.
+func (w *Worker) updateLabels(ctx context.Context, msg *sqs.Message, add, remove []string) {
+	// Follow-ups report on the PR; the issue keeps its labels
+	if msg.IsFollowUp() {
+		return
+	}
+	if err := w.github.UpdateLabels(ctx, msg.Repository, msg.IssueNumber, add, remove); err != nil {
+		slog.Error("Failed to update issue labels",
+			"issue_number", msg.IssueNumber,
+			"error", err,
+		)
+	}
+}
+
+// commentTarget returns the issue or pull request to report the task's result on
+func commentTarget(msg *sqs.Message) int {
+	if msg.IsFollowUp() {
+		return msg.PRNumber
+	}
+	return msg.IssueNumber
+}
+
+// buildFailureComment creates a comment body for failed tasks. Command
+// output in err may contain credentials, so it is redacted.
+func (w *Worker) buildFailureComment(err error, stage string, attempts int) string {
+	return fmt.Sprintf(`## ⚠️ CodingWorker: タスク処理に失敗しました
+
+**失敗したステージ**: %s
+**試行回数**: %d
+**エラー内容**:
+`+"```"+`
+%v
+`+"```"+`
+
+---
+このコメントは CodingWorker によって自動生成されました。
+`, stage, attempts, redact.Error(err))
+}
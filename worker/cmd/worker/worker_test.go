@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/agent"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/aider"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/github"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/journal"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/queue"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// fakeQueue is a memory queue that records how messages were settled
+type fakeQueue struct {
+	*queue.Memory
+
+	mu       sync.Mutex
+	deleted  int
+	nacked   int
+	extended []time.Duration
+}
+
+func (q *fakeQueue) Delete(ctx context.Context, receiptHandle string) error {
+	q.mu.Lock()
+	q.deleted++
+	q.mu.Unlock()
+	return q.Memory.Delete(ctx, receiptHandle)
+}
+
+func (q *fakeQueue) Nack(ctx context.Context, receiptHandle string) error {
+	q.mu.Lock()
+	q.nacked++
+	q.mu.Unlock()
+	return q.Memory.Nack(ctx, receiptHandle)
+}
+
+func (q *fakeQueue) ExtendLease(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	q.mu.Lock()
+	q.extended = append(q.extended, timeout)
+	q.mu.Unlock()
+	return q.Memory.ExtendLease(ctx, receiptHandle, timeout)
+}
+
+func (q *fakeQueue) counts() (deleted, nacked int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.deleted, q.nacked
+}
+
+func (q *fakeQueue) extendedBy(timeout time.Duration) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, d := range q.extended {
+		if d == timeout {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeGitHub clones into local repositories and records what the worker
+// reports
+type fakeGitHub struct {
+	t        *testing.T
+	baseDir  string
+	issueErr error                  // Returned by GetIssue
+	readOnly string                 // User without write access
+	review   []github.ReviewComment // Returned by ListReviewComments
+
+	mu       sync.Mutex
+	clones   int
+	checks   map[string]int // Login -> CanWrite calls
+	prs      []int          // Issue numbers, in order
+	comments []string
+	labels   map[int]map[string]bool // Issue number -> labels
+	nextID   int64
+}
+
+func (g *fakeGitHub) CloneAndBranch(ctx context.Context, repository string, issueNumber int) (string, error) {
+	dir, err := os.MkdirTemp(g.baseDir, fmt.Sprintf("issue-%d-", issueNumber))
+	if err != nil {
+		return "", err
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			g.t.Errorf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+	g.mu.Lock()
+	g.clones++
+	g.mu.Unlock()
+	return dir, nil
+}
+
+func (g *fakeGitHub) CheckoutExistingBranch(ctx context.Context, repository string, issueNumber int, branchName string) (string, error) {
+	return g.CloneAndBranch(ctx, repository, issueNumber)
+}
+
+func (g *fakeGitHub) Push(ctx context.Context, workDir, base string, msg *sqs.Message) (string, error) {
+	return fmt.Sprintf("codingworker/issue-%d", msg.IssueNumber), nil
+}
+
+func (g *fakeGitHub) CreatePR(ctx context.Context, branchName string, msg *sqs.Message, details github.PRDetails) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.prs = append(g.prs, msg.IssueNumber)
+	return fmt.Sprintf("https://github.com/%s/pull/%d", msg.Repository, 100+len(g.prs)), nil
+}
+
+func (g *fakeGitHub) ReplyToReview(ctx context.Context, msg *sqs.Message, details github.PRDetails) (string, error) {
+	return fmt.Sprintf("https://github.com/%s/pull/%d#issuecomment-1", msg.Repository, msg.PRNumber), nil
+}
+
+func (g *fakeGitHub) GetIssue(ctx context.Context, repository string, number int) (*github.Issue, error) {
+	if g.issueErr != nil {
+		return nil, g.issueErr
+	}
+	issue := &github.Issue{Number: number, Title: fmt.Sprintf("issue %d", number)}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for name := range g.labels[number] {
+		issue.Labels = append(issue.Labels, struct {
+			Name string `json:"name"`
+		}{name})
+	}
+	return issue, nil
+}
+
+func (g *fakeGitHub) CanWrite(ctx context.Context, repository, user string) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.checks == nil {
+		g.checks = make(map[string]int)
+	}
+	g.checks[user]++
+	return user != g.readOnly, nil
+}
+
+func (g *fakeGitHub) ListReviewComments(ctx context.Context, repository string, number int, reviewID int64) ([]github.ReviewComment, error) {
+	return g.review, nil
+}
+
+func (g *fakeGitHub) AddComment(ctx context.Context, repository string, issueNumber int, body string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.comments = append(g.comments, body)
+	return nil
+}
+
+func (g *fakeGitHub) CreateComment(ctx context.Context, repository string, issueNumber int, body string) (*github.IssueComment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.nextID++
+	return &github.IssueComment{ID: g.nextID}, nil
+}
+
+func (g *fakeGitHub) UpdateComment(ctx context.Context, repository string, commentID int64, body string) error {
+	return nil
+}
+
+func (g *fakeGitHub) UpdateLabels(ctx context.Context, repository string, issueNumber int, add, remove []string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.labels == nil {
+		g.labels = make(map[int]map[string]bool)
+	}
+	if g.labels[issueNumber] == nil {
+		g.labels[issueNumber] = make(map[string]bool)
+	}
+	for _, label := range add {
+		g.labels[issueNumber][label] = true
+	}
+	for _, label := range remove {
+		delete(g.labels[issueNumber], label)
+	}
+	return nil
+}
+
+func (g *fakeGitHub) hasLabel(issueNumber int, label string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.labels[issueNumber][label]
+}
+
+func (g *fakeGitHub) state() (clones int, prs []int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.clones, append([]int(nil), g.prs...)
+}
+
+// gatedAgent writes a file on every run, but only once release is closed.
+// It records how many runs overlapped, in total and per issue.
+type gatedAgent struct {
+	release chan struct{}
+
+	mu        sync.Mutex
+	active    int
+	maxActive int
+	issues    map[int]int // Issue number -> active runs
+	maxIssue  int
+}
+
+func newGatedAgent() *gatedAgent {
+	return &gatedAgent{release: make(chan struct{}), issues: make(map[int]int)}
+}
+
+func (a *gatedAgent) Name() string { return "fake" }
+
+func (a *gatedAgent) Run(ctx context.Context, req agent.Request) (*agent.Result, error) {
+	// Clones are named issue-<number>-<random>
+	issue, _ := strconv.Atoi(strings.Split(filepath.Base(req.WorkDir), "-")[1])
+
+	a.mu.Lock()
+	a.active++
+	a.issues[issue]++
+	a.maxActive = max(a.maxActive, a.active)
+	a.maxIssue = max(a.maxIssue, a.issues[issue])
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.active--
+		a.issues[issue]--
+		a.mu.Unlock()
+	}()
+
+	select {
+	case <-a.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := os.WriteFile(filepath.Join(req.WorkDir, "generated.txt"), []byte(req.Prompt), 0644); err != nil {
+		return nil, err
+	}
+	return &agent.Result{ChangedFiles: []string{"generated.txt"}}, nil
+}
+
+func (a *gatedAgent) running() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.active
+}
+
+func (a *gatedAgent) peaks() (total, perIssue int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.maxActive, a.maxIssue
+}
+
+// newTestWorker creates a worker with the given worker and model settings
+// (YAML, indented for their sections), backed by fakes
+func newTestWorker(t *testing.T, workerYAML, modelYAML string, a agent.Agent) (*Worker, *fakeQueue, *fakeGitHub) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	data := fmt.Sprintf(`sqs:
+  visibility_timeout: 30
+  heartbeat_interval_seconds: 1
+github:
+  clone_base_dir: %q
+agent:
+  backend: fake
+aider:
+  models:
+    - name: m
+%s
+worker:
+%s
+`, dir, modelYAML, workerYAML)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("config.Load failed: %v", err)
+	}
+
+	j, err := journal.Open(cfg.Worker.JournalPath, time.Hour)
+	if err != nil {
+		t.Fatalf("journal.Open failed: %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+
+	q := &fakeQueue{Memory: queue.NewMemory(50*time.Millisecond, 30*time.Second)}
+	gh := &fakeGitHub{t: t, baseDir: dir}
+	return &Worker{
+		queue:   q,
+		aider:   aider.NewRunner(cfg.Aider, a),
+		github:  gh,
+		journal: j,
+		config:  cfg,
+	}, q, gh
+}
+
+// startWorker runs w until the returned stop func is called. stop cancels
+// receiving, then (if abort) the tasks, and waits for Run to return.
+func startWorker(t *testing.T, w *Worker) (stop func(abort bool)) {
+	t.Helper()
+	ctx, stopReceiving := context.WithCancel(context.Background())
+	abortCtx, abortTasks := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := w.Run(ctx, abortCtx); err != nil {
+			t.Errorf("Run failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		stopReceiving()
+		abortTasks()
+		<-done
+	})
+
+	return func(abort bool) {
+		stopReceiving()
+		if abort {
+			abortTasks()
+		}
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Run did not return")
+		}
+	}
+}
+
+func inject(t *testing.T, q *fakeQueue, issues ...int) {
+	t.Helper()
+	for _, n := range issues {
+		msg := &sqs.Message{
+			Type:        sqs.TypeIssue,
+			IssueNumber: n,
+			Repository:  "owner/repo",
+			Title:       fmt.Sprintf("issue %d", n),
+			Labels:      []string{sqs.LabelTrigger},
+			CreatedAt:   "2026-01-01T00:00:00Z",
+		}
+		if err := q.Inject(msg); err != nil {
+			t.Fatalf("Inject failed: %v", err)
+		}
+	}
+}
+
+// waitFor polls cond until it holds or a deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRun_Concurrency(t *testing.T) {
+	a := newGatedAgent()
+	w, q, gh := newTestWorker(t, "  concurrency: 2", "", a)
+	inject(t, q, 1, 2, 3)
+	stop := startWorker(t, w)
+
+	waitFor(t, "two tasks to run", func() bool { return a.running() == 2 })
+	// The third task waits for a free slot
+	time.Sleep(200 * time.Millisecond)
+	if clones, _ := gh.state(); clones != 2 {
+		t.Errorf("expected 2 tasks started while both slots are busy, got %d", clones)
+	}
+
+	close(a.release)
+	waitFor(t, "three PRs", func() bool { _, prs := gh.state(); return len(prs) == 3 })
+	stop(false)
+
+	if total, _ := a.peaks(); total != 2 {
+		t.Errorf("expected at most 2 concurrent agent runs, got %d", total)
+	}
+	if deleted, _ := q.counts(); deleted != 3 {
+		t.Errorf("expected 3 messages deleted, got %d", deleted)
+	}
+}
+
+func TestRun_ModelMaxConcurrent(t *testing.T) {
+	a := newGatedAgent()
+	w, q, gh := newTestWorker(t, "  concurrency: 3", "      max_concurrent: 1", a)
+	inject(t, q, 1, 2, 3)
+	stop := startWorker(t, w)
+
+	// Every task starts, but only one at a time runs the model
+	waitFor(t, "three tasks to start", func() bool { clones, _ := gh.state(); return clones == 3 })
+	time.Sleep(200 * time.Millisecond)
+	if n := a.running(); n != 1 {
+		t.Errorf("expected 1 agent run for a model with max_concurrent 1, got %d", n)
+	}
+
+	close(a.release)
+	waitFor(t, "three PRs", func() bool { _, prs := gh.state(); return len(prs) == 3 })
+	stop(false)
+
+	if total, _ := a.peaks(); total != 1 {
+		t.Errorf("expected at most 1 concurrent agent run, got %d", total)
+	}
+}
+
+func TestRun_PostponesRunningIssue(t *testing.T) {
+	a := newGatedAgent()
+	w, q, gh := newTestWorker(t, "  concurrency: 2", "", a)
+	// A duplicate delivery of the same message
+	inject(t, q, 1, 1)
+	stop := startWorker(t, w)
+
+	waitFor(t, "the duplicate to be postponed", func() bool { return q.extendedBy(time.Second) })
+	waitFor(t, "the task to run", func() bool { return a.running() == 1 })
+	if clones, _ := gh.state(); clones != 1 {
+		t.Errorf("expected 1 task for the issue, got %d", clones)
+	}
+
+	// Once redelivered after the task finished, the journal skips it
+	close(a.release)
+	waitFor(t, "both messages settled", func() bool { deleted, _ := q.counts(); return deleted == 2 })
+	stop(false)
+
+	if _, prs := gh.state(); len(prs) != 1 {
+		t.Errorf("expected 1 PR, got %v", prs)
+	}
+	if _, perIssue := a.peaks(); perIssue != 1 {
+		t.Errorf("expected at most 1 agent run per issue, got %d", perIssue)
+	}
+}
+
+func TestRun_DrainFinishesTasks(t *testing.T) {
+	a := newGatedAgent()
+	w, q, gh := newTestWorker(t, "  drain_timeout_seconds: 10", "", a)
+	inject(t, q, 1)
+	stop := startWorker(t, w)
+
+	waitFor(t, "the task to run", func() bool { return a.running() == 1 })
+	time.AfterFunc(100*time.Millisecond, func() { close(a.release) })
+	stop(false)
+
+	if _, prs := gh.state(); len(prs) != 1 {
+		t.Errorf("expected the in-flight task to finish during drain, got PRs %v", prs)
+	}
+	if deleted, nacked := q.counts(); deleted != 1 || nacked != 0 {
+		t.Errorf("expected the message deleted, got deleted=%d nacked=%d", deleted, nacked)
+	}
+}
+
+func TestRun_AbortReleasesTasks(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout string
+		abort        bool
+	}{
+		{"second signal", "  drain_timeout_seconds: 600", true},
+		{"drain deadline", "  drain_timeout_seconds: 1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newGatedAgent()
+			w, q, gh := newTestWorker(t, tt.drainTimeout, "", a)
+			inject(t, q, 1)
+			stop := startWorker(t, w)
+
+			waitFor(t, "the task to run", func() bool { return a.running() == 1 })
+			stop(tt.abort)
+
+			if _, prs := gh.state(); len(prs) != 0 {
+				t.Errorf("expected no PR from an aborted task, got %v", prs)
+			}
+			if deleted, nacked := q.counts(); deleted != 0 || nacked != 1 {
+				t.Errorf("expected the message released for redelivery, got deleted=%d nacked=%d", deleted, nacked)
+			}
+			// The aborted job stays resumable
+			if job, ok := w.journal.Get(journal.Key("owner/repo", 1)); !ok || job.Status != journal.StatusRunning {
+				t.Errorf("expected a running journal entry, got %+v", job)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+// Backend names
+const (
+	BackendAider  = "aider"  // Aider CLI
+	BackendDirect = "direct" // OpenAI-compatible chat API, edits applied by the worker
+)
+
+// Request is a single coding instruction for an agent
+type Request struct {
+	WorkDir string
+	Prompt  string
+	Model   config.ModelConfig
+}
+
+// Result reports what an agent run changed
+type Result struct {
+	ChangedFiles []string // Paths relative to WorkDir with uncommitted changes
+	Transcript   string   // Full conversation or tool output, for debugging
+}
+
+// Agent generates code by editing files in a working tree
+type Agent interface {
+	Name() string
+	Run(ctx context.Context, req Request) (*Result, error)
+}
+
+// SelectBackend returns the backend for a task: the first label listed in
+// label_backends wins, otherwise the global backend.
+func SelectBackend(cfg config.AgentConfig, labels []string) string {
+	for _, label := range labels {
+		if backend, ok := cfg.LabelBackends[label]; ok {
+			return backend
+		}
+	}
+	return cfg.Backend
+}
+
+// Head returns the current commit, the base for ChangedFiles
+func Head(ctx context.Context, workDir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = workDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Reset discards every commit and working tree change made since base,
+// including untracked files
+func Reset(ctx context.Context, workDir, base string) error {
+	for _, args := range [][]string{
+		{"reset", "--hard", "-q", base},
+		{"clean", "-fdq"},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = workDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %w, output: %s", args[0], err, string(output))
+		}
+	}
+	return nil
+}
+
+// ChangedFiles lists files that differ from base, whether the agent
+// committed them (Aider auto-commits) or left them in the working tree,
+// including untracked files
+func ChangedFiles(ctx context.Context, workDir, base string) ([]string, error) {
+	diff := exec.CommandContext(ctx, "git", "diff", "--name-only", "-z", base)
+	diff.Dir = workDir
+	changed, err := diff.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %w", err)
+	}
+
+	untracked := exec.CommandContext(ctx, "git", "ls-files", "--others", "--exclude-standard", "-z")
+	untracked.Dir = workDir
+	added, err := untracked.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files failed: %w", err)
+	}
+
+	files := splitNUL(string(changed) + string(added))
+	sort.Strings(files)
+	return slices.Compact(files), nil
+}
+
+// Diff returns the changes since base as a unified diff, including
+// untracked files
+func Diff(ctx context.Context, workDir, base string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "diff", base)
+	cmd.Dir = workDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git diff failed: %w", err)
+	}
+	diff := string(output)
+
+	untracked := exec.CommandContext(ctx, "git", "ls-files", "--others", "--exclude-standard", "-z")
+	untracked.Dir = workDir
+	added, err := untracked.Output()
+	if err != nil {
+		return "", fmt.Errorf("git ls-files failed: %w", err)
+	}
+	for _, file := range splitNUL(string(added)) {
+		// Exits 1 when the files differ, which they always do here
+		cmd := exec.CommandContext(ctx, "git", "diff", "--no-index", "--", "/dev/null", file)
+		cmd.Dir = workDir
+		output, _ := cmd.Output()
+		diff += string(output)
+	}
+	return diff, nil
+}
+
+// FileStat summarizes the changes to one file
+type FileStat struct {
+	Path    string
+	Added   int
+	Deleted int
+	Binary  bool // Line counts are not available
+}
+
+// DiffStat summarizes the changes since base per file, including untracked
+// files
+func DiffStat(ctx context.Context, workDir, base string) ([]FileStat, error) {
+	cmd := exec.CommandContext(ctx, "git", "diff", "--numstat", "--no-renames", "-z", base)
+	cmd.Dir = workDir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %w", err)
+	}
+	var stats []FileStat
+	for _, record := range splitNUL(string(output)) {
+		added, deleted, path, ok := parseNumstat(record)
+		if ok {
+			stats = append(stats, newFileStat(path, added, deleted))
+		}
+	}
+
+	untracked := exec.CommandContext(ctx, "git", "ls-files", "--others", "--exclude-standard", "-z")
+	untracked.Dir = workDir
+	files, err := untracked.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files failed: %w", err)
+	}
+	for _, file := range splitNUL(string(files)) {
+		// Exits 1 when the files differ, which they always do here
+		cmd := exec.CommandContext(ctx, "git", "diff", "--no-index", "--numstat", "--", "/dev/null", file)
+		cmd.Dir = workDir
+		output, _ := cmd.Output()
+		if added, deleted, _, ok := parseNumstat(strings.TrimSpace(string(output))); ok {
+			stats = append(stats, newFileStat(file, added, deleted))
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Path < stats[j].Path })
+	return stats, nil
+}
+
+// parseNumstat splits a "added<TAB>deleted<TAB>path" line of git diff --numstat
+func parseNumstat(line string) (added, deleted, path string, ok bool) {
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) < 2 {
+		return "", "", "", false
+	}
+	if len(fields) == 3 {
+		path = fields[2]
+	}
+	return fields[0], fields[1], path, true
+}
+
+// newFileStat parses numstat counts; git reports "-" for binary files
+func newFileStat(path, added, deleted string) FileStat {
+	a, errA := strconv.Atoi(added)
+	d, errD := strconv.Atoi(deleted)
+	if errA != nil || errD != nil {
+		return FileStat{Path: path, Binary: true}
+	}
+	return FileStat{Path: path, Added: a, Deleted: d}
+}
+
+// splitNUL splits NUL-terminated git output
+func splitNUL(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, "\x00") {
+		if f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
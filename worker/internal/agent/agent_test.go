@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+// newTestRepo creates a git repository with files committed
+func newTestRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+	return dir
+}
+
+func TestSelectBackend(t *testing.T) {
+	cfg := config.AgentConfig{
+		Backend:       BackendAider,
+		LabelBackends: map[string]string{"ai-direct": BackendDirect},
+	}
+
+	tests := []struct {
+		name     string
+		labels   []string
+		expected string
+	}{
+		{name: "no labels", labels: nil, expected: BackendAider},
+		{name: "unmapped labels", labels: []string{"ai-task", "bug"}, expected: BackendAider},
+		{name: "mapped label", labels: []string{"ai-task", "ai-direct"}, expected: BackendDirect},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SelectBackend(cfg, tt.labels); got != tt.expected {
+				t.Errorf("SelectBackend() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"keep.go":   "package main\n",
+		"edit.go":   "package main\n",
+		"remove.go": "package main\n",
+	})
+	ctx := context.Background()
+
+	base, err := Head(ctx, dir)
+	if err != nil {
+		t.Fatalf("Head failed: %v", err)
+	}
+
+	// Committed change (as Aider does) plus working tree changes
+	os.WriteFile(filepath.Join(dir, "edit.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	cmd := exec.Command("git", "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-am", "edit")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git commit failed: %v: %s", err, output)
+	}
+	os.Remove(filepath.Join(dir, "remove.go"))
+	os.MkdirAll(filepath.Join(dir, "pkg"), 0755)
+	os.WriteFile(filepath.Join(dir, "pkg", "new file.go"), []byte("package pkg\n"), 0644)
+
+	files, err := ChangedFiles(ctx, dir, base)
+	if err != nil {
+		t.Fatalf("ChangedFiles failed: %v", err)
+	}
+
+	expected := []string{"edit.go", "pkg/new file.go", "remove.go"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("ChangedFiles() = %v, want %v", files, expected)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"edit.go": "package main\n"})
+	ctx := context.Background()
+	base, err := Head(ctx, dir)
+	if err != nil {
+		t.Fatalf("Head failed: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "edit.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "new.go"), []byte("package main\n"), 0644)
+
+	diff, err := Diff(ctx, dir, base)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	for _, want := range []string{"+++ b/edit.go", "+func main() {}", "+++ b/new.go"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff missing %q:\n%s", want, diff)
+		}
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"edit.go": "package main\n", "remove.go": "package main\n\nvar x int\n"})
+	ctx := context.Background()
+	base, err := Head(ctx, dir)
+	if err != nil {
+		t.Fatalf("Head failed: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "edit.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	os.Remove(filepath.Join(dir, "remove.go"))
+	os.WriteFile(filepath.Join(dir, "new file.go"), []byte("package main\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "image.bin"), []byte{0, 1, 2}, 0644)
+
+	stats, err := DiffStat(ctx, dir, base)
+	if err != nil {
+		t.Fatalf("DiffStat failed: %v", err)
+	}
+	expected := []FileStat{
+		{Path: "edit.go", Added: 2},
+		{Path: "image.bin", Binary: true},
+		{Path: "new file.go", Added: 1},
+		{Path: "remove.go", Deleted: 3},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("DiffStat() = %+v, want %+v", stats, expected)
+	}
+}
+
+func TestReset(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"main.go": "package main\n"})
+	ctx := context.Background()
+
+	base, err := Head(ctx, dir)
+	if err != nil {
+		t.Fatalf("Head failed: %v", err)
+	}
+
+	// A committed edit, an uncommitted edit and an untracked file
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	cmd := exec.Command("git", "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-am", "edit")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git commit failed: %v: %s", err, output)
+	}
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("broken"), 0644)
+	os.WriteFile(filepath.Join(dir, "new.go"), []byte("package main\n"), 0644)
+
+	if err := Reset(ctx, dir, base); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	files, err := ChangedFiles(ctx, dir, base)
+	if err != nil {
+		t.Fatalf("ChangedFiles failed: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected a clean tree at base, got changes: %v", files)
+	}
+	if head, _ := Head(ctx, dir); head != base {
+		t.Errorf("HEAD = %s, want %s", head, base)
+	}
+}
@@ -0,0 +1,254 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/retry"
+)
+
+// maxListedFiles caps the repository file list sent to the model
+const maxListedFiles = 500
+
+// modelPrefixes are Aider/LiteLLM provider prefixes that the API doesn't expect
+var modelPrefixes = []string{"ollama_chat/", "ollama/", "openai/"}
+
+// Direct asks an OpenAI-compatible chat completions API (such as Ollama's /v1
+// endpoint) for edits and applies them to the working tree itself.
+type Direct struct {
+	config     config.DirectAgentConfig
+	httpClient *http.Client
+}
+
+// NewDirect creates a direct API agent
+func NewDirect(cfg config.DirectAgentConfig) *Direct {
+	redact.Register(cfg.APIKey)
+	return &Direct{
+		config:     cfg,
+		httpClient: &http.Client{}, // Bounded by the per-model timeout context
+	}
+}
+
+// Name returns the backend name
+func (d *Direct) Name() string {
+	return BackendDirect
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Run sends the prompt with the relevant files and applies the returned edits
+func (d *Direct) Run(ctx context.Context, req Request) (*Result, error) {
+	base, err := Head(ctx, req.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+	userPrompt, err := d.buildUserPrompt(ctx, req.WorkDir, req.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	messages := []chatMessage{
+		{Role: "system", Content: systemPrompt(d.config.EditFormat)},
+		{Role: "user", Content: userPrompt},
+	}
+
+	model := modelID(req.Model.Name)
+	reply, err := d.complete(ctx, model, messages)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Transcript: formatTranscript(append(messages, chatMessage{Role: "assistant", Content: reply}))}
+
+	edits, err := parseEdits(d.config.EditFormat, reply)
+	if err != nil {
+		return result, err
+	}
+	if len(edits) == 0 {
+		return result, ErrNoEdits
+	}
+	if err := applyEdits(req.WorkDir, edits); err != nil {
+		return result, fmt.Errorf("failed to apply edits: %w", err)
+	}
+
+	result.ChangedFiles, err = ChangedFiles(ctx, req.WorkDir, base)
+	if err != nil {
+		return result, err
+	}
+	slog.Info("Direct agent applied edits",
+		"model", model,
+		"edits", len(edits),
+		"changed_files", len(result.ChangedFiles),
+	)
+	return result, nil
+}
+
+// complete calls the chat completions endpoint and returns the reply text
+func (d *Direct) complete(ctx context.Context, model string, messages []chatMessage) (string, error) {
+	body, err := json.Marshal(map[string]any{
+		"model":       model,
+		"messages":    messages,
+		"temperature": 0,
+		"stream":      false,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := strings.TrimRight(d.config.BaseURL, "/") + "/chat/completions"
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if d.config.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+d.config.APIKey)
+	}
+
+	resp, err := d.httpClient.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", &retry.TransientError{Err: fmt.Errorf("chat completion request failed: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", &retry.TransientError{Err: fmt.Errorf("failed to read response: %w", err)}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := fmt.Errorf("chat completion failed: %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+		if retry.ClassifyHTTPStatus(resp.StatusCode) == retry.ErrorTypeTransient {
+			return "", &retry.TransientError{Err: apiErr}
+		}
+		return "", &retry.PermanentError{Err: apiErr}
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(respBody, &completion); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("chat completion returned no choices")
+	}
+	return completion.Choices[0].Message.Content, nil
+}
+
+// buildUserPrompt lists the repository files and includes the contents of
+// the most relevant ones ahead of the task
+func (d *Direct) buildUserPrompt(ctx context.Context, workDir, prompt string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z")
+	cmd.Dir = workDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git ls-files failed: %w", err)
+	}
+	files := splitNUL(string(output))
+
+	var b strings.Builder
+	b.WriteString("Repository files:\n")
+	for i, f := range files {
+		if i == maxListedFiles {
+			fmt.Fprintf(&b, "... and %d more\n", len(files)-maxListedFiles)
+			break
+		}
+		b.WriteString(f + "\n")
+	}
+
+	// Read through the root so a symlink in the repository can't send a
+	// host file to the API
+	root, err := os.OpenRoot(workDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to open work directory: %w", err)
+	}
+	defer root.Close()
+
+	for _, f := range selectContextFiles(files, prompt, d.config.MaxContextFiles) {
+		data, err := root.ReadFile(filepath.FromSlash(f))
+		if err != nil || len(data) > d.config.MaxFileBytes || bytes.IndexByte(data, 0) >= 0 {
+			continue // Missing, outside the clone, too large or binary
+		}
+		fmt.Fprintf(&b, "\n%s\n```\n%s```\n", f, ensureNewline(string(data)))
+	}
+
+	fmt.Fprintf(&b, "\nTask:\n%s\n", prompt)
+	return b.String(), nil
+}
+
+// selectContextFiles picks files mentioned in the prompt (by path or base
+// name) first, then fills the remaining slots in repository order
+func selectContextFiles(files []string, prompt string, limit int) []string {
+	var mentioned, others []string
+	for _, f := range files {
+		if strings.Contains(prompt, f) || strings.Contains(prompt, path.Base(f)) {
+			mentioned = append(mentioned, f)
+		} else {
+			others = append(others, f)
+		}
+	}
+
+	selected := append(mentioned, others...)
+	if len(selected) > limit {
+		selected = selected[:limit]
+	}
+	return selected
+}
+
+// systemPrompt explains the expected answer format to the model
+func systemPrompt(format string) string {
+	base := "You are an expert software engineer editing a repository. " +
+		"Make the smallest change that completes the task. "
+	if format == EditFormatWhole {
+		return base + "For every file you create or change, write its path relative to the repository root " +
+			"on its own line, followed by the complete new file contents in a fenced code block. " +
+			"Do not output files you did not change."
+	}
+	return base + "Answer with a unified diff in a ```diff fenced block. " +
+		"Use '--- a/path' and '+++ b/path' headers with paths relative to the repository root, " +
+		"'--- /dev/null' for new files, and include at least 3 lines of unchanged context in each hunk."
+}
+
+// modelID strips Aider provider prefixes such as "ollama_chat/"
+func modelID(name string) string {
+	for _, prefix := range modelPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
+
+func formatTranscript(messages []chatMessage) string {
+	var b strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&b, "### %s\n\n%s\n\n", m.Role, m.Content)
+	}
+	return b.String()
+}
+
+func ensureNewline(s string) string {
+	if s == "" || strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/retry"
+)
+
+// fakeChatServer answers chat completions with reply and records the last request
+type fakeChatServer struct {
+	*httptest.Server
+	status  int
+	reply   string
+	request struct {
+		Model    string        `json:"model"`
+		Messages []chatMessage `json:"messages"`
+	}
+	auth string
+}
+
+func newFakeChatServer(t *testing.T, reply string) *fakeChatServer {
+	f := &fakeChatServer{status: http.StatusOK, reply: reply}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		f.auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&f.request)
+
+		w.WriteHeader(f.status)
+		if f.status != http.StatusOK {
+			w.Write([]byte(`{"error":"unavailable"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{
+				{"message": map[string]string{"role": "assistant", "content": f.reply}},
+			},
+		})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newTestDirect(baseURL, format string) *Direct {
+	return NewDirect(config.DirectAgentConfig{
+		BaseURL:         baseURL + "/v1",
+		APIKey:          "direct-test-key",
+		EditFormat:      format,
+		MaxContextFiles: 10,
+		MaxFileBytes:    1024,
+	})
+}
+
+func TestDirect_Run_Diff(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"greet.go": "package main\n\nfunc greet() string {\n\treturn \"hi\"\n}\n",
+		"other.go": "package main\n",
+	})
+	server := newFakeChatServer(t, "```diff\n"+
+		"--- a/greet.go\n"+
+		"+++ b/greet.go\n"+
+		"@@ -3,3 +3,3 @@\n"+
+		" func greet() string {\n"+
+		"-\treturn \"hi\"\n"+
+		"+\treturn \"hello\"\n"+
+		" }\n"+
+		"```\n")
+
+	d := newTestDirect(server.URL, EditFormatDiff)
+	result, err := d.Run(context.Background(), Request{
+		WorkDir: dir,
+		Prompt:  "Change greet.go to say hello",
+		Model:   config.ModelConfig{Name: "ollama_chat/qwen2.5-coder:1.5b"},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "greet.go"))
+	if !strings.Contains(string(data), `return "hello"`) {
+		t.Errorf("edit not applied: %s", data)
+	}
+	if len(result.ChangedFiles) != 1 || result.ChangedFiles[0] != "greet.go" {
+		t.Errorf("unexpected changed files: %v", result.ChangedFiles)
+	}
+	if !strings.Contains(result.Transcript, "### assistant") {
+		t.Errorf("transcript missing reply: %s", result.Transcript)
+	}
+
+	// Provider prefix stripped, key sent, file contents and task included
+	if server.request.Model != "qwen2.5-coder:1.5b" {
+		t.Errorf("unexpected model: %s", server.request.Model)
+	}
+	if server.auth != "Bearer direct-test-key" {
+		t.Errorf("unexpected auth header: %s", server.auth)
+	}
+	user := server.request.Messages[1].Content
+	if !strings.Contains(user, `return "hi"`) || !strings.Contains(user, "Change greet.go to say hello") {
+		t.Errorf("user prompt missing context: %s", user)
+	}
+}
+
+func TestDirect_Run_Whole(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"README.md": "# demo\n"})
+	server := newFakeChatServer(t, "cmd/app/main.go\n```go\npackage main\n\nfunc main() {}\n```\n")
+
+	d := newTestDirect(server.URL, EditFormatWhole)
+	result, err := d.Run(context.Background(), Request{WorkDir: dir, Prompt: "Add a main package"})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "cmd", "app", "main.go"))
+	if err != nil || string(data) != "package main\n\nfunc main() {}\n" {
+		t.Errorf("unexpected main.go: %q, %v", data, err)
+	}
+	if len(result.ChangedFiles) != 1 || result.ChangedFiles[0] != "cmd/app/main.go" {
+		t.Errorf("unexpected changed files: %v", result.ChangedFiles)
+	}
+}
+
+func TestDirect_Run_NoEdits(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"main.go": "package main\n"})
+	server := newFakeChatServer(t, "I'm not sure what to change.")
+
+	d := newTestDirect(server.URL, EditFormatDiff)
+	result, err := d.Run(context.Background(), Request{WorkDir: dir, Prompt: "Do something"})
+	if !errors.Is(err, ErrNoEdits) {
+		t.Errorf("expected ErrNoEdits, got %v", err)
+	}
+	if result == nil || result.Transcript == "" {
+		t.Error("expected transcript even without edits")
+	}
+}
+
+func TestDirect_Run_ServerError(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"main.go": "package main\n"})
+	server := newFakeChatServer(t, "")
+	server.status = http.StatusServiceUnavailable
+
+	d := newTestDirect(server.URL, EditFormatDiff)
+	_, err := d.Run(context.Background(), Request{WorkDir: dir, Prompt: "Do something"})
+
+	var transient *retry.TransientError
+	if !errors.As(err, &transient) {
+		t.Errorf("expected transient error for 503, got %v", err)
+	}
+}
+
+func TestBuildUserPrompt_SkipsSymlinkEscape(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "credentials")
+	os.WriteFile(secret, []byte("aws_secret_access_key = hunter2\n"), 0600)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)
+	if err := os.Symlink(secret, filepath.Join(dir, "notes.txt")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	for _, args := range [][]string{{"init", "-q"}, {"add", "-A"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+
+	d := newTestDirect("http://unused", EditFormatDiff)
+	prompt, err := d.buildUserPrompt(context.Background(), dir, "Update notes.txt")
+	if err != nil {
+		t.Fatalf("buildUserPrompt failed: %v", err)
+	}
+	if strings.Contains(prompt, "hunter2") {
+		t.Errorf("prompt contains a file outside the working tree:\n%s", prompt)
+	}
+	if !strings.Contains(prompt, "package main") {
+		t.Errorf("prompt is missing main.go:\n%s", prompt)
+	}
+}
+
+func TestSelectContextFiles(t *testing.T) {
+	files := []string{"a.go", "b.go", "pkg/target.go", "z.go"}
+
+	got := selectContextFiles(files, "Fix the bug in target.go", 2)
+	if len(got) != 2 || got[0] != "pkg/target.go" || got[1] != "a.go" {
+		t.Errorf("unexpected selection: %v", got)
+	}
+}
@@ -0,0 +1,321 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Edit formats the direct agent asks the model to answer in
+const (
+	EditFormatDiff  = "diff"  // Unified diff
+	EditFormatWhole = "whole" // Complete contents of each changed file
+)
+
+// ErrNoEdits is returned when a model response contains no applicable edits
+var ErrNoEdits = errors.New("model response contains no edits")
+
+// fileEdit is a change to one file parsed from a model response
+type fileEdit struct {
+	Path    string
+	Delete  bool
+	Create  bool
+	Content string // Whole-file edits and created files
+	Hunks   []hunk // Diff edits to an existing file
+	Whole   bool
+}
+
+// hunk is one @@ section of a unified diff
+type hunk struct {
+	oldStart int // 1-based; only used to place pure insertions
+	oldLines []string
+	newLines []string
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)`)
+
+// parseEdits extracts file edits from a model response in the given format
+func parseEdits(format, response string) ([]fileEdit, error) {
+	switch format {
+	case EditFormatDiff:
+		return parseUnifiedDiff(response), nil
+	case EditFormatWhole:
+		return parseWholeFiles(response), nil
+	default:
+		return nil, fmt.Errorf("unknown edit format: %s", format)
+	}
+}
+
+// parseUnifiedDiff reads unified diff sections, ignoring any prose or code
+// fences around them. Line numbers are not trusted: hunks are located by
+// their context when applied.
+func parseUnifiedDiff(response string) []fileEdit {
+	var edits []fileEdit
+	var cur *fileEdit
+	var h *hunk
+
+	finishHunk := func() {
+		if cur != nil && h != nil {
+			// Trailing blank lines are usually padding before a closing fence
+			for len(h.oldLines) > 0 && len(h.newLines) > 0 &&
+				h.oldLines[len(h.oldLines)-1] == "" && h.newLines[len(h.newLines)-1] == "" {
+				h.oldLines = h.oldLines[:len(h.oldLines)-1]
+				h.newLines = h.newLines[:len(h.newLines)-1]
+			}
+			if len(h.oldLines) > 0 || len(h.newLines) > 0 {
+				cur.Hunks = append(cur.Hunks, *h)
+			}
+		}
+		h = nil
+	}
+	finishFile := func() {
+		finishHunk()
+		if cur != nil && (cur.Delete || len(cur.Hunks) > 0) {
+			edits = append(edits, *cur)
+		}
+		cur = nil
+	}
+
+	lines := strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			finishFile()
+			oldPath := diffPath(line[4:])
+			newPath := diffPath(lines[i+1][4:])
+			i++
+			cur = &fileEdit{Path: newPath}
+			if newPath == "/dev/null" {
+				cur.Path = oldPath
+				cur.Delete = true
+			} else if oldPath == "/dev/null" {
+				cur.Create = true
+			}
+		case strings.HasPrefix(line, "@@") && cur != nil:
+			finishHunk()
+			h = &hunk{}
+			if m := hunkHeader.FindStringSubmatch(line); m != nil {
+				h.oldStart, _ = strconv.Atoi(m[1])
+			}
+		case h != nil && strings.HasPrefix(line, "\\"):
+			// "\ No newline at end of file"
+		case h != nil && strings.HasPrefix(line, "+"):
+			h.newLines = append(h.newLines, line[1:])
+		case h != nil && strings.HasPrefix(line, "-"):
+			h.oldLines = append(h.oldLines, line[1:])
+		case h != nil && (strings.HasPrefix(line, " ") || line == ""):
+			// Models often drop the leading space of blank context lines
+			ctx := strings.TrimPrefix(line, " ")
+			h.oldLines = append(h.oldLines, ctx)
+			h.newLines = append(h.newLines, ctx)
+		default:
+			finishHunk()
+		}
+	}
+	finishFile()
+
+	for i := range edits {
+		if edits[i].Create {
+			var content []string
+			for _, h := range edits[i].Hunks {
+				content = append(content, h.newLines...)
+			}
+			edits[i].Content = joinLines(content)
+			edits[i].Hunks = nil
+		}
+	}
+	return edits
+}
+
+// diffPath strips the a/ or b/ prefix and any timestamp from a diff header path
+func diffPath(s string) string {
+	s, _, _ = strings.Cut(s, "\t")
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return s
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+// parseWholeFiles reads "path followed by a fenced code block" sections
+func parseWholeFiles(response string) []fileEdit {
+	var edits []fileEdit
+	lines := strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n")
+
+	prev := ""
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if !strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if strings.TrimSpace(line) != "" {
+				prev = line
+			}
+			continue
+		}
+
+		// Collect the fenced block
+		var content []string
+		j := i + 1
+		for ; j < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[j]), "```"); j++ {
+			content = append(content, lines[j])
+		}
+
+		if path, ok := pathLine(prev); ok {
+			edits = append(edits, fileEdit{Path: path, Whole: true, Content: joinLines(content)})
+		}
+		prev = ""
+		i = j
+	}
+	return edits
+}
+
+// pathLine extracts a file path from a line such as "**cmd/main.go**",
+// "`cmd/main.go`:" or "File: cmd/main.go"
+func pathLine(line string) (string, bool) {
+	s := strings.TrimSpace(line)
+	s = strings.TrimLeft(s, "#")
+	s = strings.TrimSpace(s)
+	for _, prefix := range []string{"File:", "file:", "Path:", "path:"} {
+		s = strings.TrimSpace(strings.TrimPrefix(s, prefix))
+	}
+	s = strings.TrimSuffix(s, ":")
+	s = strings.Trim(s, "*`")
+	s = strings.TrimSuffix(s, ":")
+
+	if s == "" || strings.ContainsAny(s, " \t") || !strings.ContainsAny(s, "./") {
+		return "", false
+	}
+	return s, true
+}
+
+// applyEdits writes edits into workDir. Paths must stay inside the working
+// tree and may not touch .git. Files are accessed through an os.Root, so a
+// symlink in the clone cannot lead a write outside it.
+func applyEdits(workDir string, edits []fileEdit) error {
+	root, err := os.OpenRoot(workDir)
+	if err != nil {
+		return fmt.Errorf("failed to open working tree: %w", err)
+	}
+	defer root.Close()
+
+	for _, edit := range edits {
+		rel := filepath.FromSlash(edit.Path)
+		if !filepath.IsLocal(rel) || rel == ".git" || strings.HasPrefix(rel, ".git"+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to edit %q: outside the working tree", edit.Path)
+		}
+
+		switch {
+		case edit.Delete:
+			if err := root.Remove(rel); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to delete %s: %w", edit.Path, err)
+			}
+			continue
+		case edit.Whole || edit.Create:
+			if err := writeFile(root, rel, edit.Content); err != nil {
+				return fmt.Errorf("failed to write %s: %w", edit.Path, err)
+			}
+			continue
+		}
+
+		data, err := root.ReadFile(rel)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", edit.Path, err)
+		}
+		updated, err := applyHunks(string(data), edit.Hunks)
+		if err != nil {
+			return fmt.Errorf("%s: %w", edit.Path, err)
+		}
+		if err := writeFile(root, rel, updated); err != nil {
+			return fmt.Errorf("failed to write %s: %w", edit.Path, err)
+		}
+	}
+	return nil
+}
+
+// applyHunks applies hunks in order, locating each by its old lines. Exact
+// matches are preferred; otherwise trailing whitespace is ignored.
+func applyHunks(content string, hunks []hunk) (string, error) {
+	lines := splitLines(content)
+	pos := 0
+
+	for i, h := range hunks {
+		var idx int
+		if len(h.oldLines) == 0 {
+			// Pure insertion: "@@ -N,0" inserts after line N
+			idx = min(max(h.oldStart, pos), len(lines))
+		} else {
+			idx = findLines(lines, h.oldLines, pos, exactMatch)
+			if idx < 0 {
+				idx = findLines(lines, h.oldLines, 0, exactMatch)
+			}
+			if idx < 0 {
+				idx = findLines(lines, h.oldLines, 0, looseMatch)
+			}
+			if idx < 0 {
+				return "", fmt.Errorf("hunk %d does not apply", i+1)
+			}
+		}
+
+		updated := make([]string, 0, len(lines)-len(h.oldLines)+len(h.newLines))
+		updated = append(updated, lines[:idx]...)
+		updated = append(updated, h.newLines...)
+		updated = append(updated, lines[idx+len(h.oldLines):]...)
+		lines = updated
+		pos = idx + len(h.newLines)
+	}
+
+	return joinLines(lines), nil
+}
+
+func exactMatch(a, b string) bool { return a == b }
+
+func looseMatch(a, b string) bool {
+	return strings.TrimRight(a, " \t") == strings.TrimRight(b, " \t")
+}
+
+// findLines returns the index of the first occurrence of want in lines at or
+// after from, or -1
+func findLines(lines, want []string, from int, eq func(a, b string) bool) int {
+	for i := from; i+len(want) <= len(lines); i++ {
+		match := true
+		for j := range want {
+			if !eq(lines[i+j], want[j]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func writeFile(root *os.Root, path, content string) error {
+	if err := root.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return root.WriteFile(path, []byte(content), 0644)
+}
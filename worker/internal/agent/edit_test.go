@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseUnifiedDiff(t *testing.T) {
+	response := "Here is the change:\n\n```diff\n" +
+		"--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		" package main\n" +
+		"\n" +
+		"-func old() {}\n" +
+		"+func renamed() {}\n" +
+		"--- /dev/null\n" +
+		"+++ b/util/util.go\n" +
+		"@@ -0,0 +1,2 @@\n" +
+		"+package util\n" +
+		"+\n" +
+		"--- a/legacy.go\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-package main\n" +
+		"```\n\nLet me know if you need anything else."
+
+	edits := parseUnifiedDiff(response)
+	if len(edits) != 3 {
+		t.Fatalf("expected 3 edits, got %d: %+v", len(edits), edits)
+	}
+
+	if edits[0].Path != "main.go" || len(edits[0].Hunks) != 1 {
+		t.Errorf("unexpected first edit: %+v", edits[0])
+	}
+	h := edits[0].Hunks[0]
+	if strings.Join(h.oldLines, "|") != "package main||func old() {}" {
+		t.Errorf("unexpected old lines: %q", h.oldLines)
+	}
+	if strings.Join(h.newLines, "|") != "package main||func renamed() {}" {
+		t.Errorf("unexpected new lines: %q", h.newLines)
+	}
+
+	if !edits[1].Create || edits[1].Path != "util/util.go" || edits[1].Content != "package util\n\n" {
+		t.Errorf("unexpected created file: %+v", edits[1])
+	}
+	if !edits[2].Delete || edits[2].Path != "legacy.go" {
+		t.Errorf("unexpected deleted file: %+v", edits[2])
+	}
+}
+
+func TestParseWholeFiles(t *testing.T) {
+	response := "I updated two files.\n\n" +
+		"**main.go**\n" +
+		"```go\n" +
+		"package main\n" +
+		"\n" +
+		"func main() {}\n" +
+		"```\n\n" +
+		"File: pkg/util.go\n" +
+		"```\n" +
+		"package pkg\n" +
+		"```\n\n" +
+		"Example usage:\n" +
+		"```\n" +
+		"go run .\n" +
+		"```\n"
+
+	edits := parseWholeFiles(response)
+	if len(edits) != 2 {
+		t.Fatalf("expected 2 edits, got %d: %+v", len(edits), edits)
+	}
+	if edits[0].Path != "main.go" || edits[0].Content != "package main\n\nfunc main() {}\n" {
+		t.Errorf("unexpected first edit: %+v", edits[0])
+	}
+	if edits[1].Path != "pkg/util.go" || edits[1].Content != "package pkg\n" {
+		t.Errorf("unexpected second edit: %+v", edits[1])
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	content := "a\nb\nc\nd\ne\n"
+
+	tests := []struct {
+		name     string
+		hunks    []hunk
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "replace with context",
+			hunks:    []hunk{{oldLines: []string{"b", "c", "d"}, newLines: []string{"b", "C", "d"}}},
+			expected: "a\nb\nC\nd\ne\n",
+		},
+		{
+			name: "multiple hunks in order",
+			hunks: []hunk{
+				{oldLines: []string{"a"}, newLines: []string{"A"}},
+				{oldLines: []string{"e"}, newLines: []string{"E", "f"}},
+			},
+			expected: "A\nb\nc\nd\nE\nf\n",
+		},
+		{
+			name:     "trailing whitespace ignored",
+			hunks:    []hunk{{oldLines: []string{"c  "}, newLines: []string{"x"}}},
+			expected: "a\nb\nx\nd\ne\n",
+		},
+		{
+			name:     "pure insertion uses line number",
+			hunks:    []hunk{{oldStart: 2, newLines: []string{"inserted"}}},
+			expected: "a\nb\ninserted\nc\nd\ne\n",
+		},
+		{
+			name:    "context not found",
+			hunks:   []hunk{{oldLines: []string{"zzz"}, newLines: []string{"y"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyHunks(content, tt.hunks)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyHunks failed: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("applyHunks() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyEdits_RejectsPathsOutsideWorkDir(t *testing.T) {
+	for _, path := range []string{"../escape.go", "/etc/passwd", ".git/config"} {
+		err := applyEdits(t.TempDir(), []fileEdit{{Path: path, Whole: true, Content: "x\n"}})
+		if err == nil {
+			t.Errorf("expected error for %s", path)
+		}
+	}
+}
+
+func TestApplyEdits_RejectsSymlinkEscape(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "notes.txt"), []byte("keep\n"), 0644)
+	if err := os.Symlink(outside, filepath.Join(dir, "docs")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	os.Symlink(filepath.Join(outside, "notes.txt"), filepath.Join(dir, "notes.txt"))
+
+	for _, edit := range []fileEdit{
+		{Path: "docs/evil.sh", Create: true, Content: "x\n"},
+		{Path: "docs/notes.txt", Whole: true, Content: "x\n"},
+		{Path: "notes.txt", Whole: true, Content: "x\n"},
+		{Path: "docs/notes.txt", Delete: true},
+	} {
+		if err := applyEdits(dir, []fileEdit{edit}); err == nil {
+			t.Errorf("expected error for %+v", edit)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "evil.sh")); !os.IsNotExist(err) {
+		t.Error("a file was created outside the working tree")
+	}
+	if data, _ := os.ReadFile(filepath.Join(outside, "notes.txt")); string(data) != "keep\n" {
+		t.Errorf("a file outside the working tree was changed: %q", data)
+	}
+}
+
+func TestApplyEdits(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc old() {}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "legacy.go"), []byte("package main\n"), 0644)
+
+	edits := []fileEdit{
+		{Path: "main.go", Hunks: []hunk{{oldLines: []string{"func old() {}"}, newLines: []string{"func renamed() {}"}}}},
+		{Path: "util/util.go", Create: true, Content: "package util\n"},
+		{Path: "legacy.go", Delete: true},
+	}
+	if err := applyEdits(dir, edits); err != nil {
+		t.Fatalf("applyEdits failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "main.go"))
+	if string(data) != "package main\n\nfunc renamed() {}\n" {
+		t.Errorf("unexpected main.go: %q", data)
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, "util", "util.go"))
+	if string(data) != "package util\n" {
+		t.Errorf("unexpected util.go: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "legacy.go")); !os.IsNotExist(err) {
+		t.Error("legacy.go should be deleted")
+	}
+}
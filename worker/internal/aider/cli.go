@@ -0,0 +1,58 @@
+package aider
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/agent"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+// CLI runs the aider command line tool as a coding agent
+type CLI struct {
+	config config.AiderConfig
+}
+
+// NewCLI creates an Aider CLI agent
+func NewCLI(cfg config.AiderConfig) *CLI {
+	return &CLI{config: cfg}
+}
+
+// Name returns the backend name
+func (a *CLI) Name() string {
+	return agent.BackendAider
+}
+
+// Run executes Aider once with the prompt; Aider edits the files itself
+func (a *CLI) Run(ctx context.Context, req agent.Request) (*agent.Result, error) {
+	base, err := agent.Head(ctx, req.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"--model", req.Model.Name,
+		"--yes",          // Auto-confirm changes
+		"--no-auto-lint", // Skip auto-linting
+		"--map-tokens", strconv.Itoa(a.config.MapTokens),
+		"--message", req.Prompt,
+	}
+
+	cmd := exec.CommandContext(ctx, a.config.BinPath, args...)
+	cmd.Dir = req.WorkDir
+
+	// Capture output
+	output, err := cmd.CombinedOutput()
+	result := &agent.Result{Transcript: string(output)}
+	if err != nil {
+		return result, fmt.Errorf("aider execution failed: %w", err)
+	}
+
+	result.ChangedFiles, err = agent.ChangedFiles(ctx, req.WorkDir, base)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,185 @@
+package aider
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+const (
+	// defaultMaxDiagnostics is the default number of distinct diagnostics
+	// kept in fix prompts (models can override it with max_diagnostics)
+	defaultMaxDiagnostics = 10
+
+	// defaultErrorTokens is the default budget for verification output in
+	// fix prompts (models can override it with error_token_budget)
+	defaultErrorTokens = 1500
+
+	// charsPerToken estimates token counts from lengths, without a tokenizer
+	charsPerToken = 4
+)
+
+var (
+	// location matches file:line or file:line:col in compiler and linter output
+	location = regexp.MustCompile(`[\w./\\-]+\.\w+:\d+(:\d+)?`)
+
+	// Failing tests: go test, cargo test, pytest
+	goTestFail   = regexp.MustCompile(`^\s*--- FAIL: (\S+)`)
+	rustTestFail = regexp.MustCompile(`^---- (\S+) stdout ----$`)
+	pytestFail   = regexp.MustCompile(`^FAILED (\S+)(?: - (.*))?$`)
+)
+
+// digestError shortens a verification failure for a fix prompt: the step's
+// output is reduced to its distinct diagnostics and failing tests and fit
+// into the model's token budget
+func digestError(err error, model config.ModelConfig) string {
+	maxDiagnostics := model.MaxDiagnostics
+	if maxDiagnostics <= 0 {
+		maxDiagnostics = defaultMaxDiagnostics
+	}
+	budget := model.ErrorTokens
+	if budget <= 0 {
+		budget = defaultErrorTokens
+	}
+
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) {
+		return truncate(err.Error(), budget*charsPerToken)
+	}
+	header := fmt.Sprintf("%s failed: %v\n", stepErr.Step, stepErr.Err)
+	return header + digest(stepErr.Output, maxDiagnostics, budget*charsPerToken-len(header))
+}
+
+// digest summarizes build, lint or test output in at most maxChars. Repeated
+// lines are dropped. If the output still does not fit, only the first
+// maxDiagnostics distinct file:line diagnostics and the failing tests with
+// their messages are kept.
+func digest(output string, maxDiagnostics, maxChars int) string {
+	lines := dedupe(strings.Split(strings.TrimSpace(output), "\n"))
+	if text := strings.Join(lines, "\n"); len(text) <= maxChars {
+		return text
+	}
+
+	var b strings.Builder
+	tests, consumed := findFailingTests(lines)
+	diagnostics := findDiagnostics(lines, consumed)
+	if len(diagnostics) > 0 {
+		shown := diagnostics[:min(len(diagnostics), maxDiagnostics)]
+		fmt.Fprintf(&b, "Errors (first %d of %d):\n%s\n", len(shown), len(diagnostics), strings.Join(shown, "\n"))
+	}
+	if len(tests) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "Failing tests:\n%s\n", strings.Join(tests, "\n"))
+	}
+	if b.Len() == 0 {
+		// Nothing recognizable: the start of the output usually names the cause
+		return truncate(strings.Join(lines, "\n"), maxChars)
+	}
+	return truncate(strings.TrimRight(b.String(), "\n"), maxChars)
+}
+
+// dedupe drops lines seen before, ignoring surrounding whitespace. Blank
+// lines are kept for readability, but not twice in a row.
+func dedupe(lines []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, line := range lines {
+		key := strings.TrimSpace(line)
+		if key == "" {
+			if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" {
+				out = append(out, "")
+			}
+			continue
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, line)
+	}
+	return out
+}
+
+// findDiagnostics returns the lines that point at a file:line, skipping
+// those already part of a failing test. For rustc's "--> file:line" the
+// message on the line before is included.
+func findDiagnostics(lines []string, skip map[int]bool) []string {
+	var out []string
+	for i, line := range lines {
+		if skip[i] || !location.MatchString(line) {
+			continue
+		}
+		diagnostic := strings.TrimSpace(line)
+		if strings.HasPrefix(diagnostic, "-->") && i > 0 {
+			diagnostic = strings.TrimSpace(lines[i-1]) + " " + diagnostic
+		}
+		out = append(out, diagnostic)
+	}
+	return out
+}
+
+// findFailingTests returns each failing test with its assertion messages:
+// the indented lines after go test's "--- FAIL", the panic lines of a cargo
+// test's stdout block, or pytest's short summary. consumed holds the
+// indexes of the lines used.
+func findFailingTests(lines []string) (tests []string, consumed map[int]bool) {
+	consumed = make(map[int]bool)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case goTestFail.MatchString(line):
+			consumed[i] = true
+			test := []string{strings.TrimSpace(line)}
+			indent := len(line) - len(strings.TrimLeft(line, " \t"))
+			for i+1 < len(lines) && isIndentedMore(lines[i+1], indent) {
+				i++
+				consumed[i] = true
+				test = append(test, "    "+strings.TrimSpace(lines[i]))
+			}
+			tests = append(tests, strings.Join(test, "\n"))
+		case rustTestFail.MatchString(line):
+			consumed[i] = true
+			test := []string{rustTestFail.FindStringSubmatch(line)[1]}
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+				i++
+				consumed[i] = true
+				if !strings.HasPrefix(lines[i], "note:") {
+					test = append(test, "    "+strings.TrimSpace(lines[i]))
+				}
+			}
+			tests = append(tests, strings.Join(test, "\n"))
+		case pytestFail.MatchString(line):
+			consumed[i] = true
+			tests = append(tests, strings.TrimSpace(line))
+		}
+	}
+	return tests, consumed
+}
+
+// isIndentedMore reports whether line is a non-blank line indented deeper
+// than indent
+func isIndentedMore(line string, indent int) bool {
+	trimmed := strings.TrimLeft(line, " \t")
+	return trimmed != "" && len(line)-len(trimmed) > indent && !goTestFail.MatchString(line)
+}
+
+// truncate cuts s to maxChars at a line boundary, noting the cut
+func truncate(s string, maxChars int) string {
+	if len(s) <= maxChars {
+		return s
+	}
+	cut := s[:max(maxChars, 0)]
+	if i := strings.LastIndexByte(cut, '\n'); i > 0 {
+		cut = cut[:i]
+	}
+	for !utf8.ValidString(cut) {
+		cut = cut[:len(cut)-1] // Don't split a multi-byte character
+	}
+	return fmt.Sprintf("%s\n... (%d more characters truncated)", cut, len(s)-len(cut))
+}
@@ -0,0 +1,130 @@
+package aider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+func TestDigest(t *testing.T) {
+	var build strings.Builder
+	build.WriteString("# example.com/app\n")
+	for i := 1; i <= 15; i++ {
+		fmt.Fprintf(&build, "./main.go:%d:2: undefined: helper%d\n", i, i)
+		fmt.Fprintf(&build, "./main.go:%d:2: undefined: helper%d\n", i, i) // Reported twice
+	}
+
+	goTest := `--- FAIL: TestGreet (0.00s)
+    greet_test.go:12: Greet("Bob") = "Hi Bob", want "Hello, Bob"
+    --- FAIL: TestGreet/empty (0.00s)
+        greet_test.go:20: unexpected panic
+FAIL
+FAIL	example.com/app	0.012s
+`
+
+	tests := []struct {
+		name     string
+		output   string
+		maxChars int
+		want     []string
+		notWant  []string
+	}{
+		{
+			name:     "short output is kept without duplicates",
+			output:   "./main.go:3:2: undefined: x\n./main.go:3:2: undefined: x\n",
+			maxChars: 1000,
+			want:     []string{"./main.go:3:2: undefined: x"},
+		},
+		{
+			name:     "first distinct diagnostics",
+			output:   build.String(),
+			maxChars: 400,
+			want:     []string{"Errors (first 10 of 15):", "./main.go:1:2: undefined: helper1", "./main.go:10:2: undefined: helper10"},
+			notWant:  []string{"helper11", "# example.com/app"},
+		},
+		{
+			name:     "go test failures with messages",
+			output:   numbered("=== RUN noise", 20) + goTest,
+			maxChars: 400,
+			want: []string{
+				"Failing tests:\n--- FAIL: TestGreet (0.00s)\n    greet_test.go:12: Greet(\"Bob\") = \"Hi Bob\", want \"Hello, Bob\"",
+				"--- FAIL: TestGreet/empty (0.00s)\n    greet_test.go:20: unexpected panic",
+			},
+			notWant: []string{"Errors", "noise"},
+		},
+		{
+			name: "cargo test failures",
+			output: numbered("test passed", 30) + `
+---- tests::adds stdout ----
+thread 'tests::adds' panicked at src/lib.rs:10:9:
+assertion failed: add(1, 2) == 4
+note: run with ` + "`RUST_BACKTRACE=1`" + ` for a backtrace
+
+test result: FAILED. 39 passed; 1 failed
+`,
+			maxChars: 300,
+			want:     []string{"Failing tests:\ntests::adds\n    thread 'tests::adds' panicked at src/lib.rs:10:9:\n    assertion failed: add(1, 2) == 4"},
+			notWant:  []string{"RUST_BACKTRACE"},
+		},
+		{
+			name:     "pytest summary",
+			output:   numbered("collected", 30) + "FAILED tests/test_greet.py::test_name - AssertionError: assert 'Hi' == 'Hello'\n",
+			maxChars: 200,
+			want:     []string{"FAILED tests/test_greet.py::test_name - AssertionError: assert 'Hi' == 'Hello'"},
+		},
+		{
+			name:     "unrecognized output keeps its start",
+			output:   "make: *** [all] Error 1\n" + numbered("x", 200),
+			maxChars: 60,
+			want:     []string{"make: *** [all] Error 1", "more characters truncated"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := digest(tt.output, 10, tt.maxChars)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("digest missing %q:\n%s", want, got)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("digest should not contain %q:\n%s", notWant, got)
+				}
+			}
+			if len(got) > tt.maxChars+50 {
+				t.Errorf("digest is %d chars, budget %d:\n%s", len(got), tt.maxChars, got)
+			}
+		})
+	}
+}
+
+// numbered returns n distinct lines
+func numbered(prefix string, n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%s %d\n", prefix, i)
+	}
+	return b.String()
+}
+
+func TestDigestError(t *testing.T) {
+	output := strings.Repeat("./main.go:1:1: expected declaration\n./other.go:2:1: syntax error\n", 3) + numbered("context", 100)
+	err := fmt.Errorf("wrapped: %w", &StepError{Step: "go build ./...", Err: errors.New("exit status 1"), Output: output})
+
+	got := digestError(err, config.ModelConfig{MaxDiagnostics: 1, ErrorTokens: 50})
+	want := "go build ./... failed: exit status 1\nErrors (first 1 of 2):\n./main.go:1:1: expected declaration"
+	if got != want {
+		t.Errorf("digestError = %q, want %q", got, want)
+	}
+
+	// Errors without command output are only truncated
+	long := errors.New(strings.Repeat("a", 100))
+	if got := digestError(long, config.ModelConfig{ErrorTokens: 5}); !strings.HasPrefix(got, strings.Repeat("a", 20)+"\n...") {
+		t.Errorf("unexpected truncation: %q", got)
+	}
+}
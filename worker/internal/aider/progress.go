@@ -0,0 +1,55 @@
+package aider
+
+import (
+	"context"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+// Passes reported in Progress
+const (
+	PassImplementation = "implementation" // Pass 1
+	PassTests          = "tests"          // Pass 2
+	PassFollowUp       = "follow-up"      // Single pass on review feedback
+)
+
+// StepAgent is the Progress step while the agent writes the pass's code
+const StepAgent = "agent"
+
+// Progress describes the step a run has reached
+type Progress struct {
+	Model       string
+	Pass        string // See the Pass constants
+	Step        string // StepAgent, or the verification stage (build, lint, test)
+	Attempt     int    // Verification attempt, from 1 (0 for StepAgent)
+	MaxAttempts int
+}
+
+// ProgressFunc receives a run's progress. It is called from the run's
+// goroutine, so it should return quickly.
+type ProgressFunc func(Progress)
+
+type progressKey struct{}
+
+type passKey struct{}
+
+// WithProgress returns a context that reports the progress of runs made
+// with it to fn
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// withPass tags ctx with the pass being run, for progress reports
+func withPass(ctx context.Context, pass string) context.Context {
+	return context.WithValue(ctx, passKey{}, pass)
+}
+
+// reportProgress sends a progress report if ctx has a ProgressFunc
+func reportProgress(ctx context.Context, model config.ModelConfig, step string, attempt, maxAttempts int) {
+	fn, ok := ctx.Value(progressKey{}).(ProgressFunc)
+	if !ok {
+		return
+	}
+	pass, _ := ctx.Value(passKey{}).(string)
+	fn(Progress{Model: model.Name, Pass: pass, Step: step, Attempt: attempt, MaxAttempts: maxAttempts})
+}
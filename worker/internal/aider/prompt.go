@@ -0,0 +1,172 @@
+package aider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/agent"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+// Prompt kinds, each rendered from its own template
+const (
+	PromptImplementation = "implementation" // Pass 1
+	PromptTests          = "tests"          // Pass 2
+	PromptFix            = "fix"            // Verification failures
+)
+
+// Task is the issue a run works on
+type Task struct {
+	Title      string
+	Body       string
+	Labels     []string
+	Repository string
+}
+
+// PromptData is what prompt templates are executed with
+type PromptData struct {
+	Task
+	Model          string   // Model the prompt is sent to
+	Stage          string   // Fix: the failing verification stage (build, lint or test)
+	Error          string   // Fix: the failure, with the command output
+	PreviousErrors []string // Fix: earlier failures in the same pass, oldest first
+	ChangedFiles   []string // Files changed since the task started
+}
+
+// defaultPrompts are used for kinds without a configured template
+var defaultPrompts = map[string]*template.Template{
+	PromptImplementation: mustPrompt(PromptImplementation, "{{.Title}}{{if .Body}}\n\n{{.Body}}{{end}}"),
+	PromptTests:          mustPrompt(PromptTests, "Add unit tests for the changes made for: {{.Title}}"),
+	PromptFix:            mustPrompt(PromptFix, `Fix the following {{.Stage}} {{if eq .Stage "test"}}failure{{else}}error{{end}}:{{"\n\n"}}{{.Error}}`),
+}
+
+var promptFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+func mustPrompt(kind, text string) *template.Template {
+	return template.Must(template.New(kind).Funcs(promptFuncs).Parse(text))
+}
+
+// parsePrompts parses the templates named in files into templates,
+// replacing those already there. With a dir (the clone), paths must be
+// local to it and are read through an os.Root, so neither ".." nor a
+// symlink can pull in a host file.
+func parsePrompts(templates map[string]*template.Template, files config.PromptFiles, dir string) error {
+	read := os.ReadFile
+	if dir != "" {
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			return fmt.Errorf("failed to open work directory: %w", err)
+		}
+		defer root.Close()
+		read = func(path string) ([]byte, error) {
+			if !filepath.IsLocal(path) {
+				return nil, fmt.Errorf("%q is not inside the repository", path)
+			}
+			return root.ReadFile(path)
+		}
+	}
+
+	for kind, path := range map[string]string{
+		PromptImplementation: files.Implementation,
+		PromptTests:          files.Tests,
+		PromptFix:            files.Fix,
+	} {
+		if path == "" {
+			continue
+		}
+		data, err := read(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s prompt template: %w", kind, err)
+		}
+		tmpl, err := template.New(kind).Funcs(promptFuncs).Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("failed to parse %s prompt template: %w", kind, err)
+		}
+		templates[kind] = tmpl
+	}
+	return nil
+}
+
+// modelPrompts layers the prompt templates for model: aider.prompts, then
+// the model's own, then the repository's (already parsed)
+func (r *Runner) modelPrompts(model config.ModelConfig, repo map[string]*template.Template) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	if err := parsePrompts(templates, r.config.Prompts, ""); err != nil {
+		return nil, err
+	}
+	if err := parsePrompts(templates, model.Prompts, ""); err != nil {
+		return nil, fmt.Errorf("model %s: %w", model.Name, err)
+	}
+	for kind, tmpl := range repo {
+		templates[kind] = tmpl
+	}
+	return templates, nil
+}
+
+// CheckPrompts parses the configured prompt templates, so that mistakes
+// show up at startup. Templates are read again for every task, so edits
+// apply without a restart.
+func (r *Runner) CheckPrompts() error {
+	for _, model := range r.config.Models {
+		if _, err := r.modelPrompts(model, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promptSet renders the prompts of one model's run. It travels in the
+// run's context, like runStats.
+type promptSet struct {
+	templates map[string]*template.Template // Prompt kind -> template
+	task      Task
+	workDir   string
+	base      string              // Commit the task started from
+	failures  map[string][]string // Pass -> verification failures so far
+}
+
+type promptsKey struct{}
+
+func withPrompts(ctx context.Context, p *promptSet) context.Context {
+	return context.WithValue(ctx, promptsKey{}, p)
+}
+
+// renderPrompt executes the kind's template for model, filling in the task,
+// the changed files and, for fix prompts, the pass's earlier failures
+func renderPrompt(ctx context.Context, kind string, model config.ModelConfig, data PromptData) (string, error) {
+	p, _ := ctx.Value(promptsKey{}).(*promptSet)
+	if p == nil {
+		p = &promptSet{}
+	}
+	tmpl, ok := p.templates[kind]
+	if !ok {
+		tmpl = defaultPrompts[kind]
+	}
+
+	data.Task = p.task
+	data.Model = model.Name
+	if p.workDir != "" {
+		files, err := agent.ChangedFiles(ctx, p.workDir, p.base)
+		if err != nil {
+			return "", err
+		}
+		data.ChangedFiles = files
+	}
+	if kind == PromptFix && p.failures != nil {
+		pass, _ := ctx.Value(passKey{}).(string)
+		data.PreviousErrors = p.failures[pass]
+		p.failures[pass] = append(p.failures[pass], data.Error)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("%s prompt template failed: %w", kind, err)
+	}
+	return b.String(), nil
+}
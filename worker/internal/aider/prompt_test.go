@@ -0,0 +1,154 @@
+package aider
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+func TestRenderPrompt_Defaults(t *testing.T) {
+	model := config.ModelConfig{Name: "m"}
+	ctx := withPass(withPrompts(context.Background(), &promptSet{task: Task{Title: "Add greeting", Body: "Say hello"}}), PassTests)
+
+	tests := []struct {
+		kind string
+		data PromptData
+		want string
+	}{
+		{PromptImplementation, PromptData{}, "Add greeting\n\nSay hello"},
+		{PromptTests, PromptData{}, "Add unit tests for the changes made for: Add greeting"},
+		{PromptFix, PromptData{Stage: "build", Error: "undefined: x"}, "Fix the following build error:\n\nundefined: x"},
+		{PromptFix, PromptData{Stage: "test", Error: "FAIL"}, "Fix the following test failure:\n\nFAIL"},
+	}
+	for _, tt := range tests {
+		got, err := renderPrompt(ctx, tt.kind, model, tt.data)
+		if err != nil {
+			t.Fatalf("renderPrompt(%s) failed: %v", tt.kind, err)
+		}
+		if got != tt.want {
+			t.Errorf("renderPrompt(%s) = %q, want %q", tt.kind, got, tt.want)
+		}
+	}
+
+	// Without a body the implementation prompt is just the title
+	ctx = withPrompts(context.Background(), &promptSet{task: Task{Title: "Add greeting"}})
+	if got, _ := renderPrompt(ctx, PromptImplementation, model, PromptData{}); got != "Add greeting" {
+		t.Errorf("unexpected prompt without body: %q", got)
+	}
+}
+
+func TestRenderPrompt_PreviousErrors(t *testing.T) {
+	p := &promptSet{
+		templates: map[string]*template.Template{PromptFix: mustPrompt(PromptFix, "{{join .PreviousErrors \";\"}} -> {{.Error}}")},
+		failures:  make(map[string][]string),
+	}
+	build := withPass(withPrompts(context.Background(), p), PassImplementation)
+	tests := withPass(withPrompts(context.Background(), p), PassTests)
+	model := config.ModelConfig{Name: "m"}
+
+	renderPrompt(build, PromptFix, model, PromptData{Error: "e1"})
+	got, _ := renderPrompt(build, PromptFix, model, PromptData{Error: "e2"})
+	if got != "e1 -> e2" {
+		t.Errorf("second fix prompt = %q", got)
+	}
+	// Each pass starts without earlier failures
+	if got, _ := renderPrompt(tests, PromptFix, model, PromptData{Error: "e3"}); got != " -> e3" {
+		t.Errorf("first fix prompt of pass 2 = %q", got)
+	}
+}
+
+func TestRunWithTests_PromptTemplates(t *testing.T) {
+	workDir := newVerifiedRepo(t)
+	dir := t.TempDir()
+	write := func(path, content string) string {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+		return path
+	}
+	global := config.PromptFiles{
+		Implementation: write(filepath.Join(dir, "impl.tmpl"), "global {{.Title}}"),
+		Tests:          write(filepath.Join(dir, "tests.tmpl"), "global tests"),
+	}
+	model := config.PromptFiles{
+		Implementation: write(filepath.Join(dir, "impl-small.tmpl"), "[{{.Model}}] {{.Title}} in {{.Repository}} ({{join .Labels \",\"}})"),
+	}
+	// The repository's template wins over the worker's
+	write(filepath.Join(workDir, ".codingworker", "fix.tmpl"),
+		"{{.Stage}} attempt {{len .PreviousErrors}}; changed: {{join .ChangedFiles \",\"}}")
+	write(filepath.Join(workDir, config.RepoConfigFile),
+		"verify:\n  build:\n    - run: \"test -f ok\"\nprompts:\n  fix: \".codingworker/fix.tmpl\"\n")
+	for _, args := range [][]string{
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "prompts"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = workDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+
+	fake := &fixingAgent{fakeAgent: fakeAgent{behaviors: map[string]map[string]string{"small": {"broken": "x"}}}}
+	r := NewRunner(config.AiderConfig{
+		Prompts: global,
+		Models:  []config.ModelConfig{{Name: "small", Timeout: 10, Prompts: model}},
+	}, fake)
+
+	task := Task{Title: "Add greeting", Repository: "owner/repo", Labels: []string{"auto-code", "go"}}
+	if _, err := r.RunWithTests(context.Background(), "fake", workDir, task); err != nil {
+		t.Fatalf("RunWithTests failed: %v", err)
+	}
+
+	want := []string{
+		"[small] Add greeting in owner/repo (auto-code,go)",
+		"build attempt 0; changed: broken",
+		"global tests",
+	}
+	if strings.Join(fake.prompts, "|") != strings.Join(want, "|") {
+		t.Errorf("prompts = %q, want %q", fake.prompts, want)
+	}
+}
+
+func TestParsePrompts_RepoPathsStayInClone(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.tmpl")
+	os.WriteFile(outside, []byte("secret"), 0644)
+	workDir := t.TempDir()
+	os.WriteFile(filepath.Join(workDir, "fix.tmpl"), []byte("fix"), 0644)
+	if err := os.Symlink(outside, filepath.Join(workDir, "link.tmpl")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	for _, path := range []string{"link.tmpl", "../secret.tmpl", outside} {
+		err := parsePrompts(make(map[string]*template.Template), config.PromptFiles{Fix: path}, workDir)
+		if err == nil {
+			t.Errorf("expected %s to be rejected", path)
+		}
+	}
+
+	templates := make(map[string]*template.Template)
+	if err := parsePrompts(templates, config.PromptFiles{Fix: "fix.tmpl"}, workDir); err != nil || templates[PromptFix] == nil {
+		t.Errorf("expected fix.tmpl to be parsed, got %v", err)
+	}
+}
+
+func TestCheckPrompts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fix.tmpl")
+	os.WriteFile(path, []byte("{{.Error"), 0644)
+
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{{Name: "m", Prompts: config.PromptFiles{Fix: path}}}})
+	if err := r.CheckPrompts(); err == nil || !strings.Contains(err.Error(), "model m") {
+		t.Errorf("expected a parse error for model m, got %v", err)
+	}
+
+	r = NewRunner(config.AiderConfig{Prompts: config.PromptFiles{Tests: filepath.Join(t.TempDir(), "missing")}, Models: []config.ModelConfig{{Name: "m"}}})
+	if err := r.CheckPrompts(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing file error, got %v", err)
+	}
+}
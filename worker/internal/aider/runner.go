@@ -6,153 +6,352 @@ import (
 	"fmt"
 	"log/slog"
 	"os/exec"
-	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
+	"github.com/OkadaSatoshi/codingworker/worker/internal/agent"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/artifact"
 	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
 )
 
 const (
-	// maxFixAttempts is the maximum number of times to ask Aider to fix errors
+	// maxFixAttempts is the default number of verification attempts per pass
+	// (models can override it with max_fix_attempts)
 	maxFixAttempts = 3
 )
 
-// Runner executes Aider commands
+// ErrNoChanges is returned when the implementation run leaves the tree unchanged
+var ErrNoChanges = errors.New("agent made no changes")
+
+// FixExhaustedError is returned when verification still fails after the
+// model's fix attempts are used up
+type FixExhaustedError struct {
+	Stage    string // build, lint or test
+	Attempts int
+	Err      error
+}
+
+func (e *FixExhaustedError) Error() string {
+	return fmt.Sprintf("%s failed after %d fix attempts: %v", e.Stage, e.Attempts, e.Err)
+}
+
+func (e *FixExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// Runner drives a coding agent through the implementation and test passes,
+// verifying the result and asking the agent to fix failures
 type Runner struct {
-	config config.AiderConfig
+	config     config.AiderConfig
+	agents     map[string]agent.Agent   // Backend name -> agent
+	modelSlots map[string]chan struct{} // Per-model concurrency limits
 }
 
-// NewRunner creates a new Aider runner
-func NewRunner(cfg config.AiderConfig) *Runner {
+// NewRunner creates a new runner. The Aider CLI agent is always available;
+// extra agents (e.g. the direct API agent) are registered by name.
+func NewRunner(cfg config.AiderConfig, extra ...agent.Agent) *Runner {
+	slots := make(map[string]chan struct{})
+	for _, model := range cfg.Models {
+		if model.MaxConcurrent > 0 {
+			slots[model.Name] = make(chan struct{}, model.MaxConcurrent)
+		}
+	}
+
+	agents := map[string]agent.Agent{agent.BackendAider: NewCLI(cfg)}
+	for _, a := range extra {
+		agents[a.Name()] = a
+	}
+
 	return &Runner{
-		config: cfg,
+		config:     cfg,
+		agents:     agents,
+		modelSlots: slots,
 	}
 }
 
-// Run executes Aider with the given task, with model fallback on timeout
-func (r *Runner) Run(ctx context.Context, workDir, title, body string) error {
-	var lastErr error
+// HasBackend reports whether an agent is registered for backend
+func (r *Runner) HasBackend(backend string) bool {
+	_, ok := r.agents[backend]
+	return ok
+}
+
+// WithModel returns a runner that uses only the configured model matching
+// name, either its full name or the part after the provider prefix
+// (e.g. "qwen2.5-coder:7b" for "ollama_chat/qwen2.5-coder:7b")
+func (r *Runner) WithModel(name string) (*Runner, error) {
+	var names []string
+	for _, model := range r.config.Models {
+		if model.Name == name || strings.HasSuffix(model.Name, "/"+name) {
+			cfg := r.config
+			cfg.Models = []config.ModelConfig{model}
+			return &Runner{config: cfg, agents: r.agents, modelSlots: r.modelSlots}, nil
+		}
+		names = append(names, model.Name)
+	}
+	return nil, fmt.Errorf("unknown model %q (configured: %s)", name, strings.Join(names, ", "))
+}
+
+// acquireModel waits for a free slot for the model and returns its release func
+func (r *Runner) acquireModel(ctx context.Context, name string) (func(), error) {
+	slots, ok := r.modelSlots[name]
+	if !ok {
+		return func() {}, nil // Unlimited
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Result describes how a task's code was generated and verified
+type Result struct {
+	Agent       string           // Backend that generated the code
+	Model       string           // Model that produced the final code
+	Models      []string         // Every model tried, in order
+	Profile     string           // Verification profile (detected, built-in or custom)
+	Invocations int              // Agent runs across every model tried
+	Passes      []PassStats      // Passes of the final model
+	Steps       []StepResult     // Verification runs of the final model, in order
+	Files       []agent.FileStat // Files changed by the task
+}
+
+// RunWithTests executes the agent for backend in 2 passes: implementation +
+// test creation. Each pass includes retry-with-fix logic for build/lint/test
+// failures, using the verification steps from .codingworker.yml or the
+// detected profile. Prompts come from the configured templates (see
+// PromptData).
+func (r *Runner) RunWithTests(ctx context.Context, backend, workDir string, task Task) (*Result, error) {
+	return r.run(ctx, backend, workDir, task, func(ctx context.Context, a agent.Agent, verify config.VerifyConfig, model config.ModelConfig) error {
+		return r.runPasses(ctx, a, workDir, verify, model)
+	})
+}
+
+// RunFollowUp executes the agent once with prompt (e.g. review feedback on
+// an existing branch), then runs the full verification loop
+func (r *Runner) RunFollowUp(ctx context.Context, backend, workDir string, task Task, prompt string) (*Result, error) {
+	ctx = withPass(ctx, PassFollowUp)
+	return r.run(ctx, backend, workDir, task, func(ctx context.Context, a agent.Agent, verify config.VerifyConfig, model config.ModelConfig) error {
+		reportProgress(ctx, model, StepAgent, 0, 0)
+		res, err := r.runWithModel(ctx, a, workDir, prompt, model)
+		if err != nil {
+			return err
+		}
+		if len(res.ChangedFiles) == 0 {
+			return ErrNoChanges
+		}
+		return r.verifyAllWithFixes(ctx, a, workDir, verify, model)
+	})
+}
+
+// run loads the verification config and prompt templates and calls passes
+// with each configured model in turn, falling back per the model's
+// fallback_on rules
+func (r *Runner) run(ctx context.Context, backend, workDir string, task Task, passes func(ctx context.Context, a agent.Agent, verify config.VerifyConfig, model config.ModelConfig) error) (*Result, error) {
+	a, ok := r.agents[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown agent backend: %s", backend)
+	}
+
+	// Load before the agent runs so generated changes cannot alter verification
+	repoCfg, err := config.LoadRepo(workDir)
+	if err != nil {
+		return nil, err
+	}
+	verify := repoCfg.Verify
+	repoPrompts := make(map[string]*template.Template)
+	if err := parsePrompts(repoPrompts, repoCfg.Prompts, workDir); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", config.RepoConfigFile, err)
+	}
+	result := &Result{Agent: a.Name(), Profile: repoCfg.Profile}
+
+	slog.Info("Verification profile selected",
+		"agent", a.Name(),
+		"profile", repoCfg.Profile,
+		"build_steps", len(verify.Build),
+		"lint_steps", len(verify.Lint),
+		"test_steps", len(verify.Test),
+	)
+	if verify.IsEmpty() {
+		slog.Warn("Project type not detected, skipping verification")
+	}
+
+	// Each model starts over from the clone, so remember where it began
+	base, err := agent.Head(ctx, workDir)
+	if err != nil {
+		return nil, err
+	}
 
 	for i, model := range r.config.Models {
-		err := r.runWithModel(ctx, workDir, title, body, model)
+		result.Models = append(result.Models, model.Name)
+		templates, err := r.modelPrompts(model, repoPrompts)
+		if err != nil {
+			return nil, err
+		}
+		prompts := &promptSet{templates: templates, task: task, workDir: workDir, base: base, failures: make(map[string][]string)}
+		stats := &runStats{}
+		err = passes(withPrompts(withStats(ctx, stats), prompts), a, verify, model)
+		result.Invocations += stats.invocations()
 		if err == nil {
-			return nil // 成功
+			result.Model = model.Name
+			result.Passes, result.Steps = stats.passes, stats.steps
+			if result.Files, err = agent.DiffStat(ctx, workDir, base); err != nil {
+				slog.Warn("Failed to summarize changed files", "error", err)
+			}
+			return result, nil
 		}
-		lastErr = err
 
-		// タイムアウトの場合のみフォールバック
-		if !errors.Is(err, context.DeadlineExceeded) {
-			// タイムアウト以外のエラー → フォールバックせず終了
-			return err
+		reason := fallbackReason(err)
+		if ctx.Err() != nil || i == len(r.config.Models)-1 || !model.FallsBackOn(reason) {
+			return nil, err
 		}
 
-		if i < len(r.config.Models)-1 {
-			slog.Warn("Model timed out, trying next",
-				"failed_model", model.Name,
-				"next_model", r.config.Models[i+1].Name,
-			)
+		slog.Warn("Model failed, falling back to next model",
+			"failed_model", model.Name,
+			"next_model", r.config.Models[i+1].Name,
+			"reason", reason,
+			"error", err,
+		)
+		if err := agent.Reset(ctx, workDir, base); err != nil {
+			return nil, err
 		}
 	}
-	return fmt.Errorf("all models timed out: %w", lastErr)
+	return nil, fmt.Errorf("no models configured")
 }
 
-// RunWithTests executes Aider in 2 passes: implementation + test creation
-// Each pass includes retry-with-fix logic for build/lint/test failures
-func (r *Runner) RunWithTests(ctx context.Context, workDir, title, body string) error {
+// runPasses runs both passes with a single model
+func (r *Runner) runPasses(ctx context.Context, a agent.Agent, workDir string, verify config.VerifyConfig, model config.ModelConfig) error {
 	// Pass 1: Implementation with build verification
-	slog.Info("Pass 1: Running implementation")
-	if err := r.runAndVerifyBuild(ctx, workDir, title, body); err != nil {
+	slog.Info("Pass 1: Running implementation", "model", model.Name)
+	prompt, err := renderPrompt(ctx, PromptImplementation, model, PromptData{})
+	if err != nil {
+		return err
+	}
+	if err := r.runAndVerifyBuild(withPass(ctx, PassImplementation), a, workDir, prompt, verify, model); err != nil {
 		return fmt.Errorf("pass 1 (implementation) failed: %w", err)
 	}
 
 	// Pass 2: Test creation with full verification
-	slog.Info("Pass 2: Running test creation")
-	testPrompt := fmt.Sprintf("Add unit tests for the changes made for: %s", title)
-	if err := r.runAndVerifyAll(ctx, workDir, testPrompt); err != nil {
+	slog.Info("Pass 2: Running test creation", "model", model.Name)
+	testPrompt, err := renderPrompt(ctx, PromptTests, model, PromptData{})
+	if err != nil {
+		return err
+	}
+	if err := r.runAndVerifyAll(withPass(ctx, PassTests), a, workDir, testPrompt, verify, model); err != nil {
 		return fmt.Errorf("pass 2 (test creation) failed: %w", err)
 	}
 
 	return nil
 }
 
-// runAndVerifyBuild runs Aider and verifies build, retrying with fix prompts on failure
-func (r *Runner) runAndVerifyBuild(ctx context.Context, workDir, title, body string) error {
+// fallbackReason classifies a failed run for the model's fallback_on rules
+func fallbackReason(err error) string {
+	var exhausted *FixExhaustedError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return config.FallbackTimeout
+	case errors.Is(err, ErrNoChanges), errors.Is(err, agent.ErrNoEdits):
+		return config.FallbackNoDiff
+	case errors.As(err, &exhausted):
+		return config.FallbackFixExhausted
+	default:
+		return config.FallbackError
+	}
+}
+
+// runAndVerifyBuild runs the agent and verifies build, retrying with fix prompts on failure
+func (r *Runner) runAndVerifyBuild(ctx context.Context, a agent.Agent, workDir, prompt string, verify config.VerifyConfig, model config.ModelConfig) error {
 	// Initial run
-	if err := r.Run(ctx, workDir, title, body); err != nil {
+	reportProgress(ctx, model, StepAgent, 0, 0)
+	res, err := r.runWithModel(ctx, a, workDir, prompt, model)
+	if err != nil {
 		return err
 	}
+	if len(res.ChangedFiles) == 0 {
+		return ErrNoChanges
+	}
 
 	// Verify build with retry-fix loop
-	for attempt := 1; attempt <= maxFixAttempts; attempt++ {
-		buildErr := r.verifyBuildWithOutput(ctx, workDir)
+	maxAttempts := fixAttempts(model)
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		reportProgress(ctx, model, "build", attempt, maxAttempts)
+		buildErr := r.verifyWithOutput(ctx, workDir, "build", verify.Build)
 		if buildErr == nil {
 			return nil // Success
 		}
 
-		if attempt == maxFixAttempts {
-			return fmt.Errorf("build failed after %d fix attempts: %w", maxFixAttempts, buildErr)
+		if attempt == maxAttempts {
+			return &FixExhaustedError{Stage: "build", Attempts: maxAttempts, Err: buildErr}
 		}
 
-		slog.Warn("Build failed, asking Aider to fix",
+		slog.Warn("Build failed, asking the agent to fix",
 			"attempt", attempt,
-			"max_attempts", maxFixAttempts,
+			"max_attempts", maxAttempts,
 		)
 
-		// Ask Aider to fix the build error
-		fixPrompt := fmt.Sprintf("Fix the following build error:\n\n%s", buildErr.Error())
-		if err := r.Run(ctx, workDir, fixPrompt, ""); err != nil {
-			return fmt.Errorf("aider fix attempt failed: %w", err)
+		// Ask the agent to fix the build error
+		if err := r.runFix(ctx, a, workDir, "build", buildErr, model); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// runAndVerifyAll runs Aider and verifies build+lint+test, retrying with fix prompts on failure
-func (r *Runner) runAndVerifyAll(ctx context.Context, workDir, prompt string) error {
+// runAndVerifyAll runs the agent and verifies build+lint+test, retrying with fix prompts on failure
+func (r *Runner) runAndVerifyAll(ctx context.Context, a agent.Agent, workDir, prompt string, verify config.VerifyConfig, model config.ModelConfig) error {
 	// Initial run
-	if err := r.Run(ctx, workDir, prompt, ""); err != nil {
+	reportProgress(ctx, model, StepAgent, 0, 0)
+	if _, err := r.runWithModel(ctx, a, workDir, prompt, model); err != nil {
 		return err
 	}
+	return r.verifyAllWithFixes(ctx, a, workDir, verify, model)
+}
 
+// verifyAllWithFixes runs build, lint and test, asking the agent to fix
+// failures until they pass or the model's fix attempts run out
+func (r *Runner) verifyAllWithFixes(ctx context.Context, a agent.Agent, workDir string, verify config.VerifyConfig, model config.ModelConfig) error {
 	// Verify with retry-fix loop
-	for attempt := 1; attempt <= maxFixAttempts; attempt++ {
+	maxAttempts := fixAttempts(model)
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		// Check build
-		if buildErr := r.verifyBuildWithOutput(ctx, workDir); buildErr != nil {
-			if attempt == maxFixAttempts {
-				return fmt.Errorf("build failed after %d fix attempts: %w", maxFixAttempts, buildErr)
+		reportProgress(ctx, model, "build", attempt, maxAttempts)
+		if buildErr := r.verifyWithOutput(ctx, workDir, "build", verify.Build); buildErr != nil {
+			if attempt == maxAttempts {
+				return &FixExhaustedError{Stage: "build", Attempts: maxAttempts, Err: buildErr}
 			}
-			slog.Warn("Build failed, asking Aider to fix", "attempt", attempt)
-			fixPrompt := fmt.Sprintf("Fix the following build error:\n\n%s", buildErr.Error())
-			if err := r.Run(ctx, workDir, fixPrompt, ""); err != nil {
-				return fmt.Errorf("aider fix attempt failed: %w", err)
+			slog.Warn("Build failed, asking the agent to fix", "attempt", attempt)
+			if err := r.runFix(ctx, a, workDir, "build", buildErr, model); err != nil {
+				return err
 			}
 			continue
 		}
 
 		// Check lint
-		if lintErr := r.verifyLintWithOutput(ctx, workDir); lintErr != nil {
-			if attempt == maxFixAttempts {
-				return fmt.Errorf("lint failed after %d fix attempts: %w", maxFixAttempts, lintErr)
+		reportProgress(ctx, model, "lint", attempt, maxAttempts)
+		if lintErr := r.verifyWithOutput(ctx, workDir, "lint", verify.Lint); lintErr != nil {
+			if attempt == maxAttempts {
+				return &FixExhaustedError{Stage: "lint", Attempts: maxAttempts, Err: lintErr}
 			}
-			slog.Warn("Lint failed, asking Aider to fix", "attempt", attempt)
-			fixPrompt := fmt.Sprintf("Fix the following lint error:\n\n%s", lintErr.Error())
-			if err := r.Run(ctx, workDir, fixPrompt, ""); err != nil {
-				return fmt.Errorf("aider fix attempt failed: %w", err)
+			slog.Warn("Lint failed, asking the agent to fix", "attempt", attempt)
+			if err := r.runFix(ctx, a, workDir, "lint", lintErr, model); err != nil {
+				return err
 			}
 			continue
 		}
 
 		// Check tests
-		if testErr := r.verifyTestsWithOutput(ctx, workDir); testErr != nil {
-			if attempt == maxFixAttempts {
-				return fmt.Errorf("tests failed after %d fix attempts: %w", maxFixAttempts, testErr)
+		reportProgress(ctx, model, "test", attempt, maxAttempts)
+		if testErr := r.verifyWithOutput(ctx, workDir, "test", verify.Test); testErr != nil {
+			if attempt == maxAttempts {
+				return &FixExhaustedError{Stage: "test", Attempts: maxAttempts, Err: testErr}
 			}
-			slog.Warn("Tests failed, asking Aider to fix", "attempt", attempt)
-			fixPrompt := fmt.Sprintf("Fix the following test failure:\n\n%s", testErr.Error())
-			if err := r.Run(ctx, workDir, fixPrompt, ""); err != nil {
-				return fmt.Errorf("aider fix attempt failed: %w", err)
+			slog.Warn("Tests failed, asking the agent to fix", "attempt", attempt)
+			if err := r.runFix(ctx, a, workDir, "test", testErr, model); err != nil {
+				return err
 			}
 			continue
 		}
@@ -165,61 +364,30 @@ func (r *Runner) runAndVerifyAll(ctx context.Context, workDir, prompt string) er
 	return nil
 }
 
-// verifyBuildWithOutput runs go build and returns error with output for fix prompts
-func (r *Runner) verifyBuildWithOutput(ctx context.Context, workDir string) error {
-	cmd := exec.CommandContext(ctx, "go", "build", "./...")
-	cmd.Dir = workDir
-	output, err := cmd.CombinedOutput()
+// runFix asks the agent to fix a failure of a verification stage
+func (r *Runner) runFix(ctx context.Context, a agent.Agent, workDir, stage string, verifyErr error, model config.ModelConfig) error {
+	fixPrompt, err := renderPrompt(ctx, PromptFix, model, PromptData{Stage: stage, Error: digestError(verifyErr, model)})
 	if err != nil {
-		slog.Error("Build failed", "output", string(output))
-		// Include output in error for fix prompts
-		return fmt.Errorf("go build failed:\n%s", strings.TrimSpace(string(output)))
-	}
-	slog.Info("Build successful")
-	return nil
-}
-
-// verifyLintWithOutput runs go fmt and go vet, returns error with output for fix prompts
-func (r *Runner) verifyLintWithOutput(ctx context.Context, workDir string) error {
-	// go fmt (auto-fixes, so we just run it)
-	fmtCmd := exec.CommandContext(ctx, "go", "fmt", "./...")
-	fmtCmd.Dir = workDir
-	if output, err := fmtCmd.CombinedOutput(); err != nil {
-		slog.Error("go fmt failed", "output", string(output))
-		return fmt.Errorf("go fmt failed:\n%s", strings.TrimSpace(string(output)))
+		return err
 	}
-
-	// go vet
-	vetCmd := exec.CommandContext(ctx, "go", "vet", "./...")
-	vetCmd.Dir = workDir
-	if output, err := vetCmd.CombinedOutput(); err != nil {
-		slog.Error("go vet failed", "output", string(output))
-		return fmt.Errorf("go vet failed:\n%s", strings.TrimSpace(string(output)))
+	statsFrom(ctx).fixAttempt(ctx)
+	if _, err := r.runWithModel(ctx, a, workDir, fixPrompt, model); err != nil {
+		return fmt.Errorf("fix attempt failed: %w", err)
 	}
-
-	slog.Info("Lint passed")
 	return nil
 }
 
-// verifyTestsWithOutput runs go test and returns error with output for fix prompts
-func (r *Runner) verifyTestsWithOutput(ctx context.Context, workDir string) error {
-	cmd := exec.CommandContext(ctx, "go", "test", "./...")
-	cmd.Dir = workDir
-	output, err := cmd.CombinedOutput()
+// runWithModel executes the agent with a specific model
+func (r *Runner) runWithModel(ctx context.Context, a agent.Agent, workDir, prompt string, model config.ModelConfig) (*agent.Result, error) {
+	// Wait for the model to be free (so concurrent tasks don't overload it)
+	release, err := r.acquireModel(ctx, model.Name)
 	if err != nil {
-		slog.Error("Tests failed", "output", string(output))
-		// Include output in error for fix prompts
-		return fmt.Errorf("go test failed:\n%s", strings.TrimSpace(string(output)))
+		return nil, fmt.Errorf("waiting for model %s: %w", model.Name, err)
 	}
-	slog.Info("Tests passed", "output", strings.TrimSpace(string(output)))
-	return nil
-}
+	defer release()
 
-// runWithModel executes Aider with a specific model
-func (r *Runner) runWithModel(ctx context.Context, workDir, title, body string, model config.ModelConfig) error {
-	prompt := r.buildPrompt(title, body)
-
-	slog.Info("Running Aider",
+	slog.Info("Running agent",
+		"agent", a.Name(),
 		"work_dir", workDir,
 		"model", model.Name,
 		"timeout_seconds", model.Timeout,
@@ -231,53 +399,88 @@ func (r *Runner) runWithModel(ctx context.Context, workDir, title, body string,
 	modelCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	// Build Aider command
-	args := []string{
-		"--model", model.Name,
-		"--yes",           // Auto-confirm changes
-		"--no-auto-lint",  // Skip auto-linting
-		"--map-tokens", strconv.Itoa(r.config.MapTokens),
-		"--message", prompt,
-	}
-
-	cmd := exec.CommandContext(modelCtx, r.config.BinPath, args...)
-	cmd.Dir = workDir
-
-	// Capture output
-	output, err := cmd.CombinedOutput()
+	statsFrom(ctx).agentRun(ctx)
+	started := time.Now()
+	saveArtifacts := r.artifactSaver(ctx, a, workDir, prompt, model)
+	result, err := a.Run(modelCtx, agent.Request{
+		WorkDir: workDir,
+		Prompt:  prompt,
+		Model:   model,
+	})
+	saveArtifacts(result, err, time.Since(started))
 
 	// Check for timeout
 	if modelCtx.Err() == context.DeadlineExceeded {
-		slog.Warn("Aider execution timed out",
+		slog.Warn("Agent execution timed out",
+			"agent", a.Name(),
 			"model", model.Name,
 			"timeout_seconds", model.Timeout,
 		)
-		return context.DeadlineExceeded
+		return nil, context.DeadlineExceeded
 	}
 
 	if err != nil {
-		slog.Error("Aider execution failed",
-			"model", model.Name,
-			"error", err,
-			"output", string(output),
-		)
-		return fmt.Errorf("aider execution failed: %w", err)
+		attrs := []any{"agent", a.Name(), "model", model.Name, "error", err}
+		if result != nil {
+			attrs = append(attrs, "output", result.Transcript)
+		}
+		slog.Error("Agent execution failed", attrs...)
+		return nil, err
 	}
 
-	slog.Info("Aider completed successfully",
+	slog.Info("Agent completed successfully",
+		"agent", a.Name(),
 		"model", model.Name,
-		"output_length", len(output),
+		"changed_files", result.ChangedFiles,
+		"output_length", len(result.Transcript),
 	)
 
-	return nil
+	return result, nil
+}
+
+// artifactSaver records the agent run's prompt, and returns a func that
+// records its transcript and diff once it finished. No-op without an
+// artifact store in ctx.
+func (r *Runner) artifactSaver(ctx context.Context, a agent.Agent, workDir, prompt string, model config.ModelConfig) func(*agent.Result, error, time.Duration) {
+	store := artifact.FromContext(ctx)
+	if store == nil {
+		return func(*agent.Result, error, time.Duration) {}
+	}
+	pass, _ := ctx.Value(passKey{}).(string)
+	store.Write("agent-prompt.md", fmt.Sprintf("<!-- agent: %s, model: %s, pass: %s -->\n\n%s", a.Name(), model.Name, pass, prompt))
+
+	// The diff covers this run only, including Aider's commits
+	start, headErr := agent.Head(ctx, workDir)
+	return func(result *agent.Result, err error, elapsed time.Duration) {
+		status := "ok"
+		if err != nil {
+			status = err.Error()
+		}
+		transcript := ""
+		if result != nil {
+			transcript = result.Transcript
+		}
+		store.Write("agent-transcript.log", fmt.Sprintf("# model: %s, duration: %s, result: %s\n\n%s",
+			model.Name, elapsed.Round(time.Millisecond), status, transcript))
+
+		if headErr != nil {
+			return
+		}
+		diff, err := agent.Diff(context.WithoutCancel(ctx), workDir, start)
+		if err != nil {
+			slog.Warn("Failed to capture agent diff", "error", err)
+			return
+		}
+		store.Write("agent-diff.patch", diff)
+	}
 }
 
-// buildPrompt creates a prompt from issue title and body
-func (r *Runner) buildPrompt(title, body string) string {
-	if body == "" {
-		return title
+// fixAttempts returns the verification attempts allowed per pass for model
+func fixAttempts(model config.ModelConfig) int {
+	if model.MaxFixAttempts > 0 {
+		return model.MaxFixAttempts
 	}
-	return fmt.Sprintf("%s\n\n%s", title, body)
+	return maxFixAttempts
 }
 
 // CheckInstallation verifies Aider is installed and working
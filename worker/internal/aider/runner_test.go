@@ -0,0 +1,357 @@
+package aider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/agent"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/artifact"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+func TestAcquireModel_Limited(t *testing.T) {
+	r := NewRunner(config.AiderConfig{
+		Models: []config.ModelConfig{{Name: "small", MaxConcurrent: 1}},
+	})
+
+	release, err := r.acquireModel(context.Background(), "small")
+	if err != nil {
+		t.Fatalf("acquireModel failed: %v", err)
+	}
+
+	// Second caller blocks until the slot is released
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := r.acquireModel(ctx, "small"); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded while model is busy, got %v", err)
+	}
+
+	release()
+	release2, err := r.acquireModel(context.Background(), "small")
+	if err != nil {
+		t.Fatalf("acquireModel after release failed: %v", err)
+	}
+	release2()
+}
+
+func TestAcquireModel_Unlimited(t *testing.T) {
+	r := NewRunner(config.AiderConfig{
+		Models: []config.ModelConfig{{Name: "big"}},
+	})
+
+	for i := 0; i < 5; i++ {
+		if _, err := r.acquireModel(context.Background(), "big"); err != nil {
+			t.Fatalf("acquireModel failed: %v", err)
+		}
+	}
+}
+
+// fakeAgent writes files per model: behaviors maps model name to the files
+// written on every run (nil = no changes)
+type fakeAgent struct {
+	behaviors map[string]map[string]string
+	calls     []string
+	prompts   []string
+}
+
+func (f *fakeAgent) Name() string { return "fake" }
+
+func (f *fakeAgent) Run(ctx context.Context, req agent.Request) (*agent.Result, error) {
+	f.calls = append(f.calls, req.Model.Name)
+	f.prompts = append(f.prompts, req.Prompt)
+	var changed []string
+	for name, content := range f.behaviors[req.Model.Name] {
+		os.WriteFile(filepath.Join(req.WorkDir, name), []byte(content), 0644)
+		changed = append(changed, name)
+	}
+	return &agent.Result{ChangedFiles: changed}, nil
+}
+
+// newVerifiedRepo creates a git repository whose verification passes only
+// when a file named "ok" exists
+func newVerifiedRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	repoCfg := "verify:\n  build:\n    - run: \"test -f ok\"\n"
+	os.WriteFile(filepath.Join(dir, config.RepoConfigFile), []byte(repoCfg), 0644)
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+	return dir
+}
+
+func TestRunWithTests_FallbackOnFixExhausted(t *testing.T) {
+	workDir := newVerifiedRepo(t)
+	fake := &fakeAgent{behaviors: map[string]map[string]string{
+		"small": {"broken": "x"},
+		"large": {"ok": "x"},
+	}}
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{
+		{Name: "small", Timeout: 10, MaxFixAttempts: 2, FallbackOn: []string{config.FallbackFixExhausted}},
+		{Name: "large", Timeout: 10},
+	}}, fake)
+
+	result, err := r.RunWithTests(context.Background(), "fake", workDir, Task{Title: "task"})
+	if err != nil {
+		t.Fatalf("RunWithTests failed: %v", err)
+	}
+	if result.Model != "large" {
+		t.Errorf("expected large model to produce the code, got %s", result.Model)
+	}
+	if strings.Join(result.Models, ",") != "small,large" {
+		t.Errorf("unexpected models tried: %v", result.Models)
+	}
+	// The small model's output was discarded before the large model ran
+	if _, err := os.Stat(filepath.Join(workDir, "broken")); !os.IsNotExist(err) {
+		t.Error("work tree should be reset between models")
+	}
+	// small: initial run + 1 fix attempt; large: pass 1 + pass 2
+	if strings.Join(fake.calls, ",") != "small,small,large,large" {
+		t.Errorf("unexpected agent calls: %v", fake.calls)
+	}
+
+	// Stats cover every model's runs, but passes and steps only the final one
+	if result.Invocations != 4 {
+		t.Errorf("expected 4 invocations, got %d", result.Invocations)
+	}
+	wantPasses := []PassStats{{Pass: PassImplementation, Invocations: 1}, {Pass: PassTests, Invocations: 1}}
+	if fmt.Sprint(result.Passes) != fmt.Sprint(wantPasses) {
+		t.Errorf("passes = %+v, want %+v", result.Passes, wantPasses)
+	}
+	if len(result.Steps) != 2 || !result.Steps[0].Passed || result.Steps[0].Pass != PassImplementation || result.Steps[1].Pass != PassTests {
+		t.Errorf("unexpected steps: %+v", result.Steps)
+	}
+	if len(result.Files) != 1 || result.Files[0] != (agent.FileStat{Path: "ok", Added: 1}) {
+		t.Errorf("unexpected files: %+v", result.Files)
+	}
+}
+
+func TestRunWithTests_CountsFixAttempts(t *testing.T) {
+	workDir := newVerifiedRepo(t)
+	fake := &fixingAgent{fakeAgent: fakeAgent{behaviors: map[string]map[string]string{"model": {"broken": "x"}}}}
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{{Name: "model", Timeout: 10}}}, fake)
+
+	result, err := r.RunWithTests(context.Background(), "fake", workDir, Task{Title: "task"})
+	if err != nil {
+		t.Fatalf("RunWithTests failed: %v", err)
+	}
+	if len(result.Passes) == 0 || result.Passes[0] != (PassStats{Pass: PassImplementation, Invocations: 2, FixAttempts: 1}) {
+		t.Errorf("unexpected pass 1 stats: %+v", result.Passes)
+	}
+	if len(result.Steps) < 2 || result.Steps[0].Passed || !result.Steps[1].Passed {
+		t.Errorf("expected a failed then a passed build, got %+v", result.Steps)
+	}
+}
+
+// fixingAgent behaves like fakeAgent, and also creates the "ok" file on
+// every run after the first, so the first fix attempt succeeds
+type fixingAgent struct {
+	fakeAgent
+}
+
+func (f *fixingAgent) Run(ctx context.Context, req agent.Request) (*agent.Result, error) {
+	if len(f.calls) > 0 {
+		os.WriteFile(filepath.Join(req.WorkDir, "ok"), []byte("x"), 0644)
+	}
+	return f.fakeAgent.Run(ctx, req)
+}
+
+func TestRunWithTests_FallbackOnNoDiff(t *testing.T) {
+	workDir := newVerifiedRepo(t)
+	fake := &fakeAgent{behaviors: map[string]map[string]string{
+		"small": nil,
+		"large": {"ok": "x"},
+	}}
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{
+		{Name: "small", Timeout: 10, FallbackOn: []string{config.FallbackNoDiff}},
+		{Name: "large", Timeout: 10},
+	}}, fake)
+
+	result, err := r.RunWithTests(context.Background(), "fake", workDir, Task{Title: "task"})
+	if err != nil {
+		t.Fatalf("RunWithTests failed: %v", err)
+	}
+	if result.Model != "large" {
+		t.Errorf("expected large model, got %s", result.Model)
+	}
+}
+
+func TestRunWithTests_NoFallbackWithoutRule(t *testing.T) {
+	workDir := newVerifiedRepo(t)
+	fake := &fakeAgent{behaviors: map[string]map[string]string{
+		"small": nil,
+		"large": {"ok": "x"},
+	}}
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{
+		{Name: "small", Timeout: 10, FallbackOn: []string{config.FallbackTimeout}},
+		{Name: "large", Timeout: 10},
+	}}, fake)
+
+	_, err := r.RunWithTests(context.Background(), "fake", workDir, Task{Title: "task"})
+	if !errors.Is(err, ErrNoChanges) {
+		t.Errorf("expected ErrNoChanges, got %v", err)
+	}
+	if strings.Join(fake.calls, ",") != "small" {
+		t.Errorf("large model should not run: %v", fake.calls)
+	}
+}
+
+func TestRunWithTests_ReportsProgress(t *testing.T) {
+	workDir := newVerifiedRepo(t)
+	fake := &fakeAgent{behaviors: map[string]map[string]string{"model": {"ok": "x"}}}
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{{Name: "model", Timeout: 10, MaxFixAttempts: 2}}}, fake)
+
+	var got []string
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		if p.Model != "model" {
+			t.Errorf("unexpected model in %+v", p)
+		}
+		got = append(got, fmt.Sprintf("%s/%s/%d/%d", p.Pass, p.Step, p.Attempt, p.MaxAttempts))
+	})
+	if _, err := r.RunWithTests(ctx, "fake", workDir, Task{Title: "task"}); err != nil {
+		t.Fatalf("RunWithTests failed: %v", err)
+	}
+
+	want := []string{
+		"implementation/agent/0/0",
+		"implementation/build/1/2",
+		"tests/agent/0/0",
+		"tests/build/1/2",
+		"tests/lint/1/2",
+		"tests/test/1/2",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("progress = %v, want %v", got, want)
+	}
+}
+
+func TestRunWithTests_SavesArtifacts(t *testing.T) {
+	workDir := newVerifiedRepo(t)
+	fake := &fakeAgent{behaviors: map[string]map[string]string{"model": {"ok": "x"}}}
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{{Name: "model", Timeout: 10}}}, fake)
+
+	store, err := artifact.New(t.TempDir(), "task")
+	if err != nil {
+		t.Fatalf("artifact.New failed: %v", err)
+	}
+	if _, err := r.RunWithTests(artifact.WithStore(context.Background(), store), "fake", workDir, Task{Title: "task"}); err != nil {
+		t.Fatalf("RunWithTests failed: %v", err)
+	}
+
+	var names []string
+	for _, entry := range store.Entries() {
+		names = append(names, entry.Name)
+	}
+	want := []string{
+		"001-agent-prompt.md", "002-agent-transcript.log", "003-agent-diff.patch",
+		"004-verify-build-test_-f_ok.log",
+		"005-agent-prompt.md", "006-agent-transcript.log", "007-agent-diff.patch",
+		"008-verify-build-test_-f_ok.log",
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("artifacts = %v, want %v", names, want)
+	}
+
+	diff, _ := os.ReadFile(store.Entries()[2].Path)
+	if !strings.Contains(string(diff), "+++ b/ok") {
+		t.Errorf("first diff should add the ok file:\n%s", diff)
+	}
+	verify, _ := os.ReadFile(store.Entries()[3].Path)
+	if !strings.HasPrefix(string(verify), "$ test -f ok\n") || !strings.Contains(string(verify), "result: passed") {
+		t.Errorf("unexpected verification artifact:\n%s", verify)
+	}
+}
+
+func TestRunFollowUp(t *testing.T) {
+	workDir := newVerifiedRepo(t)
+	fake := &fakeAgent{behaviors: map[string]map[string]string{
+		"model": {"ok": "x"},
+	}}
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{{Name: "model", Timeout: 10}}}, fake)
+
+	result, err := r.RunFollowUp(context.Background(), "fake", workDir, Task{Title: "task"}, "Address the review")
+	if err != nil {
+		t.Fatalf("RunFollowUp failed: %v", err)
+	}
+	if result.Model != "model" || result.Agent != "fake" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	// One run with the review prompt; verification passed without fixes
+	if len(fake.prompts) != 1 || fake.prompts[0] != "Address the review" {
+		t.Errorf("unexpected prompts: %v", fake.prompts)
+	}
+}
+
+func TestRunFollowUp_NoChanges(t *testing.T) {
+	workDir := newVerifiedRepo(t)
+	fake := &fakeAgent{behaviors: map[string]map[string]string{"model": nil}}
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{{Name: "model", Timeout: 10}}}, fake)
+
+	_, err := r.RunFollowUp(context.Background(), "fake", workDir, Task{Title: "task"}, "Address the review")
+	if !errors.Is(err, ErrNoChanges) {
+		t.Errorf("expected ErrNoChanges, got %v", err)
+	}
+}
+
+func TestWithModel(t *testing.T) {
+	r := NewRunner(config.AiderConfig{Models: []config.ModelConfig{
+		{Name: "ollama_chat/qwen2.5-coder:1.5b"},
+		{Name: "ollama_chat/qwen2.5-coder:7b", MaxConcurrent: 1},
+	}})
+
+	for _, name := range []string{"qwen2.5-coder:7b", "ollama_chat/qwen2.5-coder:7b"} {
+		only, err := r.WithModel(name)
+		if err != nil {
+			t.Fatalf("WithModel(%q) failed: %v", name, err)
+		}
+		if len(only.config.Models) != 1 || only.config.Models[0].Name != "ollama_chat/qwen2.5-coder:7b" {
+			t.Errorf("WithModel(%q) models = %+v", name, only.config.Models)
+		}
+		// Concurrency limits are shared with the original runner
+		if only.modelSlots["ollama_chat/qwen2.5-coder:7b"] != r.modelSlots["ollama_chat/qwen2.5-coder:7b"] {
+			t.Error("model slots should be shared")
+		}
+	}
+
+	if _, err := r.WithModel("coder:7b"); err == nil {
+		t.Error("expected error for a partial model name")
+	}
+}
+
+func TestFallbackReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"timeout", fmt.Errorf("pass 1: %w", context.DeadlineExceeded), config.FallbackTimeout},
+		{"no changes", fmt.Errorf("pass 1: %w", ErrNoChanges), config.FallbackNoDiff},
+		{"no edits", fmt.Errorf("fix attempt failed: %w", agent.ErrNoEdits), config.FallbackNoDiff},
+		{"fix exhausted", fmt.Errorf("pass 2: %w", &FixExhaustedError{Stage: "test", Attempts: 3, Err: errors.New("FAIL")}), config.FallbackFixExhausted},
+		{"other", errors.New("aider execution failed"), config.FallbackError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fallbackReason(tt.err); got != tt.expected {
+				t.Errorf("fallbackReason() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
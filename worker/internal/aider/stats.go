@@ -0,0 +1,83 @@
+package aider
+
+import (
+	"context"
+	"time"
+)
+
+// PassStats counts the agent runs of one pass
+type PassStats struct {
+	Pass        string // See the Pass constants
+	Invocations int    // Agent runs, including fix runs
+	FixAttempts int    // Runs asking the agent to fix a verification failure
+}
+
+// StepResult is one run of a verification command
+type StepResult struct {
+	Pass     string
+	Stage    string // build, lint or test
+	Name     string
+	Run      string
+	Passed   bool
+	Duration time.Duration
+}
+
+// runStats records what one model's passes did. A Runner serves several
+// tasks at once, so the stats travel in the run's context.
+type runStats struct {
+	passes []PassStats
+	steps  []StepResult
+}
+
+type statsKey struct{}
+
+func withStats(ctx context.Context, s *runStats) context.Context {
+	return context.WithValue(ctx, statsKey{}, s)
+}
+
+// statsFrom returns the stats of ctx, or nil (whose methods do nothing)
+func statsFrom(ctx context.Context) *runStats {
+	s, _ := ctx.Value(statsKey{}).(*runStats)
+	return s
+}
+
+// pass returns the counters of ctx's pass, adding them on first use
+func (s *runStats) pass(ctx context.Context) *PassStats {
+	name, _ := ctx.Value(passKey{}).(string)
+	for i := range s.passes {
+		if s.passes[i].Pass == name {
+			return &s.passes[i]
+		}
+	}
+	s.passes = append(s.passes, PassStats{Pass: name})
+	return &s.passes[len(s.passes)-1]
+}
+
+func (s *runStats) agentRun(ctx context.Context) {
+	if s != nil {
+		s.pass(ctx).Invocations++
+	}
+}
+
+func (s *runStats) fixAttempt(ctx context.Context) {
+	if s != nil {
+		s.pass(ctx).FixAttempts++
+	}
+}
+
+func (s *runStats) step(ctx context.Context, result StepResult) {
+	if s == nil {
+		return
+	}
+	result.Pass, _ = ctx.Value(passKey{}).(string)
+	s.steps = append(s.steps, result)
+}
+
+// invocations returns the agent runs across all passes
+func (s *runStats) invocations() int {
+	n := 0
+	for _, p := range s.passes {
+		n += p.Invocations
+	}
+	return n
+}
@@ -0,0 +1,127 @@
+package aider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/artifact"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+// stepWaitDelay bounds how long a killed step may hold its output open
+const stepWaitDelay = 5 * time.Second
+
+// stepEnvNames are the worker's environment variables passed on to
+// verification steps, which run commands from the repository. Everything
+// else (GITHUB_TOKEN, AWS credentials, API keys) stays out of their reach.
+var stepEnvNames = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "LOGNAME": true, "SHELL": true,
+	"TMPDIR": true, "TZ": true, "LANG": true, "TERM": true,
+	"CGO_ENABLED": true, "CC": true, "CXX": true,
+	"CARGO_HOME": true, "RUSTUP_HOME": true, "RUSTUP_TOOLCHAIN": true,
+}
+
+// stepEnv returns the allowlisted part of environ: stepEnvNames, locale
+// settings (LC_*) and Go's own settings (GOPATH, GOFLAGS, ...), which have
+// no underscore, unlike GOOGLE_APPLICATION_CREDENTIALS
+func stepEnv(environ []string) []string {
+	var env []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if stepEnvNames[name] || strings.HasPrefix(name, "LC_") ||
+			(strings.HasPrefix(name, "GO") && !strings.Contains(name, "_")) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// StepError is a failed verification step, with the command output
+type StepError struct {
+	Step   string
+	Err    error
+	Output string
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("%s failed: %v\n%s", e.Step, e.Err, e.Output)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// verifyWithOutput runs the steps of a verification stage in order, stopping
+// at the first failure. The error includes the command output for fix prompts.
+func (r *Runner) verifyWithOutput(ctx context.Context, workDir, stage string, steps []config.VerifyStep) error {
+	store := artifact.FromContext(ctx)
+	for _, step := range steps {
+		started := time.Now()
+		output, err := runStep(ctx, workDir, step)
+		elapsed := time.Since(started)
+		statsFrom(ctx).step(ctx, StepResult{Stage: stage, Name: step.Name, Run: step.Run, Passed: err == nil, Duration: elapsed})
+		if store != nil {
+			status := "passed"
+			if err != nil {
+				status = "failed: " + err.Error()
+			}
+			store.Write(fmt.Sprintf("verify-%s-%s.log", stage, step.Name), fmt.Sprintf("$ %s\n# duration: %s, result: %s\n\n%s\n",
+				step.Run, elapsed.Round(time.Millisecond), status, output))
+		}
+		if err != nil {
+			slog.Error("Verification step failed",
+				"stage", stage,
+				"step", step.Name,
+				"error", err,
+				"output", output,
+			)
+			// Include output in error for fix prompts
+			return &StepError{Step: step.Name, Err: err, Output: output}
+		}
+		slog.Debug("Verification step passed", "stage", stage, "step", step.Name, "output", output)
+	}
+
+	slog.Info("Verification passed", "stage", stage, "steps", len(steps))
+	return nil
+}
+
+// runStep executes a single verification step with its timeout and env,
+// on top of the allowlisted worker environment
+func runStep(ctx context.Context, workDir string, step config.VerifyStep) (string, error) {
+	stepCtx, cancel := context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(stepCtx, "sh", "-c", step.Run)
+	cmd.Dir = filepath.Join(workDir, step.Dir)
+	// Children of the shell may keep the output pipe open after it is killed
+	cmd.WaitDelay = stepWaitDelay
+	cmd.Env = stepEnv(os.Environ())
+	keys := make([]string, 0, len(step.Env))
+	for k := range step.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+step.Env[k])
+	}
+
+	output, err := cmd.CombinedOutput()
+	trimmed := strings.TrimSpace(string(output))
+
+	// Only report a timeout for the step itself, not the whole task
+	if errors.Is(stepCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return trimmed, fmt.Errorf("timed out after %ds", step.Timeout)
+	}
+	if ctx.Err() != nil {
+		return trimmed, ctx.Err()
+	}
+	return trimmed, err
+}
@@ -0,0 +1,97 @@
+package aider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+func TestVerifyWithOutput(t *testing.T) {
+	workDir := t.TempDir()
+	os.Mkdir(filepath.Join(workDir, "sub"), 0755)
+	marker := filepath.Join(workDir, "ran")
+
+	r := NewRunner(config.AiderConfig{})
+	steps := []config.VerifyStep{
+		{Name: "first", Run: "echo $GREETING > " + marker, Timeout: 5, Env: map[string]string{"GREETING": "hello"}},
+		{Name: "in-dir", Run: `test "$(basename "$PWD")" = sub`, Dir: "sub", Timeout: 5},
+	}
+
+	if err := r.verifyWithOutput(context.Background(), workDir, "build", steps); err != nil {
+		t.Fatalf("verifyWithOutput failed: %v", err)
+	}
+	data, _ := os.ReadFile(marker)
+	if strings.TrimSpace(string(data)) != "hello" {
+		t.Errorf("step env not applied, got %q", data)
+	}
+}
+
+func TestVerifyWithOutput_NoWorkerSecrets(t *testing.T) {
+	for name, value := range map[string]string{
+		"GITHUB_TOKEN":                   "ghp_secret",
+		"AWS_SECRET_ACCESS_KEY":          "aws_secret",
+		"GOOGLE_APPLICATION_CREDENTIALS": "/secret/gcp.json",
+		"GOFLAGS":                        "-mod=mod",
+	} {
+		t.Setenv(name, value)
+	}
+	workDir := t.TempDir()
+	out := filepath.Join(workDir, "env")
+
+	r := NewRunner(config.AiderConfig{})
+	steps := []config.VerifyStep{{Name: "env", Run: "env > " + out, Timeout: 5, Env: map[string]string{"STEP_VAR": "x"}}}
+	if err := r.verifyWithOutput(context.Background(), workDir, "build", steps); err != nil {
+		t.Fatalf("verifyWithOutput failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(out)
+	env := string(data)
+	for _, secret := range []string{"ghp_secret", "aws_secret", "/secret/gcp.json"} {
+		if strings.Contains(env, secret) {
+			t.Errorf("step environment contains %q:\n%s", secret, env)
+		}
+	}
+	for _, want := range []string{"PATH=", "GOFLAGS=-mod=mod", "STEP_VAR=x"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("step environment is missing %q:\n%s", want, env)
+		}
+	}
+}
+
+func TestVerifyWithOutput_Failure(t *testing.T) {
+	workDir := t.TempDir()
+	marker := filepath.Join(workDir, "ran")
+
+	r := NewRunner(config.AiderConfig{})
+	steps := []config.VerifyStep{
+		{Name: "compile", Run: "echo 'main.go:3: undefined: foo' && exit 1", Timeout: 5},
+		{Name: "never", Run: "touch " + marker, Timeout: 5},
+	}
+
+	err := r.verifyWithOutput(context.Background(), workDir, "build", steps)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	// Output is carried into the fix prompt
+	if !strings.Contains(err.Error(), "compile failed") || !strings.Contains(err.Error(), "undefined: foo") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// Later steps don't run after a failure
+	if _, statErr := os.Stat(marker); statErr == nil {
+		t.Error("step after failure should not run")
+	}
+}
+
+func TestVerifyWithOutput_Timeout(t *testing.T) {
+	r := NewRunner(config.AiderConfig{})
+	steps := []config.VerifyStep{{Name: "slow", Run: "exec sleep 30", Timeout: 1}}
+
+	err := r.verifyWithOutput(context.Background(), t.TempDir(), "test", steps)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 1s") {
+		t.Errorf("expected step timeout, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
+)
+
+// Entry is an artifact written to a Store
+type Entry struct {
+	Name string // File name, numbered in write order
+	Path string
+}
+
+// Store saves a task's artifacts (agent prompts and transcripts, diffs,
+// verification output) as files in one directory. A nil *Store discards
+// everything, so callers need not check whether artifacts are enabled.
+type Store struct {
+	dir string
+
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// unsafeChars are replaced in artifact file names
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// New creates the artifact directory for a task under baseDir
+func New(baseDir, task string) (*Store, error) {
+	name := fmt.Sprintf("%s-%s", unsafeChars.ReplaceAllString(task, "_"), time.Now().Format("20060102-150405"))
+	dir := filepath.Join(baseDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the artifact directory
+func (s *Store) Dir() string {
+	if s == nil {
+		return ""
+	}
+	return s.dir
+}
+
+// Write saves an artifact. Secrets are scrubbed from content first. Write
+// errors are logged but never fail the task.
+func (s *Store) Write(name, content string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file := fmt.Sprintf("%03d-%s", len(s.entries)+1, unsafeChars.ReplaceAllString(name, "_"))
+	path := filepath.Join(s.dir, file)
+	if err := os.WriteFile(path, []byte(redact.String(content)), 0644); err != nil {
+		slog.Warn("Failed to write artifact", "path", path, "error", err)
+		return
+	}
+	s.entries = append(s.entries, Entry{Name: file, Path: path})
+}
+
+// Entries returns the artifacts written so far, in order
+func (s *Store) Entries() []Entry {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Entry(nil), s.entries...)
+}
+
+type storeKey struct{}
+
+// WithStore returns a context whose runs save artifacts to s
+func WithStore(ctx context.Context, s *Store) context.Context {
+	return context.WithValue(ctx, storeKey{}, s)
+}
+
+// FromContext returns the Store of ctx, or nil
+func FromContext(ctx context.Context) *Store {
+	s, _ := ctx.Value(storeKey{}).(*Store)
+	return s
+}
+
+// Prune removes task directories under baseDir older than retention
+func Prune(baseDir string, retention time.Duration) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to scan artifact directory", "dir", baseDir, "error", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || time.Since(info.ModTime()) <= retention {
+			continue
+		}
+		path := filepath.Join(baseDir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			slog.Warn("Failed to remove old artifacts", "dir", path, "error", err)
+		}
+	}
+}
+
+// Tail returns the last max bytes of s, cut at a line boundary, noting how
+// much was dropped
+func Tail(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	tail := s[len(s)-max:]
+	if i := strings.IndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	return fmt.Sprintf("... (%d bytes omitted)\n%s", len(s)-len(tail), tail)
+}
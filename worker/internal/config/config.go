@@ -1,45 +1,143 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
+	Queue  QueueConfig  `yaml:"queue"`
 	SQS    SQSConfig    `yaml:"sqs"`
 	Aider  AiderConfig  `yaml:"aider"`
+	Agent  AgentConfig  `yaml:"agent"`
+	Ollama OllamaConfig `yaml:"ollama"`
 	GitHub GitHubConfig `yaml:"github"`
 	Worker WorkerConfig `yaml:"worker"`
 }
 
+type QueueConfig struct {
+	Backend string `yaml:"backend"` // memory, sqs or file
+	Dir     string `yaml:"dir"`     // Queue directory for the file backend
+}
+
 type SQSConfig struct {
 	QueueURL          string `yaml:"queue_url"`
 	Region            string `yaml:"region"`
 	WaitTimeSeconds   int    `yaml:"wait_time_seconds"`
 	VisibilityTimeout int    `yaml:"visibility_timeout"`
 	UseMock           bool   `yaml:"use_mock"`
+	Endpoint          string `yaml:"endpoint"` // Override for SQS-compatible servers (e.g. ElasticMQ)
+	HeartbeatInterval int    `yaml:"heartbeat_interval_seconds"`
 }
 
 type AiderConfig struct {
 	Models    []ModelConfig `yaml:"models"`
 	BinPath   string        `yaml:"bin_path"`
 	MapTokens int           `yaml:"map_tokens"`
+	Prompts   PromptFiles   `yaml:"prompts"` // Prompt templates for every model
 }
 
 type ModelConfig struct {
-	Name    string `yaml:"name"`
-	Timeout int    `yaml:"timeout_seconds"`
+	Name           string      `yaml:"name"`
+	Timeout        int         `yaml:"timeout_seconds"`
+	MaxConcurrent  int         `yaml:"max_concurrent"`     // 0 = unlimited
+	MaxFixAttempts int         `yaml:"max_fix_attempts"`   // Verification attempts per pass
+	FallbackOn     []string    `yaml:"fallback_on"`        // Failures that hand the task to the next model
+	Prompts        PromptFiles `yaml:"prompts"`            // Overrides aider.prompts for this model
+	MaxDiagnostics int         `yaml:"max_diagnostics"`    // Distinct errors kept when fix prompt output is digested
+	ErrorTokens    int         `yaml:"error_token_budget"` // Approximate tokens of verification output in fix prompts
+}
+
+// PromptFiles names Go text/template files for the agent prompts. Empty
+// entries keep the prompt of the next layer down (or the built-in one).
+type PromptFiles struct {
+	Implementation string `yaml:"implementation"` // Pass 1
+	Tests          string `yaml:"tests"`          // Pass 2
+	Fix            string `yaml:"fix"`            // Verification failures
+}
+
+// Failure types for ModelConfig.FallbackOn
+const (
+	FallbackTimeout      = "timeout"       // The agent run timed out
+	FallbackNoDiff       = "no_diff"       // The implementation run changed nothing
+	FallbackFixExhausted = "fix_exhausted" // Verification still failed after max_fix_attempts
+	FallbackError        = "error"         // Any other agent failure
+)
+
+// FallsBackOn reports whether a failure of the given type moves on to the next model
+func (m ModelConfig) FallsBackOn(reason string) bool {
+	return slices.Contains(m.FallbackOn, reason)
+}
+
+type AgentConfig struct {
+	Backend       string            `yaml:"backend"`        // aider or direct
+	LabelBackends map[string]string `yaml:"label_backends"` // Issue label -> backend
+	Direct        DirectAgentConfig `yaml:"direct"`
+}
+
+type DirectAgentConfig struct {
+	BaseURL         string `yaml:"base_url"` // OpenAI-compatible API (Ollama: http://localhost:11434/v1)
+	APIKey          string `yaml:"api_key"`
+	EditFormat      string `yaml:"edit_format"` // diff or whole
+	MaxContextFiles int    `yaml:"max_context_files"`
+	MaxFileBytes    int    `yaml:"max_file_bytes"`
+}
+
+type OllamaConfig struct {
+	BaseURL             string `yaml:"base_url"`
+	AutoPull            bool   `yaml:"auto_pull"` // Pull configured models that are missing
+	PullTimeout         int    `yaml:"pull_timeout_seconds"`
+	HealthCheckInterval int    `yaml:"health_check_interval_seconds"` // Poll interval while Ollama is down
 }
 
 type GitHubConfig struct {
-	Token        string `yaml:"token"`
-	CloneBaseDir string `yaml:"clone_base_dir"`
+	Token          string          `yaml:"token"`
+	CloneBaseDir   string          `yaml:"clone_base_dir"`
+	APIBaseURL     string          `yaml:"api_base_url"`
+	App            GitHubAppConfig `yaml:"app"`
+	BranchPolicy   string          `yaml:"branch_policy"`    // update or suffix: what to do when the task branch already exists
+	PRBodyTemplate string          `yaml:"pr_body_template"` // Go text/template file for the PR body (empty = built-in)
+}
+
+type GitHubAppConfig struct {
+	AppID          int64  `yaml:"app_id"`
+	InstallationID int64  `yaml:"installation_id"` // 0 = look up per repository
+	PrivateKeyPath string `yaml:"private_key_path"`
 }
 
 type WorkerConfig struct {
-	MaxRetries int `yaml:"max_retries"`
-	WorkerID   string `yaml:"worker_id"`
+	MaxRetries        int          `yaml:"max_retries"`
+	WorkerID          string       `yaml:"worker_id"`
+	Concurrency       int          `yaml:"concurrency"`
+	DrainTimeout      int          `yaml:"drain_timeout_seconds"`
+	Retry             RetryConfig  `yaml:"retry"`
+	StageRetries      StageRetries `yaml:"stage_retries"`             // Per-stage retries (unset = max_retries)
+	JournalPath       string       `yaml:"journal_path"`              // Job journal for crash recovery
+	JournalRetention  int          `yaml:"journal_retention_hours"`   // How long finished jobs are kept
+	ProgressInterval  int          `yaml:"progress_interval_seconds"` // Progress comment refresh (-1 = no progress comment)
+	ArtifactDir       string       `yaml:"artifact_dir"`              // Per-task transcripts, diffs and verification logs
+	ArtifactRetention int          `yaml:"artifact_retention_hours"`  // How long artifact directories are kept
+	ArtifactComment   bool         `yaml:"artifact_comment"`          // Also post artifacts as a collapsible comment
+}
+
+// StageRetries overrides max_retries per pipeline stage. A stage left unset
+// inherits max_retries (filled in by Load); 0 means no retries.
+type StageRetries struct {
+	Clone       *int `yaml:"clone"`
+	Generate    *int `yaml:"generate"`
+	Push        *int `yaml:"push"`
+	PullRequest *int `yaml:"pull_request"`
+}
+
+type RetryConfig struct {
+	InitialBackoff int     `yaml:"initial_backoff_seconds"`
+	MaxBackoff     int     `yaml:"max_backoff_seconds"`
+	Multiplier     float64 `yaml:"multiplier"`
+	Jitter         string  `yaml:"jitter"` // none, full or decorrelated
 }
 
 func Load(path string) (*Config, error) {
@@ -57,28 +155,152 @@ func Load(path string) (*Config, error) {
 	}
 
 	// Set defaults
+	if cfg.Queue.Backend == "" {
+		// use_mock predates queue.backend and is still honored
+		if cfg.SQS.UseMock {
+			cfg.Queue.Backend = "memory"
+		} else {
+			cfg.Queue.Backend = "sqs"
+		}
+	}
 	if cfg.SQS.WaitTimeSeconds == 0 {
 		cfg.SQS.WaitTimeSeconds = 20
 	}
 	if cfg.SQS.VisibilityTimeout == 0 {
 		cfg.SQS.VisibilityTimeout = 3600
 	}
+	if cfg.SQS.HeartbeatInterval == 0 {
+		// Extend the lease well before it expires
+		cfg.SQS.HeartbeatInterval = max(cfg.SQS.VisibilityTimeout/3, 1)
+	}
 	if len(cfg.Aider.Models) == 0 {
 		cfg.Aider.Models = []ModelConfig{
 			{Name: "ollama_chat/qwen2.5-coder:1.5b", Timeout: 600},
 		}
 	}
 	for i := range cfg.Aider.Models {
-		if cfg.Aider.Models[i].Timeout == 0 {
-			cfg.Aider.Models[i].Timeout = 600 // 10分
+		model := &cfg.Aider.Models[i]
+		if model.Timeout == 0 {
+			model.Timeout = 600 // 10分
+		}
+		if model.MaxFixAttempts == 0 {
+			model.MaxFixAttempts = 3
+		}
+		if model.MaxDiagnostics == 0 {
+			model.MaxDiagnostics = 10
+		}
+		if model.ErrorTokens == 0 {
+			model.ErrorTokens = 1500
+		}
+		if len(model.FallbackOn) == 0 {
+			model.FallbackOn = []string{FallbackTimeout}
+		}
+		for _, reason := range model.FallbackOn {
+			switch reason {
+			case FallbackTimeout, FallbackNoDiff, FallbackFixExhausted, FallbackError:
+			default:
+				return nil, fmt.Errorf("aider.models[%d]: unknown fallback_on %q", i, reason)
+			}
 		}
 	}
 	if cfg.Aider.BinPath == "" {
 		cfg.Aider.BinPath = "aider"
 	}
+	if cfg.Agent.Backend == "" {
+		cfg.Agent.Backend = "aider"
+	}
+	if cfg.Agent.Direct.BaseURL == "" {
+		cfg.Agent.Direct.BaseURL = "http://localhost:11434/v1"
+	}
+	if cfg.Agent.Direct.EditFormat == "" {
+		cfg.Agent.Direct.EditFormat = "diff"
+	}
+	if cfg.Agent.Direct.MaxContextFiles == 0 {
+		cfg.Agent.Direct.MaxContextFiles = 20
+	}
+	if cfg.Agent.Direct.MaxFileBytes == 0 {
+		cfg.Agent.Direct.MaxFileBytes = 32 * 1024
+	}
+	if cfg.Ollama.BaseURL == "" {
+		cfg.Ollama.BaseURL = "http://localhost:11434"
+	}
+	if cfg.Ollama.PullTimeout == 0 {
+		cfg.Ollama.PullTimeout = 3600 // 1時間
+	}
+	if cfg.Ollama.HealthCheckInterval == 0 {
+		cfg.Ollama.HealthCheckInterval = 30
+	}
+	if cfg.GitHub.APIBaseURL == "" {
+		cfg.GitHub.APIBaseURL = "https://api.github.com"
+	}
+	if cfg.GitHub.BranchPolicy == "" {
+		cfg.GitHub.BranchPolicy = "update"
+	}
+	if cfg.GitHub.BranchPolicy != "update" && cfg.GitHub.BranchPolicy != "suffix" {
+		return nil, fmt.Errorf("github: unknown branch_policy %q", cfg.GitHub.BranchPolicy)
+	}
 	if cfg.Worker.MaxRetries == 0 {
 		cfg.Worker.MaxRetries = 3
 	}
+	if cfg.Worker.Concurrency == 0 {
+		cfg.Worker.Concurrency = 1
+	}
+	if cfg.Worker.Concurrency < 1 {
+		return nil, fmt.Errorf("worker: concurrency must be at least 1, got %d", cfg.Worker.Concurrency)
+	}
+	if cfg.Worker.DrainTimeout == 0 {
+		cfg.Worker.DrainTimeout = 600 // 10分
+	}
+	for _, n := range []**int{
+		&cfg.Worker.StageRetries.Clone,
+		&cfg.Worker.StageRetries.Generate,
+		&cfg.Worker.StageRetries.Push,
+		&cfg.Worker.StageRetries.PullRequest,
+	} {
+		if *n == nil {
+			retries := cfg.Worker.MaxRetries
+			*n = &retries
+		}
+	}
+	// The journal and artifacts live next to the clones. Clones without a
+	// clone_base_dir go to the temp directory, and so does this state
+	// (rather than the process's working directory).
+	stateDir := cfg.GitHub.CloneBaseDir
+	if stateDir == "" {
+		stateDir = filepath.Join(os.TempDir(), "codingworker")
+	}
+	if cfg.Worker.JournalPath == "" {
+		cfg.Worker.JournalPath = filepath.Join(stateDir, "journal.jsonl")
+	}
+	if cfg.Worker.JournalRetention == 0 {
+		cfg.Worker.JournalRetention = 168 // 7日
+	}
+	if cfg.Worker.ProgressInterval == 0 {
+		cfg.Worker.ProgressInterval = 60
+	}
+	if cfg.Worker.ArtifactDir == "" {
+		cfg.Worker.ArtifactDir = filepath.Join(stateDir, "artifacts")
+	}
+	if cfg.Worker.ArtifactRetention == 0 {
+		cfg.Worker.ArtifactRetention = 168 // 7日
+	}
+	if cfg.Worker.Retry.InitialBackoff == 0 {
+		cfg.Worker.Retry.InitialBackoff = 10
+	}
+	if cfg.Worker.Retry.MaxBackoff == 0 {
+		cfg.Worker.Retry.MaxBackoff = 300 // 5分
+	}
+	if cfg.Worker.Retry.Multiplier == 0 {
+		cfg.Worker.Retry.Multiplier = 2.0
+	}
+	if cfg.Worker.Retry.Jitter == "" {
+		cfg.Worker.Retry.Jitter = "full"
+	}
+	switch cfg.Worker.Retry.Jitter {
+	case "none", "full", "decorrelated":
+	default:
+		return nil, fmt.Errorf("worker.retry: unknown jitter %q", cfg.Worker.Retry.Jitter)
+	}
 
 	return &cfg, nil
 }
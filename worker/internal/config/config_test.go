@@ -9,24 +9,76 @@ import (
 func TestLoad(t *testing.T) {
 	// Create temporary config file
 	content := `
+queue:
+  backend: "file"
+  dir: "/tmp/queue"
 sqs:
   queue_url: "https://sqs.ap-northeast-1.amazonaws.com/123456789/test-queue"
   region: "ap-northeast-1"
   use_mock: true
   wait_time_seconds: 10
   visibility_timeout: 300
+  endpoint: "http://localhost:9324"
 aider:
   bin_path: "/usr/local/bin/aider"
   map_tokens: 0
+  prompts:
+    implementation: "/etc/codingworker/prompts/implementation.tmpl"
   models:
     - name: "ollama_chat/qwen2.5-coder:1.5b"
       timeout_seconds: 600
+      max_concurrent: 1
+      max_fix_attempts: 5
+      fallback_on: ["timeout", "no_diff", "fix_exhausted"]
+      prompts:
+        fix: "/etc/codingworker/prompts/fix-small.tmpl"
+      max_diagnostics: 5
+      error_token_budget: 800
+agent:
+  backend: "direct"
+  label_backends:
+    ai-task-aider: "aider"
+  direct:
+    base_url: "http://localhost:8000/v1"
+    api_key: "test-key"
+    edit_format: "whole"
+    max_context_files: 5
+    max_file_bytes: 1024
+ollama:
+  base_url: "http://ollama.local:11434"
+  auto_pull: true
+  pull_timeout_seconds: 900
+  health_check_interval_seconds: 5
 github:
   token: "test-token"
   clone_base_dir: "/tmp/workdir"
+  api_base_url: "http://localhost:8080"
+  app:
+    app_id: 12345
+    installation_id: 678
+    private_key_path: "/etc/codingworker/app.pem"
+  branch_policy: "suffix"
+  pr_body_template: "/etc/codingworker/pr_body.md.tmpl"
 worker:
   max_retries: 5
   worker_id: "test-worker"
+  concurrency: 4
+  drain_timeout_seconds: 120
+  retry:
+    initial_backoff_seconds: 2
+    max_backoff_seconds: 60
+    multiplier: 3
+    jitter: "decorrelated"
+  stage_retries:
+    generate: 1
+    push: 0
+    pull_request: 8
+  journal_path: "/var/lib/codingworker/journal.jsonl"
+  journal_retention_hours: 24
+  progress_interval_seconds: -1
+  artifact_dir: "/var/lib/codingworker/artifacts"
+  artifact_retention_hours: 72
+  artifact_comment: true
 `
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "config.yaml")
@@ -39,6 +91,14 @@ worker:
 		t.Fatalf("Load failed: %v", err)
 	}
 
+	// Verify Queue config
+	if cfg.Queue.Backend != "file" {
+		t.Errorf("unexpected queue backend: %s", cfg.Queue.Backend)
+	}
+	if cfg.Queue.Dir != "/tmp/queue" {
+		t.Errorf("unexpected queue dir: %s", cfg.Queue.Dir)
+	}
+
 	// Verify SQS config
 	if cfg.SQS.QueueURL != "https://sqs.ap-northeast-1.amazonaws.com/123456789/test-queue" {
 		t.Errorf("unexpected queue_url: %s", cfg.SQS.QueueURL)
@@ -55,6 +115,9 @@ worker:
 	if cfg.SQS.VisibilityTimeout != 300 {
 		t.Errorf("expected visibility_timeout 300, got %d", cfg.SQS.VisibilityTimeout)
 	}
+	if cfg.SQS.Endpoint != "http://localhost:9324" {
+		t.Errorf("unexpected endpoint: %s", cfg.SQS.Endpoint)
+	}
 
 	// Verify Aider config
 	if cfg.Aider.BinPath != "/usr/local/bin/aider" {
@@ -72,6 +135,52 @@ worker:
 	if cfg.Aider.Models[0].Timeout != 600 {
 		t.Errorf("expected timeout 600, got %d", cfg.Aider.Models[0].Timeout)
 	}
+	if cfg.Aider.Models[0].MaxConcurrent != 1 {
+		t.Errorf("expected max_concurrent 1, got %d", cfg.Aider.Models[0].MaxConcurrent)
+	}
+	if cfg.Aider.Models[0].MaxFixAttempts != 5 {
+		t.Errorf("expected max_fix_attempts 5, got %d", cfg.Aider.Models[0].MaxFixAttempts)
+	}
+	if !cfg.Aider.Models[0].FallsBackOn(FallbackNoDiff) || cfg.Aider.Models[0].FallsBackOn(FallbackError) {
+		t.Errorf("unexpected fallback_on: %v", cfg.Aider.Models[0].FallbackOn)
+	}
+	if cfg.Aider.Prompts.Implementation != "/etc/codingworker/prompts/implementation.tmpl" {
+		t.Errorf("unexpected aider prompts: %+v", cfg.Aider.Prompts)
+	}
+	if cfg.Aider.Models[0].Prompts != (PromptFiles{Fix: "/etc/codingworker/prompts/fix-small.tmpl"}) {
+		t.Errorf("unexpected model prompts: %+v", cfg.Aider.Models[0].Prompts)
+	}
+	if cfg.Aider.Models[0].MaxDiagnostics != 5 || cfg.Aider.Models[0].ErrorTokens != 800 {
+		t.Errorf("unexpected error digest config: %d, %d", cfg.Aider.Models[0].MaxDiagnostics, cfg.Aider.Models[0].ErrorTokens)
+	}
+
+	// Verify Agent config
+	if cfg.Agent.Backend != "direct" {
+		t.Errorf("unexpected agent backend: %s", cfg.Agent.Backend)
+	}
+	if cfg.Agent.LabelBackends["ai-task-aider"] != "aider" {
+		t.Errorf("unexpected label_backends: %v", cfg.Agent.LabelBackends)
+	}
+	if cfg.Agent.Direct.BaseURL != "http://localhost:8000/v1" || cfg.Agent.Direct.APIKey != "test-key" {
+		t.Errorf("unexpected direct agent endpoint: %+v", cfg.Agent.Direct)
+	}
+	if cfg.Agent.Direct.EditFormat != "whole" {
+		t.Errorf("unexpected edit_format: %s", cfg.Agent.Direct.EditFormat)
+	}
+	if cfg.Agent.Direct.MaxContextFiles != 5 || cfg.Agent.Direct.MaxFileBytes != 1024 {
+		t.Errorf("unexpected direct agent limits: %+v", cfg.Agent.Direct)
+	}
+
+	// Verify Ollama config
+	if cfg.Ollama.BaseURL != "http://ollama.local:11434" {
+		t.Errorf("unexpected ollama base_url: %s", cfg.Ollama.BaseURL)
+	}
+	if !cfg.Ollama.AutoPull {
+		t.Error("expected auto_pull to be true")
+	}
+	if cfg.Ollama.PullTimeout != 900 || cfg.Ollama.HealthCheckInterval != 5 {
+		t.Errorf("unexpected ollama intervals: %+v", cfg.Ollama)
+	}
 
 	// Verify GitHub config
 	if cfg.GitHub.Token != "test-token" {
@@ -80,6 +189,21 @@ worker:
 	if cfg.GitHub.CloneBaseDir != "/tmp/workdir" {
 		t.Errorf("unexpected clone_base_dir: %s", cfg.GitHub.CloneBaseDir)
 	}
+	if cfg.GitHub.APIBaseURL != "http://localhost:8080" {
+		t.Errorf("unexpected api_base_url: %s", cfg.GitHub.APIBaseURL)
+	}
+	if cfg.GitHub.App.AppID != 12345 || cfg.GitHub.App.InstallationID != 678 {
+		t.Errorf("unexpected app config: %+v", cfg.GitHub.App)
+	}
+	if cfg.GitHub.App.PrivateKeyPath != "/etc/codingworker/app.pem" {
+		t.Errorf("unexpected private_key_path: %s", cfg.GitHub.App.PrivateKeyPath)
+	}
+	if cfg.GitHub.BranchPolicy != "suffix" {
+		t.Errorf("unexpected branch_policy: %s", cfg.GitHub.BranchPolicy)
+	}
+	if cfg.GitHub.PRBodyTemplate != "/etc/codingworker/pr_body.md.tmpl" {
+		t.Errorf("unexpected pr_body_template: %s", cfg.GitHub.PRBodyTemplate)
+	}
 
 	// Verify Worker config
 	if cfg.Worker.MaxRetries != 5 {
@@ -88,6 +212,28 @@ worker:
 	if cfg.Worker.WorkerID != "test-worker" {
 		t.Errorf("unexpected worker_id: %s", cfg.Worker.WorkerID)
 	}
+	if cfg.Worker.Concurrency != 4 {
+		t.Errorf("expected concurrency 4, got %d", cfg.Worker.Concurrency)
+	}
+	if cfg.Worker.DrainTimeout != 120 {
+		t.Errorf("expected drain_timeout_seconds 120, got %d", cfg.Worker.DrainTimeout)
+	}
+	if cfg.Worker.Retry != (RetryConfig{InitialBackoff: 2, MaxBackoff: 60, Multiplier: 3, Jitter: "decorrelated"}) {
+		t.Errorf("unexpected retry config: %+v", cfg.Worker.Retry)
+	}
+	// An explicit 0 disables retries; unset stages inherit max_retries
+	if got := stageRetries(cfg.Worker.StageRetries); got != [4]int{5, 1, 0, 8} {
+		t.Errorf("unexpected stage_retries: %v", got)
+	}
+	if cfg.Worker.JournalPath != "/var/lib/codingworker/journal.jsonl" || cfg.Worker.JournalRetention != 24 {
+		t.Errorf("unexpected journal config: %s, %d", cfg.Worker.JournalPath, cfg.Worker.JournalRetention)
+	}
+	if cfg.Worker.ProgressInterval != -1 {
+		t.Errorf("expected progress_interval_seconds -1, got %d", cfg.Worker.ProgressInterval)
+	}
+	if cfg.Worker.ArtifactDir != "/var/lib/codingworker/artifacts" || cfg.Worker.ArtifactRetention != 72 || !cfg.Worker.ArtifactComment {
+		t.Errorf("unexpected artifact config: %s, %d, %v", cfg.Worker.ArtifactDir, cfg.Worker.ArtifactRetention, cfg.Worker.ArtifactComment)
+	}
 }
 
 func TestLoad_Defaults(t *testing.T) {
@@ -108,12 +254,18 @@ sqs:
 	}
 
 	// Check defaults
+	if cfg.Queue.Backend != "sqs" {
+		t.Errorf("expected default queue backend 'sqs', got %s", cfg.Queue.Backend)
+	}
 	if cfg.SQS.WaitTimeSeconds != 20 {
 		t.Errorf("expected default wait_time_seconds 20, got %d", cfg.SQS.WaitTimeSeconds)
 	}
 	if cfg.SQS.VisibilityTimeout != 3600 {
 		t.Errorf("expected default visibility_timeout 3600, got %d", cfg.SQS.VisibilityTimeout)
 	}
+	if cfg.SQS.HeartbeatInterval != 1200 {
+		t.Errorf("expected default heartbeat_interval_seconds 1200, got %d", cfg.SQS.HeartbeatInterval)
+	}
 	if len(cfg.Aider.Models) != 1 {
 		t.Errorf("expected 1 default model, got %d", len(cfg.Aider.Models))
 	}
@@ -123,12 +275,158 @@ sqs:
 	if cfg.Aider.Models[0].Timeout != 600 {
 		t.Errorf("expected default timeout 600, got %d", cfg.Aider.Models[0].Timeout)
 	}
+	if cfg.Aider.Models[0].MaxFixAttempts != 3 {
+		t.Errorf("expected default max_fix_attempts 3, got %d", cfg.Aider.Models[0].MaxFixAttempts)
+	}
+	if cfg.Aider.Models[0].MaxDiagnostics != 10 || cfg.Aider.Models[0].ErrorTokens != 1500 {
+		t.Errorf("unexpected default error digest config: %d, %d", cfg.Aider.Models[0].MaxDiagnostics, cfg.Aider.Models[0].ErrorTokens)
+	}
+	if len(cfg.Aider.Models[0].FallbackOn) != 1 || !cfg.Aider.Models[0].FallsBackOn(FallbackTimeout) {
+		t.Errorf("expected default fallback_on [timeout], got %v", cfg.Aider.Models[0].FallbackOn)
+	}
 	if cfg.Aider.BinPath != "aider" {
 		t.Errorf("expected default bin_path 'aider', got %s", cfg.Aider.BinPath)
 	}
+	if cfg.Agent.Backend != "aider" {
+		t.Errorf("expected default agent backend 'aider', got %s", cfg.Agent.Backend)
+	}
+	if cfg.Agent.Direct.BaseURL != "http://localhost:11434/v1" {
+		t.Errorf("expected default direct base_url, got %s", cfg.Agent.Direct.BaseURL)
+	}
+	if cfg.Agent.Direct.EditFormat != "diff" {
+		t.Errorf("expected default edit_format 'diff', got %s", cfg.Agent.Direct.EditFormat)
+	}
+	if cfg.Agent.Direct.MaxContextFiles != 20 || cfg.Agent.Direct.MaxFileBytes != 32*1024 {
+		t.Errorf("unexpected default direct agent limits: %+v", cfg.Agent.Direct)
+	}
+	if cfg.Ollama.BaseURL != "http://localhost:11434" {
+		t.Errorf("expected default ollama base_url, got %s", cfg.Ollama.BaseURL)
+	}
+	if cfg.Ollama.AutoPull {
+		t.Error("expected auto_pull to default to false")
+	}
+	if cfg.Ollama.PullTimeout != 3600 || cfg.Ollama.HealthCheckInterval != 30 {
+		t.Errorf("unexpected default ollama intervals: %+v", cfg.Ollama)
+	}
+	if cfg.GitHub.APIBaseURL != "https://api.github.com" {
+		t.Errorf("expected default api_base_url, got %s", cfg.GitHub.APIBaseURL)
+	}
+	if cfg.GitHub.BranchPolicy != "update" {
+		t.Errorf("expected default branch_policy update, got %s", cfg.GitHub.BranchPolicy)
+	}
 	if cfg.Worker.MaxRetries != 3 {
 		t.Errorf("expected default max_retries 3, got %d", cfg.Worker.MaxRetries)
 	}
+	if cfg.Worker.Concurrency != 1 {
+		t.Errorf("expected default concurrency 1, got %d", cfg.Worker.Concurrency)
+	}
+	if cfg.Worker.DrainTimeout != 600 {
+		t.Errorf("expected default drain_timeout_seconds 600, got %d", cfg.Worker.DrainTimeout)
+	}
+	if cfg.Worker.Retry != (RetryConfig{InitialBackoff: 10, MaxBackoff: 300, Multiplier: 2, Jitter: "full"}) {
+		t.Errorf("unexpected default retry config: %+v", cfg.Worker.Retry)
+	}
+	if got := stageRetries(cfg.Worker.StageRetries); got != [4]int{3, 3, 3, 3} {
+		t.Errorf("unexpected default stage_retries: %v", got)
+	}
+	// Without clone_base_dir, state goes to the temp directory rather than the working directory
+	if cfg.Worker.JournalPath != filepath.Join(os.TempDir(), "codingworker", "journal.jsonl") || cfg.Worker.JournalRetention != 168 {
+		t.Errorf("unexpected default journal config: %s, %d", cfg.Worker.JournalPath, cfg.Worker.JournalRetention)
+	}
+	if cfg.Worker.ProgressInterval != 60 {
+		t.Errorf("expected default progress_interval_seconds 60, got %d", cfg.Worker.ProgressInterval)
+	}
+	if cfg.Worker.ArtifactDir != filepath.Join(os.TempDir(), "codingworker", "artifacts") || cfg.Worker.ArtifactRetention != 168 || cfg.Worker.ArtifactComment {
+		t.Errorf("unexpected default artifact config: %s, %d, %v", cfg.Worker.ArtifactDir, cfg.Worker.ArtifactRetention, cfg.Worker.ArtifactComment)
+	}
+}
+
+func TestLoad_InvalidFallbackOn(t *testing.T) {
+	content := `
+aider:
+  models:
+    - name: "ollama_chat/qwen2.5-coder:1.5b"
+      fallback_on: ["timeout", "bad_luck"]
+`
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Error("expected error for unknown fallback_on")
+	}
+}
+
+func TestLoad_InvalidJitter(t *testing.T) {
+	content := `
+worker:
+  retry:
+    jitter: "random"
+`
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Error("expected error for unknown jitter")
+	}
+}
+
+func TestLoad_InvalidBranchPolicy(t *testing.T) {
+	content := `
+github:
+  branch_policy: "overwrite"
+`
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Error("expected error for unknown branch_policy")
+	}
+}
+
+func TestLoad_InvalidConcurrency(t *testing.T) {
+	content := `
+worker:
+  concurrency: -1
+`
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Error("expected error for negative concurrency")
+	}
+}
+
+func TestLoad_UseMockSelectsMemoryBackend(t *testing.T) {
+	content := `
+sqs:
+  use_mock: true
+`
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Queue.Backend != "memory" {
+		t.Errorf("expected queue backend 'memory' for use_mock, got %s", cfg.Queue.Backend)
+	}
 }
 
 func TestLoad_EnvExpansion(t *testing.T) {
@@ -179,3 +477,9 @@ invalid: yaml: content
 		t.Error("expected error for invalid YAML")
 	}
 }
+
+// stageRetries returns the resolved retries of clone, generate, push and
+// pull_request
+func stageRetries(s StageRetries) [4]int {
+	return [4]int{*s.Clone, *s.Generate, *s.Push, *s.PullRequest}
+}
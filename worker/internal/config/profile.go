@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Profile names
+const (
+	ProfileGo     = "go"
+	ProfileRust   = "rust"
+	ProfileNode   = "node"
+	ProfilePython = "python"
+	ProfileMake   = "make"
+	// ProfileNone means no project type was detected: verification is skipped
+	ProfileNone = "none"
+	// ProfileCustom means the steps come from .codingworker.yml
+	ProfileCustom = "custom"
+)
+
+// Profile is a built-in set of verification steps for a project type
+type Profile struct {
+	Name    string
+	Markers []string // Files in the repository root that identify the project type
+	Verify  VerifyConfig
+}
+
+// profiles are checked in order; the first one with a marker file wins
+var profiles = []Profile{
+	{
+		Name:    ProfileGo,
+		Markers: []string{"go.mod"},
+		Verify: VerifyConfig{
+			Build: []VerifyStep{{Name: "go build", Run: "go build ./..."}},
+			Lint: []VerifyStep{
+				{Name: "go fmt", Run: "go fmt ./..."}, // Auto-fixes formatting
+				{Name: "go vet", Run: "go vet ./..."},
+			},
+			Test: []VerifyStep{{Name: "go test", Run: "go test ./..."}},
+		},
+	},
+	{
+		Name:    ProfileRust,
+		Markers: []string{"Cargo.toml"},
+		Verify: VerifyConfig{
+			Build: []VerifyStep{{Name: "cargo build", Run: "cargo build --all-targets"}},
+			Lint: []VerifyStep{
+				{Name: "cargo fmt", Run: "cargo fmt --all"}, // Auto-fixes formatting
+				{Name: "cargo clippy", Run: "cargo clippy --all-targets -- -D warnings"},
+			},
+			Test: []VerifyStep{{Name: "cargo test", Run: "cargo test"}},
+		},
+	},
+	{
+		Name:    ProfileNode,
+		Markers: []string{"package.json"},
+		Verify: VerifyConfig{
+			Build: []VerifyStep{
+				{Name: "npm install", Run: "npm install --no-audit --no-fund"},
+				{Name: "npm run build", Run: "npm run build --if-present"},
+			},
+			Lint: []VerifyStep{{Name: "npm run lint", Run: "npm run lint --if-present"}},
+			Test: []VerifyStep{{Name: "npm test", Run: "npm run test --if-present"}},
+		},
+	},
+	{
+		Name:    ProfilePython,
+		Markers: []string{"pyproject.toml", "setup.py", "requirements.txt"},
+		Verify: VerifyConfig{
+			Build: []VerifyStep{{Name: "compileall", Run: "python3 -m compileall -q ."}},
+			Test:  []VerifyStep{{Name: "pytest", Run: "python3 -m pytest"}},
+		},
+	},
+	{
+		Name:    ProfileMake,
+		Markers: []string{"Makefile"},
+		Verify: VerifyConfig{
+			Build: []VerifyStep{{Name: "make", Run: "make"}},
+			Test:  []VerifyStep{{Name: "make test", Run: "make test"}},
+		},
+	},
+}
+
+// LookupProfile returns the built-in profile with the given name
+func LookupProfile(name string) (Profile, bool) {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// DetectProfile picks the built-in profile from marker files in the
+// repository root. Returns ProfileNone with no steps if nothing matches.
+func DetectProfile(repoDir string) Profile {
+	for _, p := range profiles {
+		for _, marker := range p.Markers {
+			if _, err := os.Stat(filepath.Join(repoDir, marker)); err == nil {
+				return p
+			}
+		}
+	}
+	return Profile{Name: ProfileNone}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		expected string
+	}{
+		{name: "go", files: []string{"go.mod"}, expected: ProfileGo},
+		{name: "rust", files: []string{"Cargo.toml"}, expected: ProfileRust},
+		{name: "node", files: []string{"package.json"}, expected: ProfileNode},
+		{name: "python pyproject", files: []string{"pyproject.toml"}, expected: ProfilePython},
+		{name: "python requirements", files: []string{"requirements.txt"}, expected: ProfilePython},
+		{name: "make", files: []string{"Makefile"}, expected: ProfileMake},
+		{name: "language marker wins over Makefile", files: []string{"Makefile", "go.mod"}, expected: ProfileGo},
+		{name: "go wins over node tooling", files: []string{"package.json", "go.mod"}, expected: ProfileGo},
+		{name: "nothing detected", files: []string{"README.md"}, expected: ProfileNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				os.WriteFile(filepath.Join(dir, f), nil, 0644)
+			}
+
+			profile := DetectProfile(dir)
+			if profile.Name != tt.expected {
+				t.Errorf("DetectProfile() = %s, want %s", profile.Name, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDetectProfile_None(t *testing.T) {
+	profile := DetectProfile(t.TempDir())
+	if !profile.Verify.IsEmpty() {
+		t.Errorf("expected no steps for undetected project, got %+v", profile.Verify)
+	}
+}
+
+func TestLookupProfile(t *testing.T) {
+	for _, name := range []string{ProfileGo, ProfileRust, ProfileNode, ProfilePython, ProfileMake} {
+		profile, ok := LookupProfile(name)
+		if !ok {
+			t.Errorf("profile %s not found", name)
+			continue
+		}
+		if len(profile.Verify.Build) == 0 || len(profile.Verify.Test) == 0 {
+			t.Errorf("profile %s should define build and test steps", name)
+		}
+	}
+
+	if _, ok := LookupProfile(ProfileNone); ok {
+		t.Error("none should not be a selectable profile")
+	}
+}
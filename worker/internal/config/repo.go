@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfigFile is the per-repository config, read from the repository root
+const RepoConfigFile = ".codingworker.yml"
+
+// DefaultStepTimeout applies to verification steps without timeout_seconds
+const DefaultStepTimeout = 600
+
+type RepoConfig struct {
+	Profile string       `yaml:"profile"` // Built-in profile to use instead of detection
+	Verify  VerifyConfig `yaml:"verify"`
+	Prompts PromptFiles  `yaml:"prompts"` // Relative to the repository root; override the worker's
+}
+
+type VerifyConfig struct {
+	Build []VerifyStep `yaml:"build"`
+	Lint  []VerifyStep `yaml:"lint"`
+	Test  []VerifyStep `yaml:"test"`
+}
+
+type VerifyStep struct {
+	Name    string            `yaml:"name"`
+	Run     string            `yaml:"run"` // Executed with sh -c
+	Dir     string            `yaml:"dir"` // Relative to the repository root
+	Timeout int               `yaml:"timeout_seconds"`
+	Env     map[string]string `yaml:"env"`
+}
+
+// IsEmpty reports whether no verification steps are defined
+func (v VerifyConfig) IsEmpty() bool {
+	return len(v.Build) == 0 && len(v.Lint) == 0 && len(v.Test) == 0
+}
+
+// clone copies the step slices so that defaults filled in by LoadRepo
+// don't modify the built-in profiles
+func (v VerifyConfig) clone() VerifyConfig {
+	return VerifyConfig{
+		Build: slices.Clone(v.Build),
+		Lint:  slices.Clone(v.Lint),
+		Test:  slices.Clone(v.Test),
+	}
+}
+
+// LoadRepo reads .codingworker.yml from the repository root. When it defines
+// verify steps they are used as is (stages that are left out are skipped) and
+// Profile is ProfileCustom unless set. Otherwise the steps come from the
+// built-in profile named by Profile, or the one detected from the clone.
+//
+// Unlike Load, environment variables are not expanded: the file comes from
+// the repository and must not be able to read the worker's secrets.
+func LoadRepo(repoDir string) (*RepoConfig, error) {
+	var cfg RepoConfig
+
+	data, err := os.ReadFile(filepath.Join(repoDir, RepoConfigFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", RepoConfigFile, err)
+		}
+	}
+
+	switch {
+	case !cfg.Verify.IsEmpty():
+		if cfg.Profile == "" {
+			cfg.Profile = ProfileCustom
+		}
+	case cfg.Profile != "":
+		profile, ok := LookupProfile(cfg.Profile)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: unknown profile %q", RepoConfigFile, cfg.Profile)
+		}
+		cfg.Verify = profile.Verify.clone()
+	default:
+		profile := DetectProfile(repoDir)
+		cfg.Profile = profile.Name
+		cfg.Verify = profile.Verify.clone()
+	}
+
+	for _, path := range []string{cfg.Prompts.Implementation, cfg.Prompts.Tests, cfg.Prompts.Fix} {
+		if path != "" && !filepath.IsLocal(path) {
+			return nil, fmt.Errorf("invalid %s: prompt %q must be inside the repository", RepoConfigFile, path)
+		}
+	}
+
+	for _, steps := range [][]VerifyStep{cfg.Verify.Build, cfg.Verify.Lint, cfg.Verify.Test} {
+		for i := range steps {
+			if err := normalizeStep(&steps[i]); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", RepoConfigFile, err)
+			}
+		}
+	}
+
+	return &cfg, nil
+}
+
+// normalizeStep validates a step and fills in defaults
+func normalizeStep(step *VerifyStep) error {
+	if step.Run == "" {
+		return fmt.Errorf("verify step %q has no run command", step.Name)
+	}
+	if step.Dir != "" && !filepath.IsLocal(step.Dir) {
+		return fmt.Errorf("verify step %q: dir %q must be inside the repository", step.Name, step.Dir)
+	}
+	if step.Name == "" {
+		step.Name = step.Run
+	}
+	if step.Timeout == 0 {
+		step.Timeout = DefaultStepTimeout
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRepo(t *testing.T) {
+	content := `
+verify:
+  build:
+    - name: "install"
+      run: "npm ci"
+      timeout_seconds: 300
+    - run: "npm run build"
+      dir: "web"
+      env:
+        NODE_ENV: "production"
+  test:
+    - name: "unit"
+      run: "npm test -- --token=$GITHUB_TOKEN"
+prompts:
+  fix: ".codingworker/fix.tmpl"
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write repo config: %v", err)
+	}
+
+	cfg, err := LoadRepo(dir)
+	if err != nil {
+		t.Fatalf("LoadRepo failed: %v", err)
+	}
+
+	if cfg.Profile != ProfileCustom {
+		t.Errorf("expected custom profile, got %s", cfg.Profile)
+	}
+	if len(cfg.Verify.Build) != 2 {
+		t.Fatalf("expected 2 build steps, got %d", len(cfg.Verify.Build))
+	}
+	install := cfg.Verify.Build[0]
+	if install.Name != "install" || install.Run != "npm ci" || install.Timeout != 300 {
+		t.Errorf("unexpected install step: %+v", install)
+	}
+	build := cfg.Verify.Build[1]
+	if build.Name != "npm run build" {
+		t.Errorf("expected name to default to run command, got %q", build.Name)
+	}
+	if build.Dir != "web" {
+		t.Errorf("unexpected dir: %s", build.Dir)
+	}
+	if build.Timeout != DefaultStepTimeout {
+		t.Errorf("expected default timeout, got %d", build.Timeout)
+	}
+	if build.Env["NODE_ENV"] != "production" {
+		t.Errorf("unexpected env: %v", build.Env)
+	}
+
+	// Omitted stages are skipped once steps are defined
+	if len(cfg.Verify.Lint) != 0 {
+		t.Errorf("expected no lint steps, got %v", cfg.Verify.Lint)
+	}
+
+	// Environment variables are not expanded in repository config
+	if cfg.Verify.Test[0].Run != "npm test -- --token=$GITHUB_TOKEN" {
+		t.Errorf("run command should not be expanded: %s", cfg.Verify.Test[0].Run)
+	}
+	if cfg.Prompts != (PromptFiles{Fix: ".codingworker/fix.tmpl"}) {
+		t.Errorf("unexpected prompts: %+v", cfg.Prompts)
+	}
+}
+
+func TestLoadRepo_Missing(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/x\n"), 0644)
+
+	cfg, err := LoadRepo(dir)
+	if err != nil {
+		t.Fatalf("LoadRepo failed: %v", err)
+	}
+
+	if cfg.Profile != ProfileGo {
+		t.Errorf("expected detected go profile, got %s", cfg.Profile)
+	}
+	if len(cfg.Verify.Build) != 1 || cfg.Verify.Build[0].Run != "go build ./..." {
+		t.Errorf("expected go build steps, got %v", cfg.Verify.Build)
+	}
+	if len(cfg.Verify.Lint) != 2 {
+		t.Errorf("expected go lint steps, got %v", cfg.Verify.Lint)
+	}
+	if cfg.Verify.Test[0].Timeout != DefaultStepTimeout {
+		t.Errorf("expected default timeout, got %d", cfg.Verify.Test[0].Timeout)
+	}
+
+	// Defaults are filled into a copy, not the built-in profile
+	builtin, _ := LookupProfile(ProfileGo)
+	if builtin.Verify.Test[0].Timeout != 0 {
+		t.Error("LoadRepo modified the built-in profile")
+	}
+}
+
+func TestLoadRepo_ProfileOverride(t *testing.T) {
+	dir := t.TempDir()
+	// Detection would pick go; the file asks for make
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/x\n"), 0644)
+	os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte("profile: make\n"), 0644)
+
+	cfg, err := LoadRepo(dir)
+	if err != nil {
+		t.Fatalf("LoadRepo failed: %v", err)
+	}
+	if cfg.Profile != ProfileMake {
+		t.Errorf("expected make profile, got %s", cfg.Profile)
+	}
+	if cfg.Verify.Build[0].Run != "make" {
+		t.Errorf("unexpected build steps: %v", cfg.Verify.Build)
+	}
+}
+
+func TestLoadRepo_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "malformed yaml",
+			content: "verify: [",
+		},
+		{
+			name:    "unknown profile",
+			content: "profile: cobol\n",
+		},
+		{
+			name: "missing run",
+			content: `
+verify:
+  build:
+    - name: "build"
+`,
+		},
+		{
+			name: "dir escapes repository",
+			content: `
+verify:
+  build:
+    - run: "make"
+      dir: "../other"
+`,
+		},
+		{
+			name: "absolute dir",
+			content: `
+verify:
+  build:
+    - run: "make"
+      dir: "/etc"
+`,
+		},
+		{
+			name:    "prompt outside repository",
+			content: "prompts:\n  tests: \"../prompts/tests.tmpl\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write repo config: %v", err)
+			}
+			if _, err := LoadRepo(dir); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/retry"
+)
+
+const (
+	// DefaultAPIBaseURL is the public GitHub REST API endpoint
+	DefaultAPIBaseURL = "https://api.github.com"
+
+	apiVersion = "2022-11-28"
+)
+
+// APIError is a non-2xx response from the GitHub REST API
+type APIError struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is the server-suggested wait before retrying (0 if unknown).
+	// Taken from Retry-After or X-RateLimit-Reset.
+	RetryAfter  time.Duration
+	RateLimited bool
+}
+
+func (e *APIError) Error() string {
+	if e.RateLimited {
+		return fmt.Sprintf("github api: %d rate limited (retry after %s): %s", e.StatusCode, e.RetryAfter, e.Message)
+	}
+	return fmt.Sprintf("github api: %d: %s", e.StatusCode, e.Message)
+}
+
+// PullRequest is the subset of the GitHub pull request resource the worker uses
+type PullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	State   string `json:"state"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+}
+
+// IssueComment is the subset of the GitHub issue comment resource the worker uses
+type IssueComment struct {
+	ID      int64  `json:"id"`
+	HTMLURL string `json:"html_url"`
+	Body    string `json:"body"`
+}
+
+// Issue is the subset of the GitHub issue resource the worker uses
+type Issue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	State  string `json:"state"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+}
+
+// ReviewComment is the subset of the GitHub pull request review comment
+// resource the worker uses
+type ReviewComment struct {
+	ID       int64  `json:"id"`
+	Path     string `json:"path"`
+	Line     int    `json:"line"` // 0 when the comment is outdated
+	Body     string `json:"body"`
+	DiffHunk string `json:"diff_hunk"`
+	User     struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// Repository is the subset of the GitHub repository resource the worker uses
+type Repository struct {
+	FullName      string `json:"full_name"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// Branch is the subset of the GitHub branch resource the worker uses
+type Branch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+// GetRepository fetches repository metadata
+func (c *Client) GetRepository(ctx context.Context, repository string) (*Repository, error) {
+	var repo Repository
+	if err := c.do(ctx, repository, http.MethodGet, "/repos/"+repository, nil, &repo); err != nil {
+		return nil, fmt.Errorf("get repository failed: %w", err)
+	}
+	return &repo, nil
+}
+
+// GetBranch fetches a branch. Returns nil, nil if the branch does not exist.
+func (c *Client) GetBranch(ctx context.Context, repository, branch string) (*Branch, error) {
+	var b Branch
+	err := c.do(ctx, repository, http.MethodGet, fmt.Sprintf("/repos/%s/branches/%s", repository, url.PathEscape(branch)), nil, &b)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get branch failed: %w", err)
+	}
+	return &b, nil
+}
+
+// GetIssue fetches an issue
+func (c *Client) GetIssue(ctx context.Context, repository string, number int) (*Issue, error) {
+	var issue Issue
+	if err := c.do(ctx, repository, http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d", repository, number), nil, &issue); err != nil {
+		return nil, fmt.Errorf("get issue failed: %w", err)
+	}
+	return &issue, nil
+}
+
+// CanWrite reports whether user has write (or admin) access to repository
+func (c *Client) CanWrite(ctx context.Context, repository, user string) (bool, error) {
+	var perm struct {
+		Permission string `json:"permission"` // admin, write, read or none
+	}
+	path := fmt.Sprintf("/repos/%s/collaborators/%s/permission", repository, url.PathEscape(user))
+	err := c.do(ctx, repository, http.MethodGet, path, nil, &perm)
+	if isNotFound(err) {
+		return false, nil // Not a user
+	}
+	if err != nil {
+		return false, fmt.Errorf("get collaborator permission failed: %w", err)
+	}
+	return perm.Permission == "admin" || perm.Permission == "write", nil
+}
+
+// CreatePullRequest opens a pull request from head into base
+func (c *Client) CreatePullRequest(ctx context.Context, repository, head, base, title, body string) (*PullRequest, error) {
+	req := map[string]string{
+		"title": redact.String(title),
+		"head":  head,
+		"base":  base,
+		"body":  redact.String(body),
+	}
+
+	var pr PullRequest
+	if err := c.do(ctx, repository, http.MethodPost, fmt.Sprintf("/repos/%s/pulls", repository), req, &pr); err != nil {
+		return nil, fmt.Errorf("create pull request failed: %w", err)
+	}
+	return &pr, nil
+}
+
+// FindPullRequest returns the open pull request from branch, or nil, nil if there is none
+func (c *Client) FindPullRequest(ctx context.Context, repository, branch string) (*PullRequest, error) {
+	owner, _, _ := strings.Cut(repository, "/")
+	query := url.Values{"head": {owner + ":" + branch}, "state": {"open"}}
+	var prs []PullRequest
+	if err := c.do(ctx, repository, http.MethodGet, fmt.Sprintf("/repos/%s/pulls?%s", repository, query.Encode()), nil, &prs); err != nil {
+		return nil, fmt.Errorf("find pull request failed: %w", err)
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return &prs[0], nil
+}
+
+// UpdatePullRequest replaces the title and body of a pull request
+func (c *Client) UpdatePullRequest(ctx context.Context, repository string, number int, title, body string) (*PullRequest, error) {
+	req := map[string]string{
+		"title": redact.String(title),
+		"body":  redact.String(body),
+	}
+
+	var pr PullRequest
+	if err := c.do(ctx, repository, http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", repository, number), req, &pr); err != nil {
+		return nil, fmt.Errorf("update pull request failed: %w", err)
+	}
+	return &pr, nil
+}
+
+// ListReviewComments returns the line comments of a review, or of every
+// review on the pull request when reviewID is 0. Outdated comments are skipped.
+func (c *Client) ListReviewComments(ctx context.Context, repository string, number int, reviewID int64) ([]ReviewComment, error) {
+	path := fmt.Sprintf("/repos/%s/pulls/%d/comments?per_page=100", repository, number)
+	if reviewID != 0 {
+		path = fmt.Sprintf("/repos/%s/pulls/%d/reviews/%d/comments?per_page=100", repository, number, reviewID)
+	}
+
+	comments, err := listAll[ReviewComment](ctx, c, repository, path)
+	if err != nil {
+		return nil, fmt.Errorf("list review comments failed: %w", err)
+	}
+	current := comments[:0]
+	for _, comment := range comments {
+		if comment.Line != 0 {
+			current = append(current, comment)
+		}
+	}
+	return current, nil
+}
+
+// CreateComment posts a comment on an issue or pull request. Secrets are
+// scrubbed from the body before it is published.
+func (c *Client) CreateComment(ctx context.Context, repository string, issueNumber int, body string) (*IssueComment, error) {
+	var comment IssueComment
+	path := fmt.Sprintf("/repos/%s/issues/%d/comments", repository, issueNumber)
+	if err := c.do(ctx, repository, http.MethodPost, path, map[string]string{"body": redact.String(body)}, &comment); err != nil {
+		return nil, fmt.Errorf("create comment failed: %w", err)
+	}
+	return &comment, nil
+}
+
+// UpdateComment replaces the body of an issue or pull request comment.
+// Secrets are scrubbed from the body before it is published.
+func (c *Client) UpdateComment(ctx context.Context, repository string, commentID int64, body string) error {
+	path := fmt.Sprintf("/repos/%s/issues/comments/%d", repository, commentID)
+	if err := c.do(ctx, repository, http.MethodPatch, path, map[string]string{"body": redact.String(body)}, nil); err != nil {
+		return fmt.Errorf("update comment failed: %w", err)
+	}
+	return nil
+}
+
+// AddLabels adds labels to an issue
+func (c *Client) AddLabels(ctx context.Context, repository string, issueNumber int, labels []string) error {
+	path := fmt.Sprintf("/repos/%s/issues/%d/labels", repository, issueNumber)
+	if err := c.do(ctx, repository, http.MethodPost, path, map[string][]string{"labels": labels}, nil); err != nil {
+		return fmt.Errorf("add labels failed: %w", err)
+	}
+	return nil
+}
+
+// RemoveLabel removes a label from an issue. A label that is not set is ignored.
+func (c *Client) RemoveLabel(ctx context.Context, repository string, issueNumber int, label string) error {
+	path := fmt.Sprintf("/repos/%s/issues/%d/labels/%s", repository, issueNumber, url.PathEscape(label))
+	err := c.do(ctx, repository, http.MethodDelete, path, nil, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("remove label failed: %w", err)
+	}
+	return nil
+}
+
+// do sends a REST API request on behalf of repository, authenticated with
+// the token for that repository.
+func (c *Client) do(ctx context.Context, repository, method, path string, in, out any) error {
+	_, err := c.send(ctx, repository, method, path, in, out)
+	return err
+}
+
+// send is do, also returning the response headers
+func (c *Client) send(ctx context.Context, repository, method, path string, in, out any) (http.Header, error) {
+	token, err := c.tokens.Token(ctx, repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GitHub token: %w", err)
+	}
+	auth := ""
+	if token != "" {
+		auth = "Bearer " + token
+	}
+	return sendRequest(ctx, c.httpClient, c.config.APIBaseURL, auth, method, path, in, out)
+}
+
+// listAll GETs a list endpoint and every page after it (Link rel="next")
+func listAll[T any](ctx context.Context, c *Client, repository, path string) ([]T, error) {
+	var all []T
+	for path != "" {
+		var page []T
+		header, err := c.send(ctx, repository, http.MethodGet, path, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if path, err = nextPage(header, c.config.APIBaseURL); err != nil {
+			return nil, err
+		}
+	}
+	return all, nil
+}
+
+// nextPage returns the path of the rel="next" page in the Link header, or
+// "" on the last page. The token is only ever sent to baseURL.
+func nextPage(header http.Header, baseURL string) (string, error) {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		target = strings.Trim(strings.TrimSpace(target), "<>")
+		path, ok := strings.CutPrefix(target, strings.TrimRight(baseURL, "/"))
+		if !ok || !strings.HasPrefix(path, "/") {
+			return "", fmt.Errorf("next page %q is outside %s", target, baseURL)
+		}
+		return path, nil
+	}
+	return "", nil
+}
+
+// sendRequest sends a REST API request, decoding the JSON response into out (if non-nil).
+// Failures are classified for retry: 429/5xx and rate limits are transient.
+func sendRequest(ctx context.Context, httpClient *http.Client, baseURL, auth, method, path string, in, out any) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(baseURL, "/")+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", apiVersion)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// Network errors are worth retrying
+		return nil, &retry.TransientError{Err: fmt.Errorf("%s %s: %w", method, path, err)}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &retry.TransientError{Err: fmt.Errorf("failed to read response: %w", err)}
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out != nil && len(respBody) > 0 {
+			if err := json.Unmarshal(respBody, out); err != nil {
+				return nil, fmt.Errorf("failed to parse response: %w", err)
+			}
+		}
+		return resp.Header, nil
+	}
+
+	apiErr := newAPIError(resp, respBody)
+	slog.Warn("GitHub API request failed",
+		"method", method,
+		"path", path,
+		"status", apiErr.StatusCode,
+		"rate_limited", apiErr.RateLimited,
+		"retry_after", apiErr.RetryAfter,
+	)
+
+	if apiErr.RateLimited || retry.ClassifyHTTPStatus(apiErr.StatusCode) == retry.ErrorTypeTransient {
+		return nil, &retry.TransientError{Err: apiErr, RetryAfter: apiErr.RetryAfter}
+	}
+	return nil, &retry.PermanentError{Err: apiErr}
+}
+
+// newAPIError builds an APIError from a failed response, reading rate-limit headers
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Message != "" {
+		apiErr.Message = payload.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+
+	// Primary rate limit: 403/429 with no remaining requests
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		apiErr.RateLimited = true
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil && apiErr.RetryAfter == 0 {
+			apiErr.RetryAfter = max(time.Until(time.Unix(reset, 0)), 0)
+		}
+	}
+	// Secondary rate limit: 403 with Retry-After
+	if resp.StatusCode == http.StatusForbidden && apiErr.RetryAfter > 0 {
+		apiErr.RateLimited = true
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		apiErr.RateLimited = true
+	}
+
+	return apiErr
+}
+
+// isNotFound reports whether err is a 404 from the GitHub API
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,311 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/retry"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := NewClient(config.GitHubConfig{Token: "test-token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return c
+}
+
+func TestNewClient_DefaultAPIBaseURL(t *testing.T) {
+	c, err := NewClient(config.GitHubConfig{})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if c.config.APIBaseURL != DefaultAPIBaseURL {
+		t.Errorf("expected %s, got %s", DefaultAPIBaseURL, c.config.APIBaseURL)
+	}
+}
+
+func TestCreatePullRequest(t *testing.T) {
+	var got map[string]string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/repos/owner/repo/pulls" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			t.Errorf("unexpected Authorization header: %q", r.Header.Get("Authorization"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"number": 12, "html_url": "https://github.com/owner/repo/pull/12", "state": "open"}`))
+	})
+
+	pr, err := c.CreatePullRequest(context.Background(), "owner/repo", "auto-code/issue-1", "main", "Title", "Body")
+	if err != nil {
+		t.Fatalf("CreatePullRequest failed: %v", err)
+	}
+	if pr.Number != 12 || pr.HTMLURL != "https://github.com/owner/repo/pull/12" {
+		t.Errorf("unexpected PR: %+v", pr)
+	}
+	if got["head"] != "auto-code/issue-1" || got["base"] != "main" || got["title"] != "Title" {
+		t.Errorf("unexpected request body: %v", got)
+	}
+}
+
+func TestAddComment(t *testing.T) {
+	var body string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/issues/5/comments" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		body = req["body"]
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1}`))
+	})
+
+	if err := c.AddComment(context.Background(), "owner/repo", 5, "hello"); err != nil {
+		t.Fatalf("AddComment failed: %v", err)
+	}
+	if body != "hello" {
+		t.Errorf("expected comment body 'hello', got %q", body)
+	}
+}
+
+func TestUpdateComment(t *testing.T) {
+	var method, body string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/issues/comments/99" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		method = r.Method
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		body = req["body"]
+		w.Write([]byte(`{"id": 99}`))
+	})
+
+	if err := c.UpdateComment(context.Background(), "owner/repo", 99, "progress"); err != nil {
+		t.Fatalf("UpdateComment failed: %v", err)
+	}
+	if method != http.MethodPatch || body != "progress" {
+		t.Errorf("got %s with body %q", method, body)
+	}
+}
+
+func TestUpdateLabels(t *testing.T) {
+	var requests []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodDelete && r.URL.Path == "/repos/owner/repo/issues/5/labels/ai-task-in-progress" {
+			// Label not set on the issue
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Label does not exist"}`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	})
+
+	err := c.UpdateLabels(context.Background(), "owner/repo", 5,
+		[]string{"ai-task-done"}, []string{"ai-task", "ai-task-in-progress"})
+	if err != nil {
+		t.Fatalf("UpdateLabels failed: %v", err)
+	}
+
+	want := []string{
+		"POST /repos/owner/repo/issues/5/labels",
+		"DELETE /repos/owner/repo/issues/5/labels/ai-task",
+		"DELETE /repos/owner/repo/issues/5/labels/ai-task-in-progress",
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("expected %d requests, got %v", len(want), requests)
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Errorf("request %d = %s, want %s", i, requests[i], want[i])
+		}
+	}
+}
+
+func TestGetBranch_NotFound(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Branch not found"}`))
+	})
+
+	b, err := c.GetBranch(context.Background(), "owner/repo", "auto-code/issue-1")
+	if err != nil {
+		t.Fatalf("GetBranch failed: %v", err)
+	}
+	if b != nil {
+		t.Errorf("expected nil branch, got %+v", b)
+	}
+}
+
+func TestListReviewComments(t *testing.T) {
+	var paths []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`[
+			{"id": 1, "path": "main.go", "line": 12, "body": "rename this", "user": {"login": "reviewer"}},
+			{"id": 2, "path": "old.go", "line": null, "body": "outdated"}
+		]`))
+	})
+
+	comments, err := c.ListReviewComments(context.Background(), "owner/repo", 5, 0)
+	if err != nil {
+		t.Fatalf("ListReviewComments failed: %v", err)
+	}
+	if len(comments) != 1 || comments[0].Path != "main.go" || comments[0].Line != 12 || comments[0].User.Login != "reviewer" {
+		t.Errorf("unexpected comments: %+v", comments)
+	}
+
+	if _, err := c.ListReviewComments(context.Background(), "owner/repo", 5, 77); err != nil {
+		t.Fatalf("ListReviewComments failed: %v", err)
+	}
+	want := []string{"/repos/owner/repo/pulls/5/comments", "/repos/owner/repo/pulls/5/reviews/77/comments"}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+}
+
+func TestListReviewComments_Paginated(t *testing.T) {
+	var base string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s/repositories/1/pulls/5/comments?per_page=100&page=2>; rel="next", <%s/repositories/1/pulls/5/comments?per_page=100&page=2>; rel="last"`, base, base))
+			w.Write([]byte(`[{"id": 1, "path": "a.go", "line": 1, "body": "first page"}]`))
+		case "2":
+			w.Write([]byte(`[{"id": 2, "path": "b.go", "line": 2, "body": "second page"}]`))
+		}
+	})
+	base = c.config.APIBaseURL
+
+	comments, err := c.ListReviewComments(context.Background(), "owner/repo", 5, 0)
+	if err != nil {
+		t.Fatalf("ListReviewComments failed: %v", err)
+	}
+	if len(comments) != 2 || comments[1].Body != "second page" {
+		t.Errorf("expected comments from both pages, got %+v", comments)
+	}
+}
+
+func TestNextPage(t *testing.T) {
+	tests := []struct {
+		link    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{`<https://api.github.com/x?page=3>; rel="next", <https://api.github.com/x?page=9>; rel="last"`, "/x?page=3", false},
+		{`<https://api.github.com/x?page=1>; rel="prev"`, "", false},
+		// The token must not be sent elsewhere
+		{`<https://evil.example.com/x?page=2>; rel="next"`, "", true},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set("Link", tt.link)
+		got, err := nextPage(header, "https://api.github.com/")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("nextPage(%q) = %q, %v; want %q (error %v)", tt.link, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCanWrite(t *testing.T) {
+	permissions := map[string]string{"admin": "admin", "dev": "write", "reader": "read"}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		user := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/collaborators/"), "/permission")
+		perm, ok := permissions[user]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"permission": %q}`, perm)
+	})
+
+	for user, want := range map[string]bool{"admin": true, "dev": true, "reader": false, "stranger": false} {
+		got, err := c.CanWrite(context.Background(), "owner/repo", user)
+		if err != nil {
+			t.Fatalf("CanWrite(%s) failed: %v", user, err)
+		}
+		if got != want {
+			t.Errorf("CanWrite(%s) = %v, want %v", user, got, want)
+		}
+	}
+}
+
+func TestDo_ErrorClassification(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		headers       map[string]string
+		wantTransient bool
+		wantRateLimit bool
+		wantRetry     time.Duration
+	}{
+		{"server error", 502, nil, true, false, 0},
+		{"validation failed", 422, nil, false, false, 0},
+		{"unauthorized", 401, nil, false, false, 0},
+		{"too many requests", 429, map[string]string{"Retry-After": "30"}, true, true, 30 * time.Second},
+		{"secondary rate limit", 403, map[string]string{"Retry-After": "60"}, true, true, 60 * time.Second},
+		{"primary rate limit", 403, map[string]string{
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":     strconv.FormatInt(time.Now().Add(2*time.Minute).Unix(), 10),
+		}, true, true, 2 * time.Minute},
+		{"forbidden", 403, nil, false, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"message": "failure"}`))
+			})
+
+			_, err := c.GetRepository(context.Background(), "owner/repo")
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			var transient *retry.TransientError
+			if got := errors.As(err, &transient); got != tt.wantTransient {
+				t.Errorf("transient = %v, want %v (err: %v)", got, tt.wantTransient, err)
+			}
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected APIError, got %T", err)
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", apiErr.StatusCode, tt.status)
+			}
+			if apiErr.RateLimited != tt.wantRateLimit {
+				t.Errorf("rate limited = %v, want %v", apiErr.RateLimited, tt.wantRateLimit)
+			}
+			if transient != nil && transient.RetryAfter != apiErr.RetryAfter {
+				t.Errorf("transient retry after = %v, want %v", transient.RetryAfter, apiErr.RetryAfter)
+			}
+			// Allow clock skew for reset-based delays
+			if diff := apiErr.RetryAfter - tt.wantRetry; diff > time.Second || diff < -2*time.Second {
+				t.Errorf("retry after = %v, want ~%v", apiErr.RetryAfter, tt.wantRetry)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
+)
+
+const (
+	// jwtLifetime is below GitHub's 10 minute maximum
+	jwtLifetime = 9 * time.Minute
+	// tokenRefreshMargin renews installation tokens before they expire
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// TokenSource provides the access token used for a repository
+type TokenSource interface {
+	Token(ctx context.Context, repository string) (string, error)
+}
+
+// staticToken is a personal access token used for every repository
+type staticToken string
+
+func (t staticToken) Token(ctx context.Context, repository string) (string, error) {
+	return string(t), nil
+}
+
+// AppTokenSource authenticates as a GitHub App and issues installation
+// access tokens scoped to a single repository, cached until shortly before
+// they expire.
+type AppTokenSource struct {
+	appID          int64
+	installationID int64 // 0 = look up the installation per repository
+	key            *rsa.PrivateKey
+	baseURL        string
+	httpClient     *http.Client
+	now            func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]installationToken
+	issue  map[string]*sync.Mutex // Per repository: one token request at a time
+}
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewAppTokenSource creates a token source from the GitHub App config
+func NewAppTokenSource(cfg config.GitHubAppConfig, baseURL string, httpClient *http.Client) (*AppTokenSource, error) {
+	pemData, err := os.ReadFile(cfg.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GitHub App private key: %w", err)
+	}
+	key, err := parsePrivateKey(pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppTokenSource{
+		appID:          cfg.AppID,
+		installationID: cfg.InstallationID,
+		key:            key,
+		baseURL:        baseURL,
+		httpClient:     httpClient,
+		now:            time.Now,
+		tokens:         make(map[string]installationToken),
+		issue:          make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// Token returns a cached or newly issued installation token for repository.
+// Requests for other repositories are not held up while a token is issued.
+func (s *AppTokenSource) Token(ctx context.Context, repository string) (string, error) {
+	s.mu.Lock()
+	issue, ok := s.issue[repository]
+	if !ok {
+		issue = &sync.Mutex{}
+		s.issue[repository] = issue
+	}
+	s.mu.Unlock()
+
+	// Concurrent callers for the same repository wait for one token
+	issue.Lock()
+	defer issue.Unlock()
+
+	if token, ok := s.cached(repository); ok {
+		return token, nil
+	}
+
+	jwt, err := s.signJWT()
+	if err != nil {
+		return "", err
+	}
+	auth := "Bearer " + jwt
+
+	installationID := s.installationID
+	if installationID == 0 {
+		var installation struct {
+			ID int64 `json:"id"`
+		}
+		if _, err := sendRequest(ctx, s.httpClient, s.baseURL, auth, http.MethodGet, fmt.Sprintf("/repos/%s/installation", repository), nil, &installation); err != nil {
+			return "", fmt.Errorf("failed to find app installation for %s: %w", repository, err)
+		}
+		installationID = installation.ID
+	}
+
+	// Scope the token to the target repository only
+	_, name, _ := strings.Cut(repository, "/")
+	req := map[string][]string{"repositories": {name}}
+
+	var token installationToken
+	path := fmt.Sprintf("/app/installations/%d/access_tokens", installationID)
+	if _, err := sendRequest(ctx, s.httpClient, s.baseURL, auth, http.MethodPost, path, req, &token); err != nil {
+		return "", fmt.Errorf("failed to create installation token: %w", err)
+	}
+
+	redact.Register(token.Token)
+	s.mu.Lock()
+	s.tokens[repository] = token
+	s.mu.Unlock()
+	slog.Info("GitHub App installation token issued",
+		"repository", repository,
+		"installation_id", installationID,
+		"expires_at", token.ExpiresAt,
+	)
+	return token.Token, nil
+}
+
+// cached returns the token for repository unless it is about to expire
+func (s *AppTokenSource) cached(repository string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cached, ok := s.tokens[repository]
+	if !ok || !s.now().Add(tokenRefreshMargin).Before(cached.ExpiresAt) {
+		return "", false
+	}
+	return cached.Token, true
+}
+
+// appSlug returns the app's URL name. Its bot user is "<slug>[bot]".
+func (s *AppTokenSource) appSlug(ctx context.Context) (string, error) {
+	jwt, err := s.signJWT()
+	if err != nil {
+		return "", err
+	}
+
+	var app struct {
+		Slug string `json:"slug"`
+	}
+	if _, err := sendRequest(ctx, s.httpClient, s.baseURL, "Bearer "+jwt, http.MethodGet, "/app", nil, &app); err != nil {
+		return "", fmt.Errorf("failed to get GitHub App: %w", err)
+	}
+	return app.Slug, nil
+}
+
+// signJWT creates the RS256 JWT that authenticates as the app itself
+func (s *AppTokenSource) signJWT() (string, error) {
+	now := s.now()
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]int64{
+		"iat": now.Add(-60 * time.Second).Unix(), // Allow for clock drift
+		"exp": now.Add(jwtLifetime).Unix(),
+		"iss": s.appID,
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(headerJSON) + "." + enc.EncodeToString(claimsJSON)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+	return signingInput + "." + enc.EncodeToString(sig), nil
+}
+
+// parsePrivateKey reads a PKCS#1 or PKCS#8 PEM encoded RSA key
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("GitHub App private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key is not an RSA key")
+	}
+	return key, nil
+}
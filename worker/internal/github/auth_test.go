@@ -0,0 +1,285 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
+)
+
+// fakeAppServer issues installation tokens after verifying the app JWT
+type fakeAppServer struct {
+	t       *testing.T
+	key     *rsa.PublicKey
+	issued  atomic.Int32
+	repos   []string
+	expires time.Time
+}
+
+func (f *fakeAppServer) handle(w http.ResponseWriter, r *http.Request) {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/installation":
+		f.verifyJWT(auth)
+		w.Write([]byte(`{"id": 77}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/app/installations/77/access_tokens":
+		f.verifyJWT(auth)
+		var req struct {
+			Repositories []string `json:"repositories"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.repos = req.Repositories
+		n := f.issued.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"token":      "ghs_installation_" + strconv.Itoa(int(n)),
+			"expires_at": f.expires.Format(time.RFC3339),
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/app":
+		f.verifyJWT(auth)
+		w.Write([]byte(`{"slug": "codingworker"}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/users/codingworker[bot]":
+		w.Write([]byte(`{"login": "codingworker[bot]", "id": 41898282}`))
+	case r.URL.Path == "/repos/owner/repo/issues/1/comments":
+		if auth != "ghs_installation_1" {
+			f.t.Errorf("expected installation token, got %q", auth)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeAppServer) verifyJWT(jwt string) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		f.t.Fatalf("malformed JWT: %q", jwt)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], sig); err != nil {
+		f.t.Errorf("invalid JWT signature: %v", err)
+	}
+
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims map[string]int64
+	json.Unmarshal(claimsJSON, &claims)
+	if claims["iss"] != 12345 {
+		f.t.Errorf("expected iss 12345, got %d", claims["iss"])
+	}
+	if lifetime := claims["exp"] - claims["iat"]; lifetime > 600 {
+		f.t.Errorf("JWT lifetime %ds exceeds 10 minutes", lifetime)
+	}
+}
+
+func writeTestKey(t *testing.T) (string, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	path := filepath.Join(t.TempDir(), "app.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	return path, key
+}
+
+func newAppTestClient(t *testing.T, expires time.Time) (*Client, *fakeAppServer) {
+	t.Helper()
+	keyPath, key := writeTestKey(t)
+	fake := &fakeAppServer{t: t, key: &key.PublicKey, expires: expires}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient(config.GitHubConfig{
+		APIBaseURL: srv.URL,
+		App:        config.GitHubAppConfig{AppID: 12345, PrivateKeyPath: keyPath},
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return c, fake
+}
+
+func TestAppTokenSource_IssuesScopedToken(t *testing.T) {
+	c, fake := newAppTestClient(t, time.Now().Add(time.Hour))
+
+	if err := c.AddComment(context.Background(), "owner/repo", 1, "from the bot"); err != nil {
+		t.Fatalf("AddComment failed: %v", err)
+	}
+	if len(fake.repos) != 1 || fake.repos[0] != "repo" {
+		t.Errorf("expected token scoped to [repo], got %v", fake.repos)
+	}
+}
+
+func TestAppTokenSource_CachesUntilExpiry(t *testing.T) {
+	c, fake := newAppTestClient(t, time.Now().Add(time.Hour))
+	src := c.tokens.(*AppTokenSource)
+
+	for i := 0; i < 3; i++ {
+		if _, err := src.Token(context.Background(), "owner/repo"); err != nil {
+			t.Fatalf("Token failed: %v", err)
+		}
+	}
+	if fake.issued.Load() != 1 {
+		t.Errorf("expected 1 token to be issued, got %d", fake.issued.Load())
+	}
+
+	// Close to expiry the token is renewed
+	src.now = func() time.Time { return time.Now().Add(58 * time.Minute) }
+	token, err := src.Token(context.Background(), "owner/repo")
+	if err != nil {
+		t.Fatalf("Token failed: %v", err)
+	}
+	if fake.issued.Load() != 2 || token != "ghs_installation_2" {
+		t.Errorf("expected a renewed token, got %q (issued %d)", token, fake.issued.Load())
+	}
+}
+
+func TestAppTokenSource_ConcurrentRepositories(t *testing.T) {
+	keyPath, _ := writeTestKey(t)
+	slowRequested := make(chan struct{})
+	release := make(chan struct{})
+	var issued atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Repositories []string `json:"repositories"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Repositories[0] == "slow" {
+			close(slowRequested)
+			<-release
+		}
+		issued.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"token":      "ghs_" + req.Repositories[0],
+			"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	src, err := NewAppTokenSource(config.GitHubAppConfig{AppID: 12345, InstallationID: 77, PrivateKeyPath: keyPath}, srv.URL, srv.Client())
+	if err != nil {
+		t.Fatalf("NewAppTokenSource failed: %v", err)
+	}
+
+	// Callers for the slow repository share one token request
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := src.Token(context.Background(), "owner/slow"); err != nil || token != "ghs_slow" {
+				t.Errorf("Token(owner/slow) = %q, %v", token, err)
+			}
+		}()
+	}
+	<-slowRequested
+
+	// Another repository is not blocked behind the slow exchange
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := src.Token(context.Background(), "owner/fast"); err != nil {
+			t.Errorf("Token(owner/fast) failed: %v", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("token for owner/fast waited for owner/slow")
+	}
+
+	close(release)
+	wg.Wait()
+	if issued.Load() != 2 {
+		t.Errorf("expected 2 tokens to be issued, got %d", issued.Load())
+	}
+}
+
+func TestCommitIdentity_App(t *testing.T) {
+	c, _ := newAppTestClient(t, time.Now().Add(time.Hour))
+
+	author, err := c.commitIdentity(context.Background(), "owner/repo")
+	if err != nil {
+		t.Fatalf("commitIdentity failed: %v", err)
+	}
+	want := identity{Name: "codingworker[bot]", Email: "41898282+codingworker[bot]@users.noreply.github.com"}
+	if *author != want {
+		t.Errorf("commitIdentity = %+v, want %+v", *author, want)
+	}
+}
+
+func TestGitAuthEnv(t *testing.T) {
+	c, _ := newAppTestClient(t, time.Now().Add(time.Hour))
+
+	env, err := c.gitAuthEnv(context.Background(), "owner/repo")
+	if err != nil {
+		t.Fatalf("gitAuthEnv failed: %v", err)
+	}
+
+	basic := base64.StdEncoding.EncodeToString([]byte("x-access-token:ghs_installation_1"))
+	expected := []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.https://github.com/.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + basic,
+	}
+	if strings.Join(env, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected env: %v", env)
+	}
+
+	// The token must never end up in the remote URL or argv
+	for _, e := range env {
+		if strings.Contains(e, "ghs_installation_1") {
+			t.Errorf("raw token leaked into env: %s", e)
+		}
+	}
+	if got := redact.String("token " + basic); strings.Contains(got, basic) {
+		t.Errorf("encoded credentials not registered for redaction: %s", got)
+	}
+}
+
+func TestGitAuthEnv_NoToken(t *testing.T) {
+	c := &Client{tokens: staticToken("")}
+
+	env, err := c.gitAuthEnv(context.Background(), "owner/repo")
+	if err != nil {
+		t.Fatalf("gitAuthEnv failed: %v", err)
+	}
+	if len(env) != 0 {
+		t.Errorf("expected no env without a token, got %v", env)
+	}
+}
+
+func TestNewClient_InvalidAppKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.pem")
+	os.WriteFile(path, []byte("not a key"), 0600)
+
+	_, err := NewClient(config.GitHubConfig{
+		App: config.GitHubAppConfig{AppID: 1, PrivateKeyPath: path},
+	})
+	if err == nil {
+		t.Error("expected error for invalid private key")
+	}
+}
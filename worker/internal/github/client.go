@@ -2,58 +2,131 @@ package github
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/redact"
 	"github.com/OkadaSatoshi/codingworker/worker/internal/retry"
 	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
 )
 
-// Client handles GitHub operations
+// Branch policies for a task branch that already exists on GitHub
+const (
+	BranchPolicyUpdate = "update" // Force-update the branch and edit its open PR
+	BranchPolicySuffix = "suffix" // Push to auto-code/issue-N-2, -3, ... instead
+)
+
+// maxBranchSuffix bounds the search for a free suffixed branch name
+const maxBranchSuffix = 20
+
+// Client handles GitHub operations (git for clone/push, REST API for the rest)
 type Client struct {
-	config config.GitHubConfig
+	config     config.GitHubConfig
+	httpClient *http.Client
+	tokens     TokenSource
+	prBody     *template.Template // github.pr_body_template (nil = default)
+
+	mu       sync.Mutex
+	commitAs *identity // Looked up on first use
 }
 
-// NewClient creates a new GitHub client
-func NewClient(cfg config.GitHubConfig) *Client {
-	return &Client{
-		config: cfg,
+// identity is the author and committer of the worker's commits
+type identity struct {
+	Name  string
+	Email string
+}
+
+// NewClient creates a new GitHub client. It authenticates as a GitHub App
+// when github.app.app_id is set, otherwise with the personal access token.
+func NewClient(cfg config.GitHubConfig) (*Client, error) {
+	if cfg.APIBaseURL == "" {
+		cfg.APIBaseURL = DefaultAPIBaseURL
+	}
+	redact.Register(cfg.Token)
+	c := &Client{
+		config:     cfg,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		tokens:     staticToken(cfg.Token),
+	}
+
+	if cfg.PRBodyTemplate != "" {
+		tmpl, err := loadPRBodyTemplate(cfg.PRBodyTemplate)
+		if err != nil {
+			return nil, err
+		}
+		c.prBody = tmpl
 	}
+
+	if cfg.App.AppID != 0 {
+		appTokens, err := NewAppTokenSource(cfg.App, cfg.APIBaseURL, c.httpClient)
+		if err != nil {
+			return nil, err
+		}
+		c.tokens = appTokens
+		slog.Info("Using GitHub App authentication", "app_id", cfg.App.AppID)
+	}
+
+	return c, nil
 }
 
-// CloneAndBranch clones a repository and creates a new branch
-func (c *Client) CloneAndBranch(ctx context.Context, repository string, issueNumber int) (string, error) {
-	// Create work directory
-	workDir := filepath.Join(c.config.CloneBaseDir, fmt.Sprintf("issue-%d-%d", issueNumber, time.Now().Unix()))
-	if err := os.MkdirAll(workDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create work directory: %w", err)
+// gitAuthEnv returns environment variables that make git send the current
+// access token as an HTTP header. Passing it through GIT_CONFIG_* keeps the
+// token out of the remote URL, .git/config and the process argument list.
+func (c *Client) gitAuthEnv(ctx context.Context, repository string) ([]string, error) {
+	token, err := c.tokens.Token(ctx, repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GitHub token: %w", err)
+	}
+	if token == "" {
+		return nil, nil
 	}
 
-	// Clone repository
-	repoURL := fmt.Sprintf("https://github.com/%s.git", repository)
-	if c.config.Token != "" {
-		repoURL = fmt.Sprintf("https://%s@github.com/%s.git", c.config.Token, repository)
+	// x-access-token works for both personal access and installation tokens
+	basic := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	redact.Register(basic)
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.https://github.com/.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + basic,
+	}, nil
+}
+
+// authenticatedGit creates a git command that authenticates to GitHub
+func (c *Client) authenticatedGit(ctx context.Context, repository, dir string, args ...string) (*exec.Cmd, error) {
+	env, err := c.gitAuthEnv(ctx, repository)
+	if err != nil {
+		return nil, err
 	}
 
-	slog.Info("Cloning repository", "repository", repository, "work_dir", workDir)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	return cmd, nil
+}
 
-	cmd := exec.CommandContext(ctx, "git", "clone", "--depth", "1", repoURL, workDir)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		wrapped := retry.WrapWithClassification(err, string(output))
-		return "", fmt.Errorf("git clone failed: %w, output: %s", wrapped, string(output))
+// CloneAndBranch clones a repository and creates a new branch
+func (c *Client) CloneAndBranch(ctx context.Context, repository string, issueNumber int) (string, error) {
+	workDir, err := c.clone(ctx, repository, issueNumber)
+	if err != nil {
+		return "", err
 	}
 
 	// Create and checkout new branch
 	branchName := fmt.Sprintf("auto-code/issue-%d", issueNumber)
-	cmd = exec.CommandContext(ctx, "git", "checkout", "-b", branchName)
+	cmd := exec.CommandContext(ctx, "git", "checkout", "-b", branchName)
 	cmd.Dir = workDir
 	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(workDir)
 		return "", fmt.Errorf("git checkout failed: %w, output: %s", err, string(output))
 	}
 
@@ -61,8 +134,51 @@ func (c *Client) CloneAndBranch(ctx context.Context, repository string, issueNum
 	return workDir, nil
 }
 
-// PushAndCreatePR pushes changes and creates a pull request
-func (c *Client) PushAndCreatePR(ctx context.Context, workDir string, msg *sqs.Message) (string, error) {
+// CheckoutExistingBranch clones a repository at an existing branch, for
+// follow-up commits to an open pull request
+func (c *Client) CheckoutExistingBranch(ctx context.Context, repository string, issueNumber int, branchName string) (string, error) {
+	workDir, err := c.clone(ctx, repository, issueNumber, "--branch", branchName)
+	if err != nil {
+		return "", err
+	}
+
+	slog.Info("Branch checked out", "branch", branchName)
+	return workDir, nil
+}
+
+// clone makes a shallow clone of repository in a new work directory
+func (c *Client) clone(ctx context.Context, repository string, issueNumber int, extraArgs ...string) (string, error) {
+	// Create a unique work directory (tasks may run concurrently)
+	if err := os.MkdirAll(c.config.CloneBaseDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create clone base directory: %w", err)
+	}
+	workDir, err := os.MkdirTemp(c.config.CloneBaseDir, fmt.Sprintf("issue-%d-", issueNumber))
+	if err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+
+	// Clone repository
+	repoURL := fmt.Sprintf("https://github.com/%s.git", repository)
+	slog.Info("Cloning repository", "repository", repository, "work_dir", workDir)
+
+	args := append([]string{"clone", "--depth", "1"}, extraArgs...)
+	cmd, err := c.authenticatedGit(ctx, repository, "", append(args, repoURL, workDir)...)
+	if err != nil {
+		os.RemoveAll(workDir)
+		return "", err
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(workDir)
+		wrapped := retry.WrapWithClassification(err, string(output))
+		return "", fmt.Errorf("git clone failed: %w, output: %s", wrapped, string(output))
+	}
+	return workDir, nil
+}
+
+// Push commits any uncommitted changes and pushes the task branch, returning
+// its name. base is the commit the task started from; the branch must have
+// new commits on top of it. Pushing again after a failed attempt is safe.
+func (c *Client) Push(ctx context.Context, workDir, base string, msg *sqs.Message) (string, error) {
 	// Get branch name
 	cmd := exec.CommandContext(ctx, "git", "branch", "--show-current")
 	cmd.Dir = workDir
@@ -72,92 +188,288 @@ func (c *Client) PushAndCreatePR(ctx context.Context, workDir string, msg *sqs.M
 	}
 	branchName := strings.TrimSpace(string(branchOutput))
 
-	// Check if there are changes to commit
-	cmd = exec.CommandContext(ctx, "git", "status", "--porcelain")
+	// Commit changes the agent left in the working tree (Aider commits its
+	// own, the direct agent does not). Aider's history files stay out.
+	cmd = exec.CommandContext(ctx, "git", "add", "-A", "--", ".", ":(exclude).aider*")
 	cmd.Dir = workDir
-	statusOutput, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("git status failed: %w", err)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("git add failed: %w, output: %s", err, string(output))
+	}
+	if hasStagedChanges(ctx, workDir) {
+		author, err := c.commitIdentity(ctx, msg.Repository)
+		if err != nil {
+			return "", err
+		}
+		cmd = exec.CommandContext(ctx, "git", "commit", "-q", "-m", commitMessage(msg))
+		cmd.Dir = workDir
+		if author != nil {
+			cmd.Env = append(os.Environ(),
+				"GIT_AUTHOR_NAME="+author.Name,
+				"GIT_AUTHOR_EMAIL="+author.Email,
+				"GIT_COMMITTER_NAME="+author.Name,
+				"GIT_COMMITTER_EMAIL="+author.Email,
+			)
+		}
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("git commit failed: %w, output: %s", err, string(output))
+		}
 	}
 
-	if len(statusOutput) == 0 {
+	// Check that the branch has commits to propose
+	cmd = exec.CommandContext(ctx, "git", "rev-list", "--count", base+"..HEAD")
+	cmd.Dir = workDir
+	countOutput, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-list failed: %w", err)
+	}
+	if strings.TrimSpace(string(countOutput)) == "0" {
 		slog.Warn("No changes to commit")
-		return "", fmt.Errorf("no changes generated by Aider")
+		return "", &retry.PermanentError{Err: fmt.Errorf("no changes generated by Aider")}
+	}
+
+	// A previous run may have pushed the branch already
+	branchName, pushArgs, err := c.resolveBranch(ctx, workDir, msg.Repository, branchName)
+	if err != nil {
+		return "", err
 	}
 
-	// Push branch
+	// Push branch (fetches a fresh token: installation tokens may have
+	// expired during a long Aider run)
 	slog.Info("Pushing branch", "branch", branchName)
-	cmd = exec.CommandContext(ctx, "git", "push", "-u", "origin", branchName)
-	cmd.Dir = workDir
+	cmd, err = c.authenticatedGit(ctx, msg.Repository, workDir, pushArgs...)
+	if err != nil {
+		return "", err
+	}
 	if output, err := cmd.CombinedOutput(); err != nil {
 		wrapped := retry.WrapWithClassification(err, string(output))
 		return "", fmt.Errorf("git push failed: %w, output: %s", wrapped, string(output))
 	}
 
-	// Create PR using gh CLI
-	prTitle := fmt.Sprintf("[auto-code] %s", msg.Title)
-	prBody := c.buildPRBody(msg)
+	return branchName, nil
+}
 
-	slog.Info("Creating pull request", "title", prTitle)
-	cmd = exec.CommandContext(ctx, "gh", "pr", "create",
-		"--title", prTitle,
-		"--body", prBody,
-		"--head", branchName,
-	)
+// resolveBranch decides how to push branchName when it already exists on
+// GitHub with other commits: force-update it (branch_policy: update) or
+// switch to the first free suffixed name (branch_policy: suffix). Returns the
+// branch to push and the git push arguments.
+func (c *Client) resolveBranch(ctx context.Context, workDir, repository, branchName string) (string, []string, error) {
+	remote, err := c.GetBranch(ctx, repository, branchName)
+	if err != nil {
+		return "", nil, err
+	}
+	if remote == nil {
+		return branchName, []string{"push", "-u", "origin", branchName}, nil
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
 	cmd.Dir = workDir
-	prOutput, err := cmd.CombinedOutput()
+	head, err := cmd.Output()
 	if err != nil {
-		wrapped := retry.WrapWithClassification(err, string(prOutput))
-		return "", fmt.Errorf("gh pr create failed: %w, output: %s", wrapped, string(prOutput))
+		return "", nil, fmt.Errorf("git rev-parse failed: %w", err)
 	}
+	if remote.Commit.SHA == strings.TrimSpace(string(head)) {
+		// Pushed by an earlier attempt of this task
+		return branchName, []string{"push", "-u", "origin", branchName}, nil
+	}
+	cmd = exec.CommandContext(ctx, "git", "merge-base", "--is-ancestor", remote.Commit.SHA, "HEAD")
+	cmd.Dir = workDir
+	if cmd.Run() == nil {
+		// Follow-up commits on top of the remote branch
+		return branchName, []string{"push", "-u", "origin", branchName}, nil
+	}
+
+	if c.config.BranchPolicy == BranchPolicySuffix {
+		for i := 2; i <= maxBranchSuffix; i++ {
+			candidate := fmt.Sprintf("%s-%d", branchName, i)
+			b, err := c.GetBranch(ctx, repository, candidate)
+			if err != nil {
+				return "", nil, err
+			}
+			if b != nil {
+				continue
+			}
 
-	prURL := strings.TrimSpace(string(prOutput))
-	return prURL, nil
+			slog.Info("Branch already exists, using a new branch", "existing", branchName, "branch", candidate)
+			cmd := exec.CommandContext(ctx, "git", "branch", "-m", candidate)
+			cmd.Dir = workDir
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return "", nil, fmt.Errorf("git branch -m failed: %w, output: %s", err, string(output))
+			}
+			return candidate, []string{"push", "-u", "origin", candidate}, nil
+		}
+		return "", nil, &retry.PermanentError{Err: fmt.Errorf("no free branch name for %s (tried up to -%d)", branchName, maxBranchSuffix)}
+	}
+
+	// Only overwrite the commit we looked at, never a concurrent push
+	slog.Info("Branch already exists, force-updating", "branch", branchName, "previous", remote.Commit.SHA)
+	lease := fmt.Sprintf("--force-with-lease=%s:%s", branchName, remote.Commit.SHA)
+	return branchName, []string{"push", "-u", lease, "origin", branchName}, nil
 }
 
-// AddComment adds a comment to an issue
-func (c *Client) AddComment(ctx context.Context, repository string, issueNumber int, body string) error {
-	// Use gh CLI to add comment
-	cmd := exec.CommandContext(ctx, "gh", "issue", "comment",
-		fmt.Sprintf("%d", issueNumber),
-		"--repo", repository,
-		"--body", body,
-	)
+// commitIdentity returns who the worker's commits are attributed to: the GitHub
+// App's bot user, or the owner of the personal access token. Nil without a
+// token, leaving the local git identity in place.
+func (c *Client) commitIdentity(ctx context.Context, repository string) (*identity, error) {
+	c.mu.Lock()
+	cached := c.commitAs
+	c.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	path := "/user"
+	if app, ok := c.tokens.(*AppTokenSource); ok {
+		slug, err := app.appSlug(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Installation tokens have no user of their own
+		path = "/users/" + url.PathEscape(slug+"[bot]")
+	} else if token, _ := c.tokens.Token(ctx, repository); token == "" {
+		return nil, nil
+	}
+
+	var user struct {
+		Login string `json:"login"`
+		ID    int64  `json:"id"`
+	}
+	if err := c.do(ctx, repository, http.MethodGet, path, nil, &user); err != nil {
+		return nil, fmt.Errorf("failed to look up commit author: %w", err)
+	}
+
+	// GitHub links commits to the account through its noreply address
+	author := &identity{
+		Name:  user.Login,
+		Email: fmt.Sprintf("%d+%s@users.noreply.github.com", user.ID, user.Login),
+	}
+	c.mu.Lock()
+	c.commitAs = author
+	c.mu.Unlock()
+	return author, nil
+}
+
+// commitMessage returns the message for changes the agent left uncommitted
+func commitMessage(msg *sqs.Message) string {
+	if msg.IsFollowUp() {
+		return fmt.Sprintf("Address review comments (#%d)", msg.PRNumber)
+	}
+	return fmt.Sprintf("%s (#%d)", msg.Title, msg.IssueNumber)
+}
+
+// hasStagedChanges reports whether the index differs from HEAD
+func hasStagedChanges(ctx context.Context, workDir string) bool {
+	cmd := exec.CommandContext(ctx, "git", "diff", "--cached", "--quiet")
+	cmd.Dir = workDir
+	return cmd.Run() != nil
+}
 
-	output, err := cmd.CombinedOutput()
+// CreatePR opens a pull request for a pushed branch against the default
+// branch, or updates the pull request already open for it
+func (c *Client) CreatePR(ctx context.Context, branchName string, msg *sqs.Message, details PRDetails) (string, error) {
+	repo, err := c.GetRepository(ctx, msg.Repository)
 	if err != nil {
-		return fmt.Errorf("gh issue comment failed: %w, output: %s", err, string(output))
+		return "", err
+	}
+
+	prTitle := fmt.Sprintf("[auto-code] %s", msg.Title)
+	prBody := c.buildPRBody(msg, details)
+
+	// Reuse the open PR of an earlier run (its branch was just updated)
+	existing, err := c.FindPullRequest(ctx, msg.Repository, branchName)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		slog.Info("Updating existing pull request", "number", existing.Number, "branch", branchName)
+		if _, err := c.UpdatePullRequest(ctx, msg.Repository, existing.Number, prTitle, prBody); err != nil {
+			return "", err
+		}
+		return existing.HTMLURL, nil
+	}
+
+	slog.Info("Creating pull request", "title", prTitle, "base", repo.DefaultBranch)
+	pr, err := c.CreatePullRequest(ctx, msg.Repository, branchName, repo.DefaultBranch, prTitle, prBody)
+	if err != nil {
+		return "", err
+	}
+
+	return pr.HTMLURL, nil
+}
+
+// ReplyToReview reports a follow-up commit on its pull request and returns
+// the comment URL
+func (c *Client) ReplyToReview(ctx context.Context, msg *sqs.Message, details PRDetails) (string, error) {
+	comment, err := c.CreateComment(ctx, msg.Repository, msg.PRNumber, c.buildFollowUpBody(msg, details))
+	if err != nil {
+		return "", err
+	}
+
+	slog.Info("Follow-up reported on pull request", "repository", msg.Repository, "pr", msg.PRNumber)
+	return comment.HTMLURL, nil
+}
+
+// AddComment adds a comment to an issue
+func (c *Client) AddComment(ctx context.Context, repository string, issueNumber int, body string) error {
+	if _, err := c.CreateComment(ctx, repository, issueNumber, body); err != nil {
+		return err
 	}
 
 	slog.Info("Comment added to issue", "repository", repository, "issue", issueNumber)
 	return nil
 }
 
-// buildPRBody creates the PR description
-func (c *Client) buildPRBody(msg *sqs.Message) string {
-	return fmt.Sprintf(`## 自動生成されたコード
+// UpdateLabels adds and removes issue labels
+func (c *Client) UpdateLabels(ctx context.Context, repository string, issueNumber int, add, remove []string) error {
+	if len(add) > 0 {
+		if err := c.AddLabels(ctx, repository, issueNumber, add); err != nil {
+			return err
+		}
+	}
+	for _, label := range remove {
+		if err := c.RemoveLabel(ctx, repository, issueNumber, label); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Issue labels updated",
+		"repository", repository,
+		"issue", issueNumber,
+		"added", add,
+		"removed", remove,
+	)
+	return nil
+}
+
+func (c *Client) buildFollowUpBody(msg *sqs.Message, details PRDetails) string {
+	requester := ""
+	if msg.CommentAuthor != "" {
+		requester = fmt.Sprintf("**依頼者**: @%s\n", msg.CommentAuthor)
+	}
+	return fmt.Sprintf(`## 🤖 CodingWorker: レビュー指摘に対応しました
 
-このPRは CodingWorker によって自動生成されました。
+修正を `+"`%s`"+` ブランチに追加コミットしました。
 
-**関連Issue**: #%d
-**生成モデル**: Ollama qwen2.5-coder:1.5b (via Aider)
+%s**生成モデル**: %s (via %s)%s
 **生成日時**: %s
-**生成方式**: 2パス（実装 + テスト自動生成）
-
-### タスク内容
-%s
+**検証プロファイル**: %s
 
 ### 自動検証結果
-- [x] ビルド成功 (go build)
-- [x] Lint通過 (go fmt, go vet)
-- [x] テスト通過 (go test)
-
-### 確認事項
-- [ ] コードが期待通りに動作するか
-- [ ] テストカバレッジが十分か
-`,
-		msg.IssueNumber,
+%s`,
+		msg.BranchName,
+		requester,
+		details.Model,
+		details.Agent,
+		formatFallback(details.Models),
 		time.Now().Format("2006-01-02 15:04:05"),
-		msg.Body,
+		details.Profile,
+		formatSteps(details.Steps),
 	)
 }
+
+// formatFallback shows the model chain when earlier models gave up
+func formatFallback(models []string) string {
+	if len(models) < 2 {
+		return ""
+	}
+	return "\n**モデルフォールバック**: " + strings.Join(models, " → ")
+}
@@ -0,0 +1,343 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/retry"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+func TestBuildPRBody(t *testing.T) {
+	c := &Client{}
+	msg := &sqs.Message{IssueNumber: 42, Body: "Add a greeting"}
+
+	body := c.buildPRBody(msg, PRDetails{
+		Agent:       "aider",
+		Model:       "ollama_chat/qwen2.5-coder:7b",
+		Models:      []string{"ollama_chat/qwen2.5-coder:1.5b", "ollama_chat/qwen2.5-coder:7b"},
+		Profile:     config.ProfileNode,
+		Invocations: 5,
+		Passes: []PassStats{
+			{Pass: PassImplementation, Invocations: 2, FixAttempts: 1},
+			{Pass: PassTests, Invocations: 1},
+		},
+		Steps: []StepResult{
+			{Pass: PassImplementation, Stage: "build", Run: "npm install", Passed: false, Duration: 1200 * time.Millisecond},
+			{Pass: PassImplementation, Stage: "build", Run: "npm install", Passed: true, Duration: 2 * time.Second},
+		},
+		Files: []FileStat{{Path: "index.js", Added: 3, Deleted: 1}, {Path: "logo.png", Binary: true}},
+	})
+
+	for _, want := range []string{
+		"Closes #42",
+		"**生成モデル**: ollama_chat/qwen2.5-coder:7b (via aider)",
+		"**モデルフォールバック**: ollama_chat/qwen2.5-coder:1.5b → ollama_chat/qwen2.5-coder:7b",
+		"**検証プロファイル**: node",
+		"**エージェント実行回数**: 5",
+		"| Pass 1 (実装) | 2 | 1 |",
+		"| Pass 2 (テスト作成) | 1 | 0 |",
+		"- ❌ Pass 1 (実装) build: `npm install` (1.2s)",
+		"- ✅ Pass 1 (実装) build: `npm install` (2s)",
+		"- `index.js` (+3 -1)",
+		"- `logo.png` (バイナリ)",
+		"2 ファイル, +3 -1",
+		"Add a greeting",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("PR body missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestBuildPRBody_CustomTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pr.md.tmpl")
+	os.WriteFile(path, []byte("Fixes #{{.Issue.IssueNumber}} with {{.Result.Model}}\n{{range .Result.Steps}}{{.Run}} {{duration .Duration}}{{end}}"), 0644)
+	c, err := NewClient(config.GitHubConfig{PRBodyTemplate: path})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	body := c.buildPRBody(&sqs.Message{IssueNumber: 7}, PRDetails{
+		Model: "m",
+		Steps: []StepResult{{Run: "go test ./...", Passed: true, Duration: 1500 * time.Millisecond}},
+	})
+	if body != "Fixes #7 with m\ngo test ./... 1.5s" {
+		t.Errorf("unexpected body: %q", body)
+	}
+
+	// A template that fails at run time falls back to the default
+	os.WriteFile(path, []byte("{{.Result.Missing}}"), 0644)
+	c, _ = NewClient(config.GitHubConfig{PRBodyTemplate: path})
+	if body := c.buildPRBody(&sqs.Message{IssueNumber: 7}, PRDetails{}); !strings.Contains(body, "Closes #7") {
+		t.Errorf("expected default body, got %q", body)
+	}
+}
+
+func TestNewClient_InvalidPRBodyTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pr.md.tmpl")
+	os.WriteFile(path, []byte("{{if}}"), 0644)
+	if _, err := NewClient(config.GitHubConfig{PRBodyTemplate: path}); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+	if _, err := NewClient(config.GitHubConfig{PRBodyTemplate: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestBuildPRBody_NoVerification(t *testing.T) {
+	c := &Client{}
+	body := c.buildPRBody(&sqs.Message{IssueNumber: 1}, PRDetails{Model: "m", Models: []string{"m"}, Profile: config.ProfileNone})
+
+	if !strings.Contains(body, "検証なし") || !strings.Contains(body, "Closes #1") {
+		t.Errorf("expected no-verification note:\n%s", body)
+	}
+	if strings.Contains(body, "モデルフォールバック") {
+		t.Errorf("single model should not show a fallback chain:\n%s", body)
+	}
+}
+
+func TestBuildFollowUpBody(t *testing.T) {
+	c := &Client{}
+	msg := &sqs.Message{Type: sqs.TypePRComment, PRNumber: 5, BranchName: "auto-code/issue-42", CommentAuthor: "reviewer"}
+	body := c.buildFollowUpBody(msg, PRDetails{
+		Agent:   "aider",
+		Model:   "m",
+		Models:  []string{"m"},
+		Profile: config.ProfileGo,
+		Steps:   []StepResult{{Pass: PassFollowUp, Stage: "build", Run: "go build ./...", Passed: true, Duration: time.Second}},
+	})
+
+	for _, want := range []string{
+		"レビュー指摘に対応しました",
+		"`auto-code/issue-42`",
+		"**依頼者**: @reviewer",
+		"**生成モデル**: m (via aider)",
+		"- ✅ レビュー対応 build: `go build ./...` (1s)",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("follow-up body missing %q:\n%s", want, body)
+		}
+	}
+}
+
+// newPushFixture creates a bare origin with one commit, a clone of it on the
+// task branch and a client whose fake REST API reports the origin's branches
+func newPushFixture(t *testing.T, policy string) (c *Client, origin, workDir string) {
+	t.Helper()
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	root := t.TempDir()
+	origin = filepath.Join(root, "origin.git")
+	seed := filepath.Join(root, "seed")
+	workDir = filepath.Join(root, "work")
+
+	run(t, root, "init", "-q", "--bare", "-b", "main", origin)
+	run(t, root, "clone", "-q", origin, seed)
+	os.WriteFile(filepath.Join(seed, "main.go"), []byte("package main\n"), 0644)
+	run(t, seed, "add", "-A")
+	run(t, seed, "commit", "-q", "-m", "init")
+	run(t, seed, "push", "-q", "origin", "HEAD:main")
+
+	run(t, root, "clone", "-q", origin, workDir)
+	run(t, workDir, "checkout", "-q", "-b", "auto-code/issue-42")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user" {
+			w.Write([]byte(`{"login": "octocat", "id": 583231}`))
+			return
+		}
+		branch, ok := strings.CutPrefix(r.URL.Path, "/repos/owner/repo/branches/")
+		if !ok {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		cmd := exec.Command("git", "rev-parse", "--verify", "-q", "refs/heads/"+branch)
+		cmd.Dir = origin
+		sha, err := cmd.Output()
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Branch not found"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"name": %q, "commit": {"sha": %q}}`, branch, strings.TrimSpace(string(sha)))
+	}))
+	t.Cleanup(srv.Close)
+
+	c = &Client{
+		config:     config.GitHubConfig{APIBaseURL: srv.URL, BranchPolicy: policy},
+		httpClient: srv.Client(),
+		tokens:     staticToken("test-token"),
+	}
+	return c, origin, workDir
+}
+
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v: %s", args, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestPush_CommitsWorkingTreeChanges(t *testing.T) {
+	c, origin, workDir := newPushFixture(t, BranchPolicyUpdate)
+	msg := &sqs.Message{IssueNumber: 42, Title: "Add feature", Repository: "owner/repo"}
+
+	// Uncommitted edits as left by the direct agent, plus Aider's history file
+	os.WriteFile(filepath.Join(workDir, "feature.go"), []byte("package main\n"), 0644)
+	os.WriteFile(filepath.Join(workDir, ".aider.chat.history.md"), []byte("chat"), 0644)
+
+	branch, err := c.Push(context.Background(), workDir, run(t, workDir, "rev-parse", "main"), msg)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if branch != "auto-code/issue-42" {
+		t.Errorf("branch = %s", branch)
+	}
+
+	files := strings.Fields(run(t, origin, "ls-tree", "-r", "--name-only", branch))
+	if strings.Join(files, ",") != "feature.go,main.go" {
+		t.Errorf("pushed files = %v", files)
+	}
+	// Committed as the token's user, not the local git identity
+	want := "octocat <583231+octocat@users.noreply.github.com>"
+	if got := run(t, origin, "log", "-1", "--format=%an <%ae>|%cn <%ce>", branch); got != want+"|"+want {
+		t.Errorf("commit identity = %s, want %s", got, want)
+	}
+
+	// Pushing again (a retried stage) succeeds without a new commit
+	if _, err := c.Push(context.Background(), workDir, run(t, workDir, "rev-parse", "main"), msg); err != nil {
+		t.Errorf("second Push failed: %v", err)
+	}
+}
+
+func TestPush_NoChanges(t *testing.T) {
+	c, _, workDir := newPushFixture(t, BranchPolicyUpdate)
+	msg := &sqs.Message{IssueNumber: 42, Title: "Add feature", Repository: "owner/repo"}
+
+	_, err := c.Push(context.Background(), workDir, run(t, workDir, "rev-parse", "main"), msg)
+	var permanent *retry.PermanentError
+	if !errors.As(err, &permanent) {
+		t.Errorf("expected PermanentError, got %v", err)
+	}
+}
+
+func TestPush_ExistingBranch(t *testing.T) {
+	tests := []struct {
+		policy     string
+		wantBranch string
+	}{
+		{policy: BranchPolicyUpdate, wantBranch: "auto-code/issue-42"},
+		{policy: BranchPolicySuffix, wantBranch: "auto-code/issue-42-3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			c, origin, workDir := newPushFixture(t, tt.policy)
+			msg := &sqs.Message{IssueNumber: 42, Title: "Add feature", Repository: "owner/repo"}
+
+			// Branches left by earlier runs, unrelated to this clone's commits
+			stale := run(t, origin, "commit-tree", "main^{tree}", "-p", "main", "-m", "earlier run")
+			run(t, origin, "branch", "auto-code/issue-42", stale)
+			run(t, origin, "branch", "auto-code/issue-42-2", stale)
+
+			os.WriteFile(filepath.Join(workDir, "feature.go"), []byte("package main\n"), 0644)
+			branch, err := c.Push(context.Background(), workDir, run(t, workDir, "rev-parse", "main"), msg)
+			if err != nil {
+				t.Fatalf("Push failed: %v", err)
+			}
+			if branch != tt.wantBranch {
+				t.Errorf("branch = %s, want %s", branch, tt.wantBranch)
+			}
+
+			head := run(t, workDir, "rev-parse", "HEAD")
+			if got := run(t, origin, "rev-parse", tt.wantBranch); got != head {
+				t.Errorf("%s = %s, want %s", tt.wantBranch, got, head)
+			}
+			if tt.policy == BranchPolicySuffix {
+				if got := run(t, origin, "rev-parse", "auto-code/issue-42"); got != stale {
+					t.Error("suffix policy must not touch the existing branch")
+				}
+			}
+		})
+	}
+}
+
+func TestPush_FollowUpFastForward(t *testing.T) {
+	// Even the suffix policy keeps pushing to the PR's branch
+	c, origin, workDir := newPushFixture(t, BranchPolicySuffix)
+	msg := &sqs.Message{Type: sqs.TypePRComment, IssueNumber: 42, PRNumber: 5, Repository: "owner/repo"}
+
+	os.WriteFile(filepath.Join(workDir, "feature.go"), []byte("package main\n"), 0644)
+	run(t, workDir, "add", "-A")
+	run(t, workDir, "commit", "-q", "-m", "Add feature (#42)")
+	run(t, workDir, "push", "-q", "origin", "auto-code/issue-42")
+	base := run(t, workDir, "rev-parse", "HEAD")
+
+	os.WriteFile(filepath.Join(workDir, "feature.go"), []byte("package main\n\nfunc calcTotal() {}\n"), 0644)
+	branch, err := c.Push(context.Background(), workDir, base, msg)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if branch != "auto-code/issue-42" {
+		t.Errorf("branch = %s, want auto-code/issue-42", branch)
+	}
+	if got := run(t, origin, "log", "-1", "--format=%s", branch); got != "Address review comments (#5)" {
+		t.Errorf("commit message = %q", got)
+	}
+	if got := run(t, origin, "rev-list", "--count", base+".."+branch); got != "1" {
+		t.Errorf("expected one follow-up commit, got %s", got)
+	}
+}
+
+func TestCreatePR_UpdatesExistingPullRequest(t *testing.T) {
+	var patched map[string]string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo":
+			w.Write([]byte(`{"full_name": "owner/repo", "default_branch": "main"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/pulls":
+			if r.URL.Query().Get("head") != "owner:auto-code/issue-42" || r.URL.Query().Get("state") != "open" {
+				t.Errorf("unexpected query: %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`[{"number": 5, "html_url": "https://github.com/owner/repo/pull/5", "state": "open"}]`))
+		case r.Method == http.MethodPatch && r.URL.Path == "/repos/owner/repo/pulls/5":
+			json.NewDecoder(r.Body).Decode(&patched)
+			w.Write([]byte(`{"number": 5, "html_url": "https://github.com/owner/repo/pull/5", "state": "open"}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	msg := &sqs.Message{IssueNumber: 42, Title: "Add feature", Repository: "owner/repo"}
+
+	url, err := c.CreatePR(context.Background(), "auto-code/issue-42", msg, PRDetails{Agent: "aider", Model: "m", Profile: "go"})
+	if err != nil {
+		t.Fatalf("CreatePR failed: %v", err)
+	}
+	if url != "https://github.com/owner/repo/pull/5" {
+		t.Errorf("url = %s", url)
+	}
+	if patched["title"] != "[auto-code] Add feature" || !strings.Contains(patched["body"], "#42") {
+		t.Errorf("unexpected update: %v", patched)
+	}
+}
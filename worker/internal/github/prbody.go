@@ -0,0 +1,193 @@
+package github
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// Pass names in PRDetails
+const (
+	PassImplementation = "implementation" // Pass 1
+	PassTests          = "tests"          // Pass 2
+	PassFollowUp       = "follow-up"      // Single pass on review feedback
+)
+
+// PRDetails is the result of a task: how the change was generated and
+// verified, for the PR body
+type PRDetails struct {
+	Agent       string       // Coding agent backend
+	Model       string       // Model that produced the final code
+	Models      []string     // Every model tried, in order
+	Profile     string       // Verification profile
+	Invocations int          // Agent runs across every model tried
+	Passes      []PassStats  // Agent runs and fix attempts per pass
+	Steps       []StepResult // Verification commands run, in order
+	Files       []FileStat   // Files changed by the task
+}
+
+// PassStats is what the agent did in one pass
+type PassStats struct {
+	Pass        string // See the Pass constants
+	Invocations int    // Agent runs, including fix runs
+	FixAttempts int    // Runs asking the agent to fix a verification failure
+}
+
+// StepResult is one run of a verification command
+type StepResult struct {
+	Pass     string
+	Stage    string // build, lint or test
+	Name     string
+	Run      string
+	Passed   bool
+	Duration time.Duration
+}
+
+// FileStat is the line count of one changed file
+type FileStat struct {
+	Path    string
+	Added   int
+	Deleted int
+	Binary  bool // Line counts are not available
+}
+
+// PRBodyData is what a PR body template is executed with
+type PRBodyData struct {
+	Issue       *sqs.Message // The task's issue (number, title, body, labels)
+	Result      PRDetails
+	GeneratedAt time.Time
+}
+
+// defaultPRBody is used unless github.pr_body_template names another one
+const defaultPRBody = `## 自動生成されたコード
+
+このPRは CodingWorker によって自動生成されました。
+
+Closes #{{.Issue.IssueNumber}}
+
+**生成モデル**: {{.Result.Model}} (via {{.Result.Agent}})
+{{- if gt (len .Result.Models) 1}}
+**モデルフォールバック**: {{join .Result.Models " → "}}
+{{- end}}
+**生成日時**: {{.GeneratedAt.Format "2006-01-02 15:04:05"}}
+**検証プロファイル**: {{.Result.Profile}}
+**エージェント実行回数**: {{.Result.Invocations}}
+
+### タスク内容
+{{.Issue.Body}}
+
+### 実行内容
+| パス | エージェント実行 | うち修正 |
+|:---|---:|---:|
+{{- range .Result.Passes}}
+| {{passLabel .Pass}} | {{.Invocations}} | {{.FixAttempts}} |
+{{- end}}
+
+### 自動検証結果
+{{steps .Result.Steps}}
+### 変更ファイル
+{{files .Result.Files}}
+### 確認事項
+- [ ] コードが期待通りに動作するか
+- [ ] テストカバレッジが十分か
+`
+
+var prBodyFuncs = template.FuncMap{
+	"join":      strings.Join,
+	"passLabel": passLabel,
+	"duration":  formatDuration,
+	"steps":     formatSteps,
+	"files":     formatFiles,
+}
+
+var defaultPRBodyTemplate = template.Must(template.New("pr_body").Funcs(prBodyFuncs).Parse(defaultPRBody))
+
+// loadPRBodyTemplate parses a PR body template file
+func loadPRBodyTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PR body template: %w", err)
+	}
+	tmpl, err := template.New("pr_body").Funcs(prBodyFuncs).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PR body template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// buildPRBody creates the PR description from the task result. A custom
+// template that fails to execute falls back to the default one.
+func (c *Client) buildPRBody(msg *sqs.Message, details PRDetails) string {
+	data := PRBodyData{Issue: msg, Result: details, GeneratedAt: time.Now()}
+	if c.prBody != nil {
+		var b strings.Builder
+		err := c.prBody.Execute(&b, data)
+		if err == nil {
+			return b.String()
+		}
+		slog.Error("Failed to execute PR body template, using the default", "error", err)
+	}
+
+	var b strings.Builder
+	defaultPRBodyTemplate.Execute(&b, data)
+	return b.String()
+}
+
+// passLabel names a pass for humans
+func passLabel(pass string) string {
+	switch pass {
+	case PassImplementation:
+		return "Pass 1 (実装)"
+	case PassTests:
+		return "Pass 2 (テスト作成)"
+	case PassFollowUp:
+		return "レビュー対応"
+	default:
+		return pass
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// formatSteps lists the verification commands that ran with their outcome
+func formatSteps(steps []StepResult) string {
+	if len(steps) == 0 {
+		return "- 検証なし（プロジェクト種別を検出できませんでした）\n"
+	}
+	var b strings.Builder
+	for _, step := range steps {
+		mark := "✅"
+		if !step.Passed {
+			mark = "❌"
+		}
+		fmt.Fprintf(&b, "- %s %s %s: `%s` (%s)\n", mark, passLabel(step.Pass), step.Stage, step.Run, formatDuration(step.Duration))
+	}
+	return b.String()
+}
+
+// formatFiles summarizes the changed files with their line counts
+func formatFiles(files []FileStat) string {
+	if len(files) == 0 {
+		return "- なし\n"
+	}
+	var b strings.Builder
+	added, deleted := 0, 0
+	for _, f := range files {
+		if f.Binary {
+			fmt.Fprintf(&b, "- `%s` (バイナリ)\n", f.Path)
+			continue
+		}
+		fmt.Fprintf(&b, "- `%s` (+%d -%d)\n", f.Path, f.Added, f.Deleted)
+		added += f.Added
+		deleted += f.Deleted
+	}
+	fmt.Fprintf(&b, "\n%d ファイル, +%d -%d\n", len(files), added, deleted)
+	return b.String()
+}
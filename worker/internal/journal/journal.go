@@ -0,0 +1,222 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job statuses
+const (
+	StatusRunning   = "running"   // In progress, or interrupted if found at startup
+	StatusDone      = "done"      // PR created
+	StatusFailed    = "failed"    // Gave up after retries
+	StatusAbandoned = "abandoned" // Interrupted and could not be resumed
+	StatusCancelled = "cancelled" // Aborted by /codingworker cancel
+)
+
+// Job is the latest recorded state of a task
+type Job struct {
+	Key         string          `json:"key"` // See Key
+	Repository  string          `json:"repository"`
+	IssueNumber int             `json:"issue_number"`
+	CreatedAt   string          `json:"created_at"` // Message created_at; redeliveries of a message share it
+	Status      string          `json:"status"`
+	Stage       string          `json:"stage,omitempty"` // Last completed stage
+	Attempts    map[string]int  `json:"attempts,omitempty"`
+	WorkDir     string          `json:"work_dir,omitempty"`
+	Base        string          `json:"base,omitempty"` // Commit the generation starts from
+	Branch      string          `json:"branch,omitempty"`
+	Model       string          `json:"model,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"` // Generation result, for resuming
+	PRURL       string          `json:"pr_url,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// Finished reports whether the job reached a final status
+func (j Job) Finished() bool {
+	return j.Status != StatusRunning
+}
+
+// Key identifies the job for an issue; one issue has at most one job at a time
+func Key(repository string, issueNumber int) string {
+	return fmt.Sprintf("%s#%d", repository, issueNumber)
+}
+
+// FollowUpKey identifies the job for one follow-up request (a comment or
+// review) on a pull request
+func FollowUpKey(repository string, prNumber int, triggerID int64) string {
+	return fmt.Sprintf("%s#%d/%d", repository, prNumber, triggerID)
+}
+
+// Journal is an append-only log of job state changes in a local file. Each
+// line is a full Job snapshot, so replaying the file yields the latest state.
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+	jobs map[string]Job
+	now  func() time.Time
+}
+
+// Open replays the journal at path, creating it if needed. Finished jobs
+// older than retention are dropped and the file is compacted.
+func Open(path string, retention time.Duration) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	j := &Journal{jobs: make(map[string]Job), now: time.Now}
+	if err := j.replay(path); err != nil {
+		return nil, err
+	}
+	for key, job := range j.jobs {
+		if job.Finished() && j.now().Sub(job.UpdatedAt) > retention {
+			delete(j.jobs, key)
+		}
+	}
+	if err := j.compact(path); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	j.file = file
+	return j, nil
+}
+
+// replay loads the latest snapshot of every job. A line that fails to parse
+// (e.g. cut short by a crash) is skipped.
+func (j *Journal) replay(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var job Job
+		if err := json.Unmarshal(scanner.Bytes(), &job); err != nil || job.Key == "" {
+			slog.Warn("Skipping unreadable journal entry", "path", path, "line", line)
+			continue
+		}
+		j.jobs[job.Key] = job
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the journal with one line per job
+func (j *Journal) compact(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	for _, job := range j.sorted() {
+		data, err := json.Marshal(job)
+		if err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("failed to marshal job: %w", err)
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	// Rename so a crash never leaves a partially written journal
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	return nil
+}
+
+// Record appends the job's new state and syncs it to disk
+func (j *Journal) Record(job Job) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job.UpdatedAt = j.now()
+	job.Attempts = maps.Clone(job.Attempts)
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	j.jobs[job.Key] = job
+	return nil
+}
+
+// Get returns the latest state of a job
+func (j *Journal) Get(key string) (Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[key]
+	return job, ok
+}
+
+// Interrupted returns jobs still marked running. At startup these are jobs
+// the previous process did not finish.
+func (j *Journal) Interrupted() []Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var jobs []Job
+	for _, job := range j.sorted() {
+		if !job.Finished() {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// Jobs returns every job in the journal
+func (j *Journal) Jobs() []Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.sorted()
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+func (j *Journal) sorted() []Job {
+	jobs := make([]Job, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].Key < jobs[b].Key })
+	return jobs
+}
@@ -0,0 +1,121 @@
+package journal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "journal.jsonl")
+
+	j, err := Open(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := Key("owner/repo", 42)
+	job := Job{Key: key, Repository: "owner/repo", IssueNumber: 42, Status: StatusRunning, Stage: "clone",
+		Attempts: map[string]int{"clone": 1}, WorkDir: "/tmp/issue-42-1"}
+	if err := j.Record(job); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	job.Stage = "generate"
+	job.Attempts["generate"] = 2
+	job.Model = "ollama_chat/qwen2.5-coder:7b"
+	job.Result = json.RawMessage(`{"Model":"ollama_chat/qwen2.5-coder:7b"}`)
+	if err := j.Record(job); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if err := j.Record(Job{Key: Key("owner/repo", 7), Status: StatusDone, PRURL: "https://github.com/owner/repo/pull/8"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	j.Close()
+
+	// Reopening yields the latest state of each job
+	j, err = Open(path, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer j.Close()
+
+	got, ok := j.Get(key)
+	if !ok {
+		t.Fatal("job not found after replay")
+	}
+	if got.Stage != "generate" || got.Model != "ollama_chat/qwen2.5-coder:7b" || got.Attempts["generate"] != 2 {
+		t.Errorf("unexpected job: %+v", got)
+	}
+	if string(got.Result) != `{"Model":"ollama_chat/qwen2.5-coder:7b"}` {
+		t.Errorf("unexpected result: %s", got.Result)
+	}
+
+	interrupted := j.Interrupted()
+	if len(interrupted) != 1 || interrupted[0].Key != key {
+		t.Errorf("Interrupted() = %+v, want only %s", interrupted, key)
+	}
+	if jobs := j.Jobs(); len(jobs) != 2 {
+		t.Errorf("expected 2 jobs, got %+v", jobs)
+	}
+
+	// Compaction left one line per job
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read journal: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("expected 2 lines after compaction, got %d", lines)
+	}
+}
+
+func TestOpen_DropsExpiredFinishedJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	old := time.Now().Add(-48 * time.Hour)
+
+	var lines []string
+	for _, job := range []Job{
+		{Key: "owner/repo#1", Status: StatusDone, UpdatedAt: old},
+		{Key: "owner/repo#2", Status: StatusFailed, UpdatedAt: time.Now()},
+		{Key: "owner/repo#3", Status: StatusRunning, UpdatedAt: old}, // Left for recovery
+	} {
+		data, _ := json.Marshal(job)
+		lines = append(lines, string(data))
+	}
+	os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+
+	j, err := Open(path, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer j.Close()
+
+	if _, ok := j.Get("owner/repo#1"); ok {
+		t.Error("expired finished job should be dropped")
+	}
+	if _, ok := j.Get("owner/repo#2"); !ok {
+		t.Error("recent finished job should be kept")
+	}
+	if _, ok := j.Get("owner/repo#3"); !ok {
+		t.Error("interrupted job should be kept")
+	}
+}
+
+func TestOpen_SkipsTruncatedEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	good, _ := json.Marshal(Job{Key: "owner/repo#1", Status: StatusRunning, Stage: "push", UpdatedAt: time.Now()})
+	// A crash mid-write leaves a partial last line
+	os.WriteFile(path, []byte(string(good)+"\n"+`{"key":"owner/repo#1","status":"do`), 0644)
+
+	j, err := Open(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer j.Close()
+
+	job, ok := j.Get("owner/repo#1")
+	if !ok || job.Stage != "push" {
+		t.Errorf("expected the last complete entry, got %+v", job)
+	}
+}
@@ -0,0 +1,222 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+// requestTimeout bounds health and model list requests (pulls use PullTimeout)
+const requestTimeout = 10 * time.Second
+
+// modelPrefixes are the Aider/LiteLLM prefixes of models served by Ollama
+var modelPrefixes = []string{"ollama_chat/", "ollama/"}
+
+// ErrUnavailable is returned when the Ollama server cannot be reached
+var ErrUnavailable = errors.New("ollama is unavailable")
+
+// MissingModelsError lists configured models that Ollama does not have
+type MissingModelsError struct {
+	Models []string
+}
+
+func (e *MissingModelsError) Error() string {
+	return fmt.Sprintf("ollama models not found: %s", strings.Join(e.Models, ", "))
+}
+
+// Client talks to the Ollama HTTP API to check health and manage models
+type Client struct {
+	config     config.OllamaConfig
+	models     []string // Ollama model names required by the worker
+	httpClient *http.Client
+}
+
+// NewClient creates a client that checks the given Ollama model names
+func NewClient(cfg config.OllamaConfig, models []string) *Client {
+	return &Client{
+		config:     cfg,
+		models:     models,
+		httpClient: &http.Client{},
+	}
+}
+
+// Models returns the Ollama model names among the configured models.
+// Models for other providers are skipped.
+func Models(models []config.ModelConfig) []string {
+	var names []string
+	for _, m := range models {
+		for _, prefix := range modelPrefixes {
+			if strings.HasPrefix(m.Name, prefix) {
+				names = append(names, strings.TrimPrefix(m.Name, prefix))
+				break
+			}
+		}
+	}
+	return names
+}
+
+// Ready checks that Ollama is reachable and has every required model,
+// pulling missing ones when auto_pull is enabled
+func (c *Client) Ready(ctx context.Context) error {
+	if _, err := c.Version(ctx); err != nil {
+		return err
+	}
+	return c.EnsureModels(ctx)
+}
+
+// Version returns the Ollama server version
+func (c *Client) Version(ctx context.Context) (string, error) {
+	var resp struct {
+		Version string `json:"version"`
+	}
+	if err := c.get(ctx, "/api/version", &resp); err != nil {
+		return "", err
+	}
+	return resp.Version, nil
+}
+
+// ListModels returns the names of the locally available models
+func (c *Client) ListModels(ctx context.Context) ([]string, error) {
+	var resp struct {
+		Models []struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		} `json:"models"`
+	}
+	if err := c.get(ctx, "/api/tags", &resp); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, m := range resp.Models {
+		names = append(names, m.Name)
+		if m.Model != "" && m.Model != m.Name {
+			names = append(names, m.Model)
+		}
+	}
+	return names, nil
+}
+
+// EnsureModels verifies every required model is present. Missing models are
+// pulled when auto_pull is enabled, otherwise a MissingModelsError is returned.
+func (c *Client) EnsureModels(ctx context.Context) error {
+	available, err := c.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for _, name := range available {
+		have[normalize(name)] = true
+	}
+
+	var missing []string
+	for _, model := range c.models {
+		if !have[normalize(model)] {
+			missing = append(missing, model)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if !c.config.AutoPull {
+		return &MissingModelsError{Models: missing}
+	}
+
+	for _, model := range missing {
+		if err := c.Pull(ctx, model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pull downloads a model, waiting until it completes
+func (c *Client) Pull(ctx context.Context, model string) error {
+	slog.Info("Pulling Ollama model", "model", model)
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.config.PullTimeout)*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]any{"model": model, "stream": false})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := c.send(ctx, http.MethodPost, "/api/pull", bytes.NewReader(body), &resp); err != nil {
+		return fmt.Errorf("pull %s failed: %w", model, err)
+	}
+	if resp.Status != "success" {
+		return fmt.Errorf("pull %s failed: status %q", model, resp.Status)
+	}
+
+	slog.Info("Ollama model pulled", "model", model, "duration", time.Since(start))
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, path string, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return c.send(ctx, http.MethodGet, path, nil, out)
+}
+
+// send performs a request, decoding the JSON response into out. Connection
+// failures are reported as ErrUnavailable.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.config.BaseURL, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read response: %v", ErrUnavailable, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		msg := strings.TrimSpace(string(respBody))
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error != "" {
+			msg = apiErr.Error
+		}
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%w: %d: %s", ErrUnavailable, resp.StatusCode, msg)
+		}
+		return fmt.Errorf("ollama api: %d: %s", resp.StatusCode, msg)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// normalize adds the implicit ":latest" tag
+func normalize(model string) string {
+	if !strings.Contains(model, ":") {
+		return model + ":latest"
+	}
+	return model
+}
@@ -0,0 +1,164 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+// fakeOllama serves /api/version, /api/tags and /api/pull from an in-memory model list
+type fakeOllama struct {
+	*httptest.Server
+	mu     sync.Mutex
+	models []string
+	pulled []string
+}
+
+func newFakeOllama(t *testing.T, models ...string) *fakeOllama {
+	f := &fakeOllama{models: models}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/version", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"version": "0.5.7"})
+	})
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var list []map[string]string
+		for _, m := range f.models {
+			list = append(list, map[string]string{"name": m, "model": m})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"models": list})
+	})
+	mux.HandleFunc("POST /api/pull", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model  string `json:"model"`
+			Stream bool   `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "does-not-exist:1b" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "pull model manifest: file does not exist"})
+			return
+		}
+		f.mu.Lock()
+		f.models = append(f.models, req.Model)
+		f.pulled = append(f.pulled, req.Model)
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newTestClient(baseURL string, autoPull bool, models ...string) *Client {
+	return NewClient(config.OllamaConfig{
+		BaseURL:     baseURL,
+		AutoPull:    autoPull,
+		PullTimeout: 10,
+	}, models)
+}
+
+func TestModels(t *testing.T) {
+	models := []config.ModelConfig{
+		{Name: "ollama_chat/qwen2.5-coder:1.5b"},
+		{Name: "ollama/llama3"},
+		{Name: "openai/gpt-4o-mini"},
+		{Name: "claude-3-haiku"},
+	}
+
+	got := Models(models)
+	expected := []string{"qwen2.5-coder:1.5b", "llama3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Models() = %v, want %v", got, expected)
+	}
+}
+
+func TestReady(t *testing.T) {
+	server := newFakeOllama(t, "qwen2.5-coder:1.5b", "llama3:latest")
+	c := newTestClient(server.URL, false, "qwen2.5-coder:1.5b", "llama3")
+
+	if err := c.Ready(context.Background()); err != nil {
+		t.Errorf("Ready failed: %v", err)
+	}
+}
+
+func TestReady_MissingModel(t *testing.T) {
+	server := newFakeOllama(t, "qwen2.5-coder:1.5b")
+	c := newTestClient(server.URL, false, "qwen2.5-coder:1.5b", "qwen2.5-coder:7b")
+
+	err := c.Ready(context.Background())
+	var missing *MissingModelsError
+	if !errors.As(err, &missing) {
+		t.Fatalf("expected MissingModelsError, got %v", err)
+	}
+	if !reflect.DeepEqual(missing.Models, []string{"qwen2.5-coder:7b"}) {
+		t.Errorf("unexpected missing models: %v", missing.Models)
+	}
+	if len(server.pulled) != 0 {
+		t.Errorf("should not pull without auto_pull: %v", server.pulled)
+	}
+}
+
+func TestReady_AutoPull(t *testing.T) {
+	server := newFakeOllama(t)
+	c := newTestClient(server.URL, true, "qwen2.5-coder:1.5b")
+
+	if err := c.Ready(context.Background()); err != nil {
+		t.Fatalf("Ready failed: %v", err)
+	}
+	if !reflect.DeepEqual(server.pulled, []string{"qwen2.5-coder:1.5b"}) {
+		t.Errorf("unexpected pulls: %v", server.pulled)
+	}
+
+	// Already present now: no second pull
+	if err := c.Ready(context.Background()); err != nil {
+		t.Fatalf("Ready failed: %v", err)
+	}
+	if len(server.pulled) != 1 {
+		t.Errorf("model pulled again: %v", server.pulled)
+	}
+}
+
+func TestReady_PullFailure(t *testing.T) {
+	server := newFakeOllama(t)
+	c := newTestClient(server.URL, true, "does-not-exist:1b")
+
+	err := c.Ready(context.Background())
+	if err == nil {
+		t.Fatal("expected pull error")
+	}
+	if errors.Is(err, ErrUnavailable) {
+		t.Errorf("unknown model should not look like an outage: %v", err)
+	}
+}
+
+func TestReady_Unavailable(t *testing.T) {
+	server := newFakeOllama(t)
+	url := server.URL
+	server.Close()
+
+	c := newTestClient(url, false, "qwen2.5-coder:1.5b")
+	if err := c.Ready(context.Background()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable, got %v", err)
+	}
+}
+
+func TestReady_ServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"loading"}`, http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := newTestClient(server.URL, false, "qwen2.5-coder:1.5b")
+	if err := c.Ready(context.Background()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable for 503, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// Directory layout of the file queue:
+//
+//	<dir>/pending/   messages waiting to be received
+//	<dir>/inflight/  received messages; the file mtime holds the lease deadline
+//	<dir>/failed/    message files that could not be parsed
+//
+// A message is claimed by setting its lease deadline and renaming it from
+// pending to inflight, so several workers can share one directory on the
+// same filesystem.
+const (
+	filePending  = "pending"
+	fileInflight = "inflight"
+	fileFailed   = "failed"
+)
+
+// File is a durable queue backed by JSON files in a local directory
+type File struct {
+	dir          string
+	waitTime     time.Duration
+	lease        time.Duration // 0 disables lease expiry
+	pollInterval time.Duration
+}
+
+// NewFile creates a file queue rooted at dir, creating the directories if needed
+func NewFile(dir string, waitTime, lease time.Duration) (*File, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("queue.dir is required for the file backend")
+	}
+	for _, sub := range []string{filePending, fileInflight, fileFailed} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create queue directory: %w", err)
+		}
+	}
+	return &File{
+		dir:          dir,
+		waitTime:     waitTime,
+		lease:        lease,
+		pollInterval: 500 * time.Millisecond,
+	}, nil
+}
+
+// Inject writes a message into the pending directory
+func (f *File) Inject(msg *sqs.Message) error {
+	if msg.CreatedAt == "" {
+		msg.CreatedAt = time.Now().Format(time.RFC3339)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	name := fmt.Sprintf("%d-issue-%d.json", time.Now().UnixNano(), msg.IssueNumber)
+	tmp := filepath.Join(f.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	// Rename so receivers never see a partially written file
+	if err := os.Rename(tmp, filepath.Join(f.dir, filePending, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to enqueue message: %w", err)
+	}
+
+	slog.Info("File queue: Message enqueued",
+		"issue_number", msg.IssueNumber,
+		"repository", msg.Repository,
+		"file", name,
+	)
+	return nil
+}
+
+// Receive polls the pending directory until a message arrives or the wait time passes
+func (f *File) Receive(ctx context.Context) (*sqs.Message, error) {
+	deadline := time.Now().Add(f.waitTime)
+
+	for {
+		f.requeueExpired()
+
+		msg, err := f.claimNext()
+		if err != nil || msg != nil {
+			return msg, err
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, nil // No message after timeout
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(f.pollInterval, time.Until(deadline))):
+		}
+	}
+}
+
+// Delete removes a processed message
+func (f *File) Delete(ctx context.Context, receiptHandle string) error {
+	if err := os.Remove(f.inflightPath(receiptHandle)); err != nil {
+		return fmt.Errorf("file queue delete failed: %w", err)
+	}
+	slog.Info("File queue: Message deleted", "receipt_handle", receiptHandle)
+	return nil
+}
+
+// ExtendLease moves the lease deadline of an in-flight message
+func (f *File) ExtendLease(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if err := os.Chtimes(f.inflightPath(receiptHandle), deadline, deadline); err != nil {
+		return fmt.Errorf("file queue extend lease failed: %w", err)
+	}
+	return nil
+}
+
+// Nack moves an in-flight message back to pending
+func (f *File) Nack(ctx context.Context, receiptHandle string) error {
+	if err := os.Rename(f.inflightPath(receiptHandle), filepath.Join(f.dir, filePending, receiptHandle)); err != nil {
+		return fmt.Errorf("file queue nack failed: %w", err)
+	}
+	slog.Info("File queue: Message released", "receipt_handle", receiptHandle)
+	return nil
+}
+
+// claimNext moves the oldest pending message to inflight and parses it
+func (f *File) claimNext() (*sqs.Message, error) {
+	names, err := f.list(filePending)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		pending := filepath.Join(f.dir, filePending, name)
+		inflight := f.inflightPath(name)
+
+		// Set the lease deadline before the rename: once the file is in
+		// inflight, another worker's requeueExpired must see the new mtime
+		if f.lease > 0 {
+			deadline := time.Now().Add(f.lease)
+			if err := os.Chtimes(pending, deadline, deadline); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue // Claimed by another worker
+				}
+				return nil, fmt.Errorf("failed to set lease: %w", err)
+			}
+		}
+
+		if err := os.Rename(pending, inflight); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue // Claimed by another worker
+			}
+			return nil, fmt.Errorf("failed to claim message: %w", err)
+		}
+
+		data, err := os.ReadFile(inflight)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+
+		var msg sqs.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			// Park unparsable files so they are not received again
+			os.Rename(inflight, filepath.Join(f.dir, fileFailed, name))
+			return nil, fmt.Errorf("failed to parse message %s: %w", name, err)
+		}
+		msg.ReceiptHandle = name
+
+		slog.Info("File queue: Received message",
+			"issue_number", msg.IssueNumber,
+			"repository", msg.Repository,
+			"file", name,
+		)
+		return &msg, nil
+	}
+
+	return nil, nil
+}
+
+// requeueExpired moves in-flight messages whose lease has passed back to pending
+func (f *File) requeueExpired() {
+	if f.lease == 0 {
+		return
+	}
+
+	names, err := f.list(fileInflight)
+	if err != nil {
+		slog.Warn("File queue: Failed to list in-flight messages", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, name := range names {
+		info, err := os.Stat(f.inflightPath(name))
+		if err != nil || info.ModTime().After(now) {
+			continue
+		}
+		if err := os.Rename(f.inflightPath(name), filepath.Join(f.dir, filePending, name)); err == nil {
+			slog.Warn("File queue: Lease expired, message redelivered", "file", name)
+		}
+	}
+}
+
+// list returns the message file names in a subdirectory, oldest first
+func (f *File) list(sub string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(f.dir, sub))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue directory: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	// File names start with the enqueue time in nanoseconds
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f *File) inflightPath(receiptHandle string) string {
+	return filepath.Join(f.dir, fileInflight, filepath.Base(receiptHandle))
+}
@@ -0,0 +1,242 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+func newTestFileQueue(t *testing.T, lease time.Duration) *File {
+	t.Helper()
+	q, err := NewFile(t.TempDir(), 100*time.Millisecond, lease)
+	if err != nil {
+		t.Fatalf("NewFile failed: %v", err)
+	}
+	q.pollInterval = 10 * time.Millisecond
+	return q
+}
+
+func TestFile_InjectAndReceive(t *testing.T) {
+	q := newTestFileQueue(t, time.Hour)
+
+	for i := 1; i <= 2; i++ {
+		if err := q.Inject(&sqs.Message{IssueNumber: i, Repository: "test/repo", Title: "File test"}); err != nil {
+			t.Fatalf("Inject failed: %v", err)
+		}
+	}
+
+	// Messages are received oldest first
+	for i := 1; i <= 2; i++ {
+		msg, err := q.Receive(context.Background())
+		if err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+		if msg == nil {
+			t.Fatal("expected to receive a message")
+		}
+		if msg.IssueNumber != i {
+			t.Errorf("expected issue %d, got %d", i, msg.IssueNumber)
+		}
+		if msg.ReceiptHandle == "" {
+			t.Error("expected ReceiptHandle to be set")
+		}
+		if msg.CreatedAt == "" {
+			t.Error("expected CreatedAt to be set")
+		}
+	}
+}
+
+func TestFile_Receive_NoMessage(t *testing.T) {
+	q := newTestFileQueue(t, time.Hour)
+
+	msg, err := q.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if msg != nil {
+		t.Error("expected nil when no message available")
+	}
+}
+
+func TestFile_Receive_ContextCancelled(t *testing.T) {
+	q, err := NewFile(t.TempDir(), 10*time.Second, 0)
+	if err != nil {
+		t.Fatalf("NewFile failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	if _, err := q.Receive(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFile_Receive_PicksUpLateMessage(t *testing.T) {
+	q := newTestFileQueue(t, time.Hour)
+	q.waitTime = time.Second
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		q.Inject(&sqs.Message{IssueNumber: 3, Repository: "test/repo", Title: "Late"})
+	}()
+
+	msg, err := q.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if msg == nil || msg.IssueNumber != 3 {
+		t.Errorf("expected issue 3 during long polling, got %+v", msg)
+	}
+}
+
+func TestFile_Delete(t *testing.T) {
+	q := newTestFileQueue(t, time.Hour)
+	q.Inject(&sqs.Message{IssueNumber: 1, Repository: "test/repo", Title: "Delete"})
+
+	msg, _ := q.Receive(context.Background())
+	if err := q.Delete(context.Background(), msg.ReceiptHandle); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(q.dir, fileInflight))
+	if len(entries) != 0 {
+		t.Errorf("expected no in-flight files, got %d", len(entries))
+	}
+}
+
+func TestFile_LeaseExpiryAndExtend(t *testing.T) {
+	q := newTestFileQueue(t, 50*time.Millisecond)
+	q.Inject(&sqs.Message{IssueNumber: 2, Repository: "test/repo", Title: "Lease"})
+
+	msg, _ := q.Receive(context.Background())
+	if msg == nil {
+		t.Fatal("expected to receive a message")
+	}
+
+	// An extended lease keeps the message hidden
+	if err := q.ExtendLease(context.Background(), msg.ReceiptHandle, time.Hour); err != nil {
+		t.Fatalf("ExtendLease failed: %v", err)
+	}
+	time.Sleep(70 * time.Millisecond)
+	if again, _ := q.Receive(context.Background()); again != nil {
+		t.Fatal("message redelivered while lease was extended")
+	}
+
+	// An expired lease makes it visible again
+	if err := q.ExtendLease(context.Background(), msg.ReceiptHandle, 0); err != nil {
+		t.Fatalf("ExtendLease failed: %v", err)
+	}
+	again, err := q.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if again == nil || again.IssueNumber != 2 {
+		t.Errorf("expected issue 2 to be redelivered, got %+v", again)
+	}
+}
+
+func TestFile_Nack(t *testing.T) {
+	q := newTestFileQueue(t, time.Hour)
+	q.Inject(&sqs.Message{IssueNumber: 4, Repository: "test/repo", Title: "Nack"})
+
+	msg, _ := q.Receive(context.Background())
+	if err := q.Nack(context.Background(), msg.ReceiptHandle); err != nil {
+		t.Fatalf("Nack failed: %v", err)
+	}
+
+	again, err := q.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if again == nil || again.IssueNumber != 4 {
+		t.Errorf("expected issue 4 after nack, got %+v", again)
+	}
+}
+
+func TestFile_InvalidMessageIsParked(t *testing.T) {
+	q := newTestFileQueue(t, time.Hour)
+	os.WriteFile(filepath.Join(q.dir, filePending, "1-issue-0.json"), []byte("not json"), 0644)
+
+	if _, err := q.Receive(context.Background()); err == nil {
+		t.Error("expected error for invalid message file")
+	}
+	if _, err := os.Stat(filepath.Join(q.dir, fileFailed, "1-issue-0.json")); err != nil {
+		t.Errorf("expected invalid file to be moved to failed/: %v", err)
+	}
+}
+
+func TestFile_Durable(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := NewFile(dir, 0, time.Hour)
+	first.Inject(&sqs.Message{IssueNumber: 9, Repository: "test/repo", Title: "Survives restart"})
+
+	// A new queue instance on the same directory sees the message
+	second, _ := NewFile(dir, 0, time.Hour)
+	msg, err := second.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if msg == nil || msg.IssueNumber != 9 {
+		t.Errorf("expected issue 9, got %+v", msg)
+	}
+}
+
+func TestFile_SharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	const messages = 300
+	queues := make([]*File, 2)
+	for i := range queues {
+		q, err := NewFile(dir, 0, time.Hour)
+		if err != nil {
+			t.Fatalf("NewFile failed: %v", err)
+		}
+		queues[i] = q
+	}
+	for i := 1; i <= messages; i++ {
+		queues[0].Inject(&sqs.Message{IssueNumber: i, Repository: "test/repo", Title: "Shared"})
+	}
+
+	// Both workers receive and requeue expired leases concurrently; each
+	// message must be claimed by exactly one of them
+	var mu sync.Mutex
+	claims := make(map[int]int)
+	var wg sync.WaitGroup
+	for _, q := range queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				msg, err := q.Receive(context.Background())
+				if err != nil {
+					t.Errorf("Receive failed: %v", err)
+					return
+				}
+				if msg == nil {
+					return
+				}
+				mu.Lock()
+				claims[msg.IssueNumber]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claims) != messages {
+		t.Errorf("expected %d messages claimed, got %d", messages, len(claims))
+	}
+	for issue, n := range claims {
+		if n != 1 {
+			t.Errorf("issue %d claimed %d times", issue, n)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// LeaseExtender can extend the lease of an in-flight message
+type LeaseExtender interface {
+	ExtendLease(ctx context.Context, receiptHandle string, timeout time.Duration) error
+}
+
+// Heartbeat periodically extends the lease (visibility timeout) of an in-flight
+// message so that long-running tasks are not redelivered to another worker.
+type Heartbeat struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// StartHeartbeat extends the message lease to timeout every interval until
+// Stop is called or ctx is cancelled. A non-positive interval disables it.
+func StartHeartbeat(ctx context.Context, ext LeaseExtender, receiptHandle string, interval, timeout time.Duration) *Heartbeat {
+	hbCtx, cancel := context.WithCancel(ctx)
+	h := &Heartbeat{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	if interval <= 0 {
+		close(h.done)
+		return h
+	}
+
+	go func() {
+		defer close(h.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hbCtx.Done():
+				return
+			case <-ticker.C:
+				if err := ext.ExtendLease(hbCtx, receiptHandle, timeout); err != nil {
+					if hbCtx.Err() != nil {
+						return
+					}
+					slog.Warn("Failed to extend message lease",
+						"receipt_handle", receiptHandle,
+						"error", err,
+					)
+					continue
+				}
+				slog.Debug("Message lease extended", "timeout", timeout)
+			}
+		}
+	}()
+
+	return h
+}
+
+// Stop stops the heartbeat and waits for any in-progress extension to finish.
+// It is safe to call Stop more than once.
+func (h *Heartbeat) Stop() {
+	h.once.Do(h.cancel)
+	<-h.done
+}
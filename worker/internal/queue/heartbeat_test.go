@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+type countingExtender struct {
+	calls atomic.Int32
+}
+
+func (e *countingExtender) ExtendLease(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	e.calls.Add(1)
+	return nil
+}
+
+func TestHeartbeat_ExtendsPeriodically(t *testing.T) {
+	ext := &countingExtender{}
+	hb := StartHeartbeat(context.Background(), ext, "receipt", 10*time.Millisecond, time.Minute)
+
+	time.Sleep(55 * time.Millisecond)
+	hb.Stop()
+
+	calls := ext.calls.Load()
+	if calls < 3 {
+		t.Errorf("expected at least 3 extensions, got %d", calls)
+	}
+
+	// No more calls after Stop
+	time.Sleep(30 * time.Millisecond)
+	if ext.calls.Load() != calls {
+		t.Error("heartbeat kept running after Stop")
+	}
+}
+
+func TestHeartbeat_StopIsIdempotent(t *testing.T) {
+	hb := StartHeartbeat(context.Background(), &countingExtender{}, "receipt", 10*time.Millisecond, time.Minute)
+	hb.Stop()
+	hb.Stop()
+}
+
+func TestHeartbeat_StopsOnContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	hb := StartHeartbeat(ctx, &countingExtender{}, "receipt", 10*time.Millisecond, time.Minute)
+
+	cancel()
+
+	select {
+	case <-hb.done:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat did not stop after context cancel")
+	}
+}
+
+func TestHeartbeat_DisabledInterval(t *testing.T) {
+	ext := &countingExtender{}
+	hb := StartHeartbeat(context.Background(), ext, "receipt", 0, time.Minute)
+	hb.Stop()
+
+	if ext.calls.Load() != 0 {
+		t.Error("expected no extensions with zero interval")
+	}
+}
+
+func TestHeartbeat_PreventsRedelivery(t *testing.T) {
+	q := NewMemory(time.Second, 50*time.Millisecond)
+	q.Inject(&sqs.Message{IssueNumber: 1, Repository: "test/repo", Title: "Long task"})
+
+	msg, err := q.Receive(context.Background())
+	if err != nil || msg == nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	hb := StartHeartbeat(context.Background(), q, msg.ReceiptHandle, 10*time.Millisecond, 50*time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+
+	q.requeueExpired()
+	if q.Len() != 0 {
+		t.Error("message was redelivered while heartbeat was running")
+	}
+
+	hb.Stop()
+	if err := q.Delete(context.Background(), msg.ReceiptHandle); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	time.Sleep(70 * time.Millisecond)
+	q.requeueExpired()
+	if q.Len() != 0 {
+		t.Error("deleted message should not be redelivered")
+	}
+}
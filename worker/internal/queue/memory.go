@@ -0,0 +1,203 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// Memory is an in-process queue for development and tests.
+// Received messages are redelivered when their lease expires without
+// being deleted or extended, like SQS does after the visibility timeout.
+type Memory struct {
+	waitTime time.Duration
+	lease    time.Duration // 0 disables lease expiry
+	messages chan *sqs.Message
+
+	mu       sync.Mutex
+	inflight map[string]*inflightMessage
+}
+
+type inflightMessage struct {
+	msg      *sqs.Message
+	deadline time.Time
+}
+
+// NewMemory creates an in-memory queue
+func NewMemory(waitTime, lease time.Duration) *Memory {
+	return &Memory{
+		waitTime: waitTime,
+		lease:    lease,
+		messages: make(chan *sqs.Message, 100), // Buffer for test messages
+		inflight: make(map[string]*inflightMessage),
+	}
+}
+
+// Inject adds a test message to the queue
+func (m *Memory) Inject(msg *sqs.Message) error {
+	// Generate a mock receipt handle
+	if msg.ReceiptHandle == "" {
+		msg.ReceiptHandle = newReceiptHandle(msg)
+	}
+
+	// Set created_at if not set
+	if msg.CreatedAt == "" {
+		msg.CreatedAt = time.Now().Format(time.RFC3339)
+	}
+
+	select {
+	case m.messages <- msg:
+		slog.Info("Test message injected",
+			"issue_number", msg.IssueNumber,
+			"repository", msg.Repository,
+			"title", msg.Title,
+		)
+		return nil
+	default:
+		return fmt.Errorf("memory queue is full")
+	}
+}
+
+// InjectJSON adds a test message from JSON string
+func (m *Memory) InjectJSON(jsonStr string) error {
+	var msg sqs.Message
+	if err := json.Unmarshal([]byte(jsonStr), &msg); err != nil {
+		return fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return m.Inject(&msg)
+}
+
+// Len returns the number of messages waiting in the queue
+func (m *Memory) Len() int {
+	return len(m.messages)
+}
+
+// Receive waits up to the configured wait time for a message
+func (m *Memory) Receive(ctx context.Context) (*sqs.Message, error) {
+	m.requeueExpired()
+
+	// First check if there are any test messages in the queue
+	select {
+	case msg := <-m.messages:
+		return m.deliver(msg), nil
+	default:
+		// No message in queue, do long polling simulation
+	}
+
+	// Simulate long polling delay
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case msg := <-m.messages:
+		return m.deliver(msg), nil
+	case <-time.After(m.waitTime):
+		return nil, nil // No message after timeout
+	}
+}
+
+// Delete removes an in-flight message
+func (m *Memory) Delete(ctx context.Context, receiptHandle string) error {
+	m.mu.Lock()
+	delete(m.inflight, receiptHandle)
+	m.mu.Unlock()
+	slog.Info("Memory queue: Message deleted", "receipt_handle", receiptHandle)
+	return nil
+}
+
+// ExtendLease resets the lease of an in-flight message
+func (m *Memory) ExtendLease(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	if m.lease == 0 {
+		return nil // Lease expiry disabled
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inflight, ok := m.inflight[receiptHandle]
+	if !ok || time.Now().After(inflight.deadline) {
+		return fmt.Errorf("memory queue: receipt handle %s is not in flight", receiptHandle)
+	}
+	inflight.deadline = time.Now().Add(timeout)
+	slog.Debug("Memory queue: Lease extended", "receipt_handle", receiptHandle, "timeout", timeout)
+	return nil
+}
+
+// Nack puts an in-flight message back on the queue immediately
+func (m *Memory) Nack(ctx context.Context, receiptHandle string) error {
+	m.mu.Lock()
+	inflight, ok := m.inflight[receiptHandle]
+	delete(m.inflight, receiptHandle)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("memory queue: receipt handle %s is not in flight", receiptHandle)
+	}
+	m.redeliver(inflight.msg)
+	return nil
+}
+
+// deliver records the lease for a received message
+func (m *Memory) deliver(msg *sqs.Message) *sqs.Message {
+	slog.Info("Memory queue: Received message",
+		"issue_number", msg.IssueNumber,
+		"repository", msg.Repository,
+	)
+
+	m.mu.Lock()
+	deadline := time.Time{} // Zero deadline never expires
+	if m.lease > 0 {
+		deadline = time.Now().Add(m.lease)
+	}
+	m.inflight[msg.ReceiptHandle] = &inflightMessage{msg: msg, deadline: deadline}
+	m.mu.Unlock()
+	return msg
+}
+
+// requeueExpired puts messages whose lease has expired back on the queue
+func (m *Memory) requeueExpired() {
+	if m.lease == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	var expired []*sqs.Message
+	now := time.Now()
+	for handle, inflight := range m.inflight {
+		if now.Before(inflight.deadline) {
+			continue
+		}
+		delete(m.inflight, handle)
+		expired = append(expired, inflight.msg)
+		slog.Warn("Memory queue: Lease expired",
+			"issue_number", inflight.msg.IssueNumber,
+			"receipt_handle", handle,
+		)
+	}
+	m.mu.Unlock()
+
+	for _, msg := range expired {
+		m.redeliver(msg)
+	}
+}
+
+// redeliver enqueues a copy of msg with a fresh receipt handle
+func (m *Memory) redeliver(msg *sqs.Message) {
+	redelivered := *msg
+	redelivered.ReceiptHandle = newReceiptHandle(&redelivered)
+
+	select {
+	case m.messages <- &redelivered:
+		slog.Info("Memory queue: Message redelivered", "issue_number", redelivered.IssueNumber)
+	default:
+		slog.Error("Memory queue: Queue is full, message dropped", "issue_number", redelivered.IssueNumber)
+	}
+}
+
+func newReceiptHandle(msg *sqs.Message) string {
+	return fmt.Sprintf("mock-receipt-%d-%d", msg.IssueNumber, time.Now().UnixNano())
+}
@@ -0,0 +1,259 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+func TestNewMemory(t *testing.T) {
+	q := NewMemory(5*time.Second, time.Minute)
+
+	if q == nil {
+		t.Fatal("NewMemory returned nil")
+	}
+	if q.messages == nil {
+		t.Error("expected messages to be initialized")
+	}
+	if q.waitTime != 5*time.Second {
+		t.Errorf("expected wait time 5s, got %v", q.waitTime)
+	}
+}
+
+func TestMemory_Inject(t *testing.T) {
+	q := NewMemory(time.Second, 0)
+
+	msg := &sqs.Message{
+		IssueNumber: 42,
+		Repository:  "test/repo",
+		Title:       "Test task",
+		Body:        "Test body",
+	}
+
+	err := q.Inject(msg)
+	if err != nil {
+		t.Fatalf("Inject failed: %v", err)
+	}
+
+	if q.Len() != 1 {
+		t.Errorf("expected queue length 1, got %d", q.Len())
+	}
+
+	// Check that receipt handle was generated
+	if msg.ReceiptHandle == "" {
+		t.Error("expected ReceiptHandle to be generated")
+	}
+
+	// Check that created_at was set
+	if msg.CreatedAt == "" {
+		t.Error("expected CreatedAt to be set")
+	}
+}
+
+func TestMemory_InjectJSON(t *testing.T) {
+	q := NewMemory(time.Second, 0)
+
+	jsonStr := `{
+		"issue_number": 123,
+		"repository": "owner/repo",
+		"title": "JSON test",
+		"body": "Body from JSON"
+	}`
+
+	err := q.InjectJSON(jsonStr)
+	if err != nil {
+		t.Fatalf("InjectJSON failed: %v", err)
+	}
+
+	if q.Len() != 1 {
+		t.Errorf("expected queue length 1, got %d", q.Len())
+	}
+}
+
+func TestMemory_InjectJSON_InvalidJSON(t *testing.T) {
+	q := NewMemory(time.Second, 0)
+
+	err := q.InjectJSON("invalid json")
+	if err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
+
+func TestMemory_Receive(t *testing.T) {
+	q := NewMemory(time.Second, 0)
+
+	// Inject a message first
+	msg := &sqs.Message{
+		IssueNumber: 99,
+		Repository:  "test/repo",
+		Title:       "Receive test",
+	}
+	if err := q.Inject(msg); err != nil {
+		t.Fatalf("failed to inject: %v", err)
+	}
+
+	// Receive it
+	received, err := q.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if received == nil {
+		t.Fatal("expected to receive a message")
+	}
+	if received.IssueNumber != 99 {
+		t.Errorf("expected issue 99, got %d", received.IssueNumber)
+	}
+	if received.Repository != "test/repo" {
+		t.Errorf("expected 'test/repo', got %s", received.Repository)
+	}
+}
+
+func TestMemory_Receive_NoMessage(t *testing.T) {
+	q := NewMemory(100*time.Millisecond, 0) // Short timeout for test
+
+	received, err := q.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if received != nil {
+		t.Error("expected nil when no message available")
+	}
+}
+
+func TestMemory_Receive_ContextCancelled(t *testing.T) {
+	q := NewMemory(10*time.Second, 0) // Long timeout
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Cancel after short delay
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	received, err := q.Receive(ctx)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if received != nil {
+		t.Error("expected nil message on cancellation")
+	}
+}
+
+func TestMemory_Delete(t *testing.T) {
+	q := NewMemory(100*time.Millisecond, 30*time.Millisecond)
+
+	err := q.Delete(context.Background(), "mock-receipt-123")
+	if err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+
+	// A deleted message is not redelivered after its lease would expire
+	q.Inject(&sqs.Message{IssueNumber: 7, Repository: "test/repo", Title: "Delete"})
+	msg, err := q.Receive(context.Background())
+	if err != nil || msg == nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if err := q.Delete(context.Background(), msg.ReceiptHandle); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if again, _ := q.Receive(context.Background()); again != nil {
+		t.Errorf("deleted message was redelivered: %+v", again)
+	}
+}
+
+func TestMemory_LeaseExpiry(t *testing.T) {
+	q := NewMemory(time.Second, 30*time.Millisecond)
+	q.Inject(&sqs.Message{IssueNumber: 5, Repository: "test/repo", Title: "Lease"})
+
+	first, err := q.Receive(context.Background())
+	if err != nil || first == nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	// Lease expires without heartbeat or delete
+	time.Sleep(50 * time.Millisecond)
+
+	second, err := q.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if second == nil {
+		t.Fatal("expected message to be redelivered after lease expiry")
+	}
+	if second.IssueNumber != 5 {
+		t.Errorf("expected issue 5, got %d", second.IssueNumber)
+	}
+	if second.ReceiptHandle == first.ReceiptHandle {
+		t.Error("expected a new receipt handle on redelivery")
+	}
+
+	// The stale receipt handle can no longer extend the lease
+	if err := q.ExtendLease(context.Background(), first.ReceiptHandle, time.Minute); err == nil {
+		t.Error("expected error when extending an expired lease")
+	}
+}
+
+func TestMemory_ExtendLease(t *testing.T) {
+	q := NewMemory(time.Second, 30*time.Millisecond)
+	q.Inject(&sqs.Message{IssueNumber: 6, Repository: "test/repo", Title: "Extend"})
+
+	msg, err := q.Receive(context.Background())
+	if err != nil || msg == nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if err := q.ExtendLease(context.Background(), msg.ReceiptHandle, time.Minute); err != nil {
+		t.Fatalf("ExtendLease failed: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	q.requeueExpired()
+	if q.Len() != 0 {
+		t.Error("message should stay in flight after its lease was extended")
+	}
+}
+
+func TestMemory_Nack(t *testing.T) {
+	q := NewMemory(time.Second, time.Hour)
+	q.Inject(&sqs.Message{IssueNumber: 8, Repository: "test/repo", Title: "Nack"})
+
+	msg, err := q.Receive(context.Background())
+	if err != nil || msg == nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if err := q.Nack(context.Background(), msg.ReceiptHandle); err != nil {
+		t.Fatalf("Nack failed: %v", err)
+	}
+	if q.Len() != 1 {
+		t.Errorf("expected message to be back in the queue, got length %d", q.Len())
+	}
+
+	if err := q.Nack(context.Background(), msg.ReceiptHandle); err == nil {
+		t.Error("expected error when releasing a message twice")
+	}
+}
+
+func TestMemory_Len(t *testing.T) {
+	q := NewMemory(time.Second, 0)
+
+	if q.Len() != 0 {
+		t.Errorf("expected 0, got %d", q.Len())
+	}
+
+	// Add messages
+	for i := 1; i <= 3; i++ {
+		msg := &sqs.Message{IssueNumber: i, Repository: "test/repo", Title: "Test"}
+		q.Inject(msg)
+	}
+
+	if q.Len() != 3 {
+		t.Errorf("expected 3, got %d", q.Len())
+	}
+}
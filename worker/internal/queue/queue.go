@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+	"github.com/OkadaSatoshi/codingworker/worker/internal/sqs"
+)
+
+// Backend names accepted in queue.backend
+const (
+	BackendMemory = "memory"
+	BackendSQS    = "sqs"
+	BackendFile   = "file"
+)
+
+// Queue is a source of task messages with lease (visibility timeout) semantics
+type Queue interface {
+	// Receive waits for the next message. Returns nil, nil when none arrives.
+	Receive(ctx context.Context) (*sqs.Message, error)
+	// Delete removes a processed message
+	Delete(ctx context.Context, receiptHandle string) error
+	// ExtendLease keeps an in-flight message hidden for another timeout
+	ExtendLease(ctx context.Context, receiptHandle string, timeout time.Duration) error
+	// Nack releases an in-flight message so it is redelivered promptly
+	Nack(ctx context.Context, receiptHandle string) error
+}
+
+var (
+	_ Queue = (*Memory)(nil)
+	_ Queue = (*File)(nil)
+	_ Queue = (*sqs.Client)(nil)
+)
+
+// Injector is implemented by local backends that accept messages directly
+type Injector interface {
+	Inject(msg *sqs.Message) error
+}
+
+// New creates the queue backend selected in config
+func New(cfg config.QueueConfig, sqsCfg config.SQSConfig) (Queue, error) {
+	waitTime := time.Duration(sqsCfg.WaitTimeSeconds) * time.Second
+	lease := time.Duration(sqsCfg.VisibilityTimeout) * time.Second
+
+	switch cfg.Backend {
+	case BackendMemory:
+		return NewMemory(waitTime, lease), nil
+	case BackendSQS:
+		return sqs.NewClient(sqsCfg), nil
+	case BackendFile:
+		return NewFile(cfg.Dir, waitTime, lease)
+	default:
+		return nil, fmt.Errorf("unknown queue backend: %q", cfg.Backend)
+	}
+}
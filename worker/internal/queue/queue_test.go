@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	sqsCfg := config.SQSConfig{WaitTimeSeconds: 1, VisibilityTimeout: 60}
+
+	tests := []struct {
+		name string
+		cfg  config.QueueConfig
+		want string
+	}{
+		{"memory", config.QueueConfig{Backend: BackendMemory}, "*queue.Memory"},
+		{"sqs", config.QueueConfig{Backend: BackendSQS}, "*sqs.Client"},
+		{"file", config.QueueConfig{Backend: BackendFile, Dir: t.TempDir()}, "*queue.File"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := New(tt.cfg, sqsCfg)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			if got := fmt.Sprintf("%T", q); got != tt.want {
+				t.Errorf("New(%q) = %s, want %s", tt.cfg.Backend, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_UnknownBackend(t *testing.T) {
+	if _, err := New(config.QueueConfig{Backend: "kafka"}, config.SQSConfig{}); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+}
+
+func TestNew_FileWithoutDir(t *testing.T) {
+	if _, err := New(config.QueueConfig{Backend: BackendFile}, config.SQSConfig{}); err == nil {
+		t.Error("expected error when queue.dir is empty")
+	}
+}
+
+func TestNew_SQSIsNotInjectable(t *testing.T) {
+	q, err := New(config.QueueConfig{Backend: BackendSQS}, config.SQSConfig{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, ok := q.(Injector); ok {
+		t.Error("sqs backend should not accept injected messages")
+	}
+}
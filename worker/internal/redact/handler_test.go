@@ -0,0 +1,35 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	secret := "handler-test-secret"
+	Register(secret)
+
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil)))
+
+	logger.With("token", secret).Info("using "+secret,
+		"error", errors.New("failed with "+secret),
+		"args", []string{"--token", secret},
+		slog.Group("req", "auth", "Authorization: Bearer "+secret),
+		"count", 3,
+	)
+
+	out := buf.String()
+	if strings.Contains(out, secret) {
+		t.Errorf("secret leaked into log output: %s", out)
+	}
+	if !strings.Contains(out, `"count":3`) {
+		t.Errorf("non-string attrs should be preserved: %s", out)
+	}
+	if !strings.Contains(out, Placeholder) {
+		t.Errorf("expected placeholder in output: %s", out)
+	}
+}
@@ -2,18 +2,41 @@ package retry
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"math/rand/v2"
 	"strings"
 	"time"
 )
 
+// Jitter strategies
+const (
+	JitterNone         = "none"         // Plain exponential backoff
+	JitterFull         = "full"         // Random delay between 0 and the exponential backoff
+	JitterDecorrelated = "decorrelated" // Random delay between the initial backoff and the previous delay * multiplier
+)
+
+// Clock abstracts waiting so tests can run without real delays
+type Clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
 // Policy defines retry behavior
 type Policy struct {
 	MaxRetries     int
 	InitialBackoff time.Duration
 	MaxBackoff     time.Duration
 	Multiplier     float64
+	Jitter         string
+
+	Clock Clock          // nil = real time
+	Rand  func() float64 // Returns [0.0, 1.0); nil = math/rand
 }
 
 // DefaultPolicy returns the default retry policy with fixed 10s backoff
@@ -28,6 +51,35 @@ func NewPolicy(maxRetries int) *Policy {
 		InitialBackoff: 10 * time.Second,
 		MaxBackoff:     10 * time.Second, // Fixed backoff (no exponential growth)
 		Multiplier:     1.0,              // No multiplier
+		Jitter:         JitterNone,
+	}
+}
+
+// backoff returns the delay before retry number attempt (1-based), given the
+// previous delay (used by decorrelated jitter)
+func (p *Policy) backoff(attempt int, prev time.Duration) time.Duration {
+	random := p.Rand
+	if random == nil {
+		random = rand.Float64
+	}
+
+	capped := func(d float64) float64 {
+		if p.MaxBackoff > 0 {
+			return min(d, float64(p.MaxBackoff))
+		}
+		return d
+	}
+	exp := capped(float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1)))
+
+	switch p.Jitter {
+	case JitterFull:
+		return time.Duration(random() * exp)
+	case JitterDecorrelated:
+		lo := float64(p.InitialBackoff)
+		hi := max(float64(prev)*p.Multiplier, lo)
+		return time.Duration(capped(lo + random()*(hi-lo)))
+	default:
+		return time.Duration(exp)
 	}
 }
 
@@ -52,6 +104,10 @@ type ClassifiableError interface {
 // TransientError represents a temporary error
 type TransientError struct {
 	Err error
+	// RetryAfter is a server-suggested minimum delay before retrying
+	// (e.g. from Retry-After or a rate-limit reset). 0 = use the policy backoff.
+	// Longer than the policy's MaxBackoff, the operation is not retried.
+	RetryAfter time.Duration
 }
 
 func (e *TransientError) Error() string {
@@ -87,12 +143,21 @@ func (e *PermanentError) Unwrap() error {
 type Result struct {
 	Attempts int
 	LastErr  error
+
+	maxRetries int // Of the policy that produced the result
 }
 
-// Do executes the function with retry logic
+// Do executes the function with retry logic. Errors classified as permanent
+// anywhere in their chain are not retried; a TransientError's RetryAfter is
+// used when it is longer than the backoff, and ends the retries when it is
+// longer than MaxBackoff.
 func (p *Policy) Do(ctx context.Context, operation func() error) *Result {
-	result := &Result{}
-	backoff := p.InitialBackoff
+	result := &Result{maxRetries: p.MaxRetries}
+	clock := p.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	var delay time.Duration
 
 	for attempt := 1; attempt <= p.MaxRetries+1; attempt++ {
 		result.Attempts = attempt
@@ -106,14 +171,13 @@ func (p *Policy) Do(ctx context.Context, operation func() error) *Result {
 		result.LastErr = err
 
 		// Check if error is permanent
-		if classifiable, ok := err.(ClassifiableError); ok {
-			if classifiable.ErrorType() != ErrorTypeTransient {
-				slog.Warn("Permanent error, not retrying",
-					"attempt", attempt,
-					"error", err,
-				)
-				return result
-			}
+		var classifiable ClassifiableError
+		if errors.As(err, &classifiable) && classifiable.ErrorType() != ErrorTypeTransient {
+			slog.Warn("Permanent error, not retrying",
+				"attempt", attempt,
+				"error", err,
+			)
+			return result
 		}
 
 		// Don't retry if we've exhausted attempts
@@ -125,9 +189,24 @@ func (p *Policy) Do(ctx context.Context, operation func() error) *Result {
 			return result
 		}
 
+		delay = p.backoff(attempt, max(delay, p.InitialBackoff))
+		var transient *TransientError
+		if errors.As(err, &transient) && transient.RetryAfter > delay {
+			if p.MaxBackoff > 0 && transient.RetryAfter > p.MaxBackoff {
+				slog.Warn("Retry-After exceeds max backoff, not retrying",
+					"attempt", attempt,
+					"retry_after", transient.RetryAfter,
+					"max_backoff", p.MaxBackoff,
+					"error", err,
+				)
+				return result
+			}
+			delay = transient.RetryAfter
+		}
+
 		slog.Warn("Operation failed, retrying",
 			"attempt", attempt,
-			"backoff", backoff,
+			"backoff", delay,
 			"error", err,
 		)
 
@@ -136,22 +215,16 @@ func (p *Policy) Do(ctx context.Context, operation func() error) *Result {
 		case <-ctx.Done():
 			result.LastErr = ctx.Err()
 			return result
-		case <-time.After(backoff):
-		}
-
-		// Increase backoff for next attempt
-		backoff = time.Duration(float64(backoff) * p.Multiplier)
-		if backoff > p.MaxBackoff {
-			backoff = p.MaxBackoff
+		case <-clock.After(delay):
 		}
 	}
 
 	return result
 }
 
-// IsRetryExhausted returns true if all retries were used
+// IsRetryExhausted returns true if all retries of the policy were used
 func (r *Result) IsRetryExhausted() bool {
-	return r.Attempts > DefaultPolicy().MaxRetries && r.LastErr != nil
+	return r.Attempts > r.maxRetries && r.LastErr != nil
 }
 
 // ClassifyHTTPStatus returns the error type based on HTTP status code
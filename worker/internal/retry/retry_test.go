@@ -3,6 +3,7 @@ package retry
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -189,6 +190,9 @@ func TestPolicy_Do_MaxRetriesExceeded(t *testing.T) {
 	if callCount != 3 {
 		t.Errorf("expected 3 calls, got %d", callCount)
 	}
+	if !result.IsRetryExhausted() {
+		t.Error("expected retries of the policy to be exhausted")
+	}
 }
 
 func TestPolicy_Do_ContextCancelled(t *testing.T) {
@@ -217,6 +221,141 @@ func TestPolicy_Do_ContextCancelled(t *testing.T) {
 	}
 }
 
+// fakeClock fires immediately and records every requested delay
+type fakeClock struct {
+	delays []time.Duration
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.delays = append(c.delays, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func TestPolicy_Do_Backoff(t *testing.T) {
+	half := func() float64 { return 0.5 }
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []time.Duration
+	}{
+		{
+			name:   "fixed",
+			policy: Policy{InitialBackoff: 10 * time.Second, MaxBackoff: 10 * time.Second, Multiplier: 1.0},
+			want:   []time.Duration{10 * time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second},
+		},
+		{
+			name:   "exponential capped",
+			policy: Policy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 2.0, Jitter: JitterNone},
+			want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second},
+		},
+		{
+			name:   "full jitter",
+			policy: Policy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 2.0, Jitter: JitterFull, Rand: half},
+			want:   []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second},
+		},
+		{
+			name:   "decorrelated jitter",
+			policy: Policy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 3.0, Jitter: JitterDecorrelated, Rand: half},
+			want:   []time.Duration{2 * time.Second, 3500 * time.Millisecond, 5750 * time.Millisecond, 9125 * time.Millisecond, 10 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{}
+			policy := tt.policy
+			policy.MaxRetries = len(tt.want)
+			policy.Clock = clock
+
+			result := policy.Do(context.Background(), func() error {
+				return &TransientError{Err: errors.New("transient")}
+			})
+
+			if result.Attempts != len(tt.want)+1 {
+				t.Errorf("expected %d attempts, got %d", len(tt.want)+1, result.Attempts)
+			}
+			if len(clock.delays) != len(tt.want) {
+				t.Fatalf("delays = %v, want %v", clock.delays, tt.want)
+			}
+			for i := range tt.want {
+				if clock.delays[i] != tt.want[i] {
+					t.Errorf("delay %d = %v, want %v", i+1, clock.delays[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPolicy_Do_RetryAfter(t *testing.T) {
+	clock := &fakeClock{}
+	policy := &Policy{
+		MaxRetries:     2,
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2.0,
+		Clock:          clock,
+	}
+
+	callCount := 0
+	result := policy.Do(context.Background(), func() error {
+		callCount++
+		switch callCount {
+		case 1:
+			// Longer than the backoff: the server knows best
+			return &TransientError{Err: errors.New("rate limited"), RetryAfter: 8 * time.Second}
+		case 2:
+			// Shorter than the backoff: the backoff wins
+			return fmt.Errorf("wrapped: %w", &TransientError{Err: errors.New("rate limited"), RetryAfter: time.Millisecond})
+		}
+		return nil
+	})
+
+	if result.LastErr != nil {
+		t.Errorf("expected success, got %v", result.LastErr)
+	}
+	want := []time.Duration{8 * time.Second, 2 * time.Second}
+	if len(clock.delays) != len(want) || clock.delays[0] != want[0] || clock.delays[1] != want[1] {
+		t.Errorf("delays = %v, want %v", clock.delays, want)
+	}
+}
+
+func TestPolicy_Do_RetryAfterBeyondMaxBackoff(t *testing.T) {
+	clock := &fakeClock{}
+	policy := &Policy{MaxRetries: 3, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 2.0, Clock: clock}
+
+	result := policy.Do(context.Background(), func() error {
+		return &TransientError{Err: errors.New("rate limited"), RetryAfter: time.Hour}
+	})
+
+	if result.Attempts != 1 || result.LastErr == nil {
+		t.Errorf("expected to give up after 1 attempt, got %d (%v)", result.Attempts, result.LastErr)
+	}
+	if len(clock.delays) != 0 {
+		t.Errorf("expected no wait, got %v", clock.delays)
+	}
+	if result.IsRetryExhausted() {
+		t.Error("retries are not exhausted when the operation gave up early")
+	}
+}
+
+func TestPolicy_Do_WrappedPermanentNoRetry(t *testing.T) {
+	clock := &fakeClock{}
+	policy := &Policy{MaxRetries: 3, InitialBackoff: time.Second, Multiplier: 1.0, Clock: clock}
+
+	result := policy.Do(context.Background(), func() error {
+		return fmt.Errorf("clone failed: %w", &PermanentError{Err: errors.New("not found")})
+	})
+
+	if result.Attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", result.Attempts)
+	}
+	if len(clock.delays) != 0 {
+		t.Errorf("expected no backoff, got %v", clock.delays)
+	}
+}
+
 func TestTransientError(t *testing.T) {
 	baseErr := errors.New("base")
 	err := &TransientError{Err: baseErr}
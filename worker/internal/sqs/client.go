@@ -8,155 +8,132 @@ import (
 	"sync"
 	"time"
 
-	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
-)
-
-// Message represents a task message from SQS
-type Message struct {
-	IssueNumber   int      `json:"issue_number"`
-	Repository    string   `json:"repository"`
-	Title         string   `json:"title"`
-	Body          string   `json:"body"`
-	Labels        []string `json:"labels"`
-	CreatedAt     string   `json:"created_at"`
-	ReceiptHandle string   `json:"-"`
-}
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
 
-// Label constants
-const (
-	LabelTrigger = "ai-task"        // Triggers worker processing
-	LabelFailed  = "ai-task-failed" // Added on failure
-	LabelDone    = "ai-task-done"   // Added on success (ai-task removed)
+	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
 )
 
-// Client handles SQS operations
+// Client handles AWS SQS operations
 type Client struct {
 	config    config.SQSConfig
-	useMock   bool
-	mockQueue chan *Message
 	mu        sync.Mutex
+	awsClient *awssqs.Client // Lazily initialized on first AWS call
 }
 
 // NewClient creates a new SQS client
 func NewClient(cfg config.SQSConfig) *Client {
 	return &Client{
-		config:    cfg,
-		useMock:   cfg.UseMock,
-		mockQueue: make(chan *Message, 100), // Buffer for test messages
+		config: cfg,
 	}
 }
 
-// ReceiveMessage receives a message from SQS (or mock)
-func (c *Client) ReceiveMessage(ctx context.Context) (*Message, error) {
-	if c.useMock {
-		return c.receiveMockMessage(ctx)
+// Receive long-polls SQS for a single message.
+// Returns nil, nil when no message arrives within WaitTimeSeconds.
+func (c *Client) Receive(ctx context.Context) (*Message, error) {
+	api, err := c.api(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return c.receiveFromAWS(ctx)
-}
 
-// DeleteMessage deletes a message from SQS
-func (c *Client) DeleteMessage(ctx context.Context, receiptHandle string) error {
-	if c.useMock {
-		slog.Info("Mock: Message deleted", "receipt_handle", receiptHandle)
-		return nil
-	}
-	return c.deleteFromAWS(ctx, receiptHandle)
-}
-
-// InjectTestMessage adds a test message to the mock queue
-func (c *Client) InjectTestMessage(msg *Message) error {
-	if !c.useMock {
-		return fmt.Errorf("cannot inject test message when not in mock mode")
+	out, err := api.ReceiveMessage(ctx, &awssqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(c.config.QueueURL),
+		MaxNumberOfMessages: 1,
+		WaitTimeSeconds:     int32(c.config.WaitTimeSeconds),
+		VisibilityTimeout:   int32(c.config.VisibilityTimeout),
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("sqs receive failed: %w", err)
 	}
 
-	// Generate a mock receipt handle
-	if msg.ReceiptHandle == "" {
-		msg.ReceiptHandle = fmt.Sprintf("mock-receipt-%d-%d", msg.IssueNumber, time.Now().UnixNano())
+	if len(out.Messages) == 0 {
+		return nil, nil // No message after long polling
 	}
 
-	// Set created_at if not set
-	if msg.CreatedAt == "" {
-		msg.CreatedAt = time.Now().Format(time.RFC3339)
+	raw := out.Messages[0]
+	var msg Message
+	if err := json.Unmarshal([]byte(aws.ToString(raw.Body)), &msg); err != nil {
+		// Leave the message in the queue; it becomes visible again and
+		// eventually moves to the DLQ via the redrive policy.
+		return nil, fmt.Errorf("failed to parse message %s: %w", aws.ToString(raw.MessageId), err)
 	}
+	msg.ReceiptHandle = aws.ToString(raw.ReceiptHandle)
+
+	slog.Info("Received message from SQS",
+		"message_id", aws.ToString(raw.MessageId),
+		"issue_number", msg.IssueNumber,
+		"repository", msg.Repository,
+	)
+	return &msg, nil
+}
 
-	select {
-	case c.mockQueue <- msg:
-		slog.Info("Test message injected",
-			"issue_number", msg.IssueNumber,
-			"repository", msg.Repository,
-			"title", msg.Title,
-		)
-		return nil
-	default:
-		return fmt.Errorf("mock queue is full")
+// Delete removes a processed message from SQS
+func (c *Client) Delete(ctx context.Context, receiptHandle string) error {
+	api, err := c.api(ctx)
+	if err != nil {
+		return err
 	}
-}
 
-// InjectTestMessageFromJSON adds a test message from JSON string
-func (c *Client) InjectTestMessageFromJSON(jsonStr string) error {
-	var msg Message
-	if err := json.Unmarshal([]byte(jsonStr), &msg); err != nil {
-		return fmt.Errorf("failed to parse JSON: %w", err)
+	_, err = api.DeleteMessage(ctx, &awssqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.config.QueueURL),
+		ReceiptHandle: aws.String(receiptHandle),
+	})
+	if err != nil {
+		return fmt.Errorf("sqs delete failed: %w", err)
 	}
-	return c.InjectTestMessage(&msg)
-}
 
-// QueueLength returns the number of messages in the mock queue
-func (c *Client) QueueLength() int {
-	return len(c.mockQueue)
+	slog.Info("Message deleted from SQS")
+	return nil
 }
 
-// Mock implementation for development
-func (c *Client) receiveMockMessage(ctx context.Context) (*Message, error) {
-	// First check if there are any test messages in the queue
-	select {
-	case msg := <-c.mockQueue:
-		slog.Info("Mock: Received message from test queue",
-			"issue_number", msg.IssueNumber,
-			"repository", msg.Repository,
-		)
-		return msg, nil
-	default:
-		// No message in queue, do long polling simulation
+// ExtendLease resets the visibility timeout of an in-flight message
+func (c *Client) ExtendLease(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	api, err := c.api(ctx)
+	if err != nil {
+		return err
 	}
 
-	// Simulate long polling delay
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case msg := <-c.mockQueue:
-		slog.Info("Mock: Received message from test queue",
-			"issue_number", msg.IssueNumber,
-			"repository", msg.Repository,
-		)
-		return msg, nil
-	case <-time.After(time.Duration(c.config.WaitTimeSeconds) * time.Second):
-		return nil, nil // No message after timeout
+	_, err = api.ChangeMessageVisibility(ctx, &awssqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(c.config.QueueURL),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: int32(timeout / time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("sqs change visibility failed: %w", err)
 	}
+	return nil
 }
 
-// AWS implementation (placeholder)
-func (c *Client) receiveFromAWS(ctx context.Context) (*Message, error) {
-	// TODO: Implement real AWS SQS receive
-	// This will be implemented when AWS infrastructure is ready
-	slog.Warn("AWS SQS not implemented, falling back to mock")
-	return c.receiveMockMessage(ctx)
+// Nack makes an in-flight message visible again immediately
+func (c *Client) Nack(ctx context.Context, receiptHandle string) error {
+	return c.ExtendLease(ctx, receiptHandle, 0)
 }
 
-func (c *Client) deleteFromAWS(ctx context.Context, receiptHandle string) error {
-	// TODO: Implement real AWS SQS delete
-	slog.Warn("AWS SQS delete not implemented")
-	return nil
-}
+// api returns the AWS SQS API client, creating it on first use
+func (c *Client) api(ctx context.Context) (*awssqs.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-// CreateTestMessage is a helper to create a test message
-func CreateTestMessage(repo string, issueNumber int, title, body string) *Message {
-	return &Message{
-		IssueNumber: issueNumber,
-		Repository:  repo,
-		Title:       title,
-		Body:        body,
-		Labels:      []string{LabelTrigger},
-		CreatedAt:   time.Now().Format(time.RFC3339),
+	if c.awsClient != nil {
+		return c.awsClient, nil
+	}
+	if c.config.QueueURL == "" {
+		return nil, fmt.Errorf("sqs queue_url is not configured")
 	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(c.config.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	c.awsClient = awssqs.NewFromConfig(awsCfg, func(o *awssqs.Options) {
+		if c.config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.config.Endpoint)
+		}
+	})
+	return c.awsClient, nil
 }
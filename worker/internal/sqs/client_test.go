@@ -3,261 +3,187 @@ package sqs
 import (
 	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/OkadaSatoshi/codingworker/worker/internal/config"
 )
 
-func TestNewClient(t *testing.T) {
-	cfg := config.SQSConfig{
-		UseMock:         true,
-		WaitTimeSeconds: 5,
-	}
-
-	client := NewClient(cfg)
-
-	if client == nil {
-		t.Fatal("NewClient returned nil")
-	}
-	if !client.useMock {
-		t.Error("expected useMock to be true")
-	}
-	if client.mockQueue == nil {
-		t.Error("expected mockQueue to be initialized")
-	}
+// fakeSQS is a minimal SQS-compatible server (AWS JSON protocol),
+// similar to running ElasticMQ locally.
+type fakeSQS struct {
+	mu       sync.Mutex
+	bodies   []string
+	deleted  []string
+	requests map[string]map[string]interface{}
 }
 
-func TestInjectTestMessage(t *testing.T) {
-	cfg := config.SQSConfig{UseMock: true}
-	client := NewClient(cfg)
-
-	msg := &Message{
-		IssueNumber: 42,
-		Repository:  "test/repo",
-		Title:       "Test task",
-		Body:        "Test body",
-	}
-
-	err := client.InjectTestMessage(msg)
-	if err != nil {
-		t.Fatalf("InjectTestMessage failed: %v", err)
-	}
-
-	if client.QueueLength() != 1 {
-		t.Errorf("expected queue length 1, got %d", client.QueueLength())
-	}
-
-	// Check that receipt handle was generated
-	if msg.ReceiptHandle == "" {
-		t.Error("expected ReceiptHandle to be generated")
-	}
-
-	// Check that created_at was set
-	if msg.CreatedAt == "" {
-		t.Error("expected CreatedAt to be set")
-	}
+func newFakeSQS(t *testing.T, bodies ...string) (*fakeSQS, *httptest.Server) {
+	t.Helper()
+	f := &fakeSQS{bodies: bodies, requests: map[string]map[string]interface{}{}}
+	srv := httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(srv.Close)
+	return f, srv
 }
 
-func TestInjectTestMessage_NotMockMode(t *testing.T) {
-	cfg := config.SQSConfig{UseMock: false}
-	client := NewClient(cfg)
-
-	msg := &Message{
-		IssueNumber: 1,
-		Repository:  "test/repo",
-		Title:       "Test",
-	}
-
-	err := client.InjectTestMessage(msg)
-	if err == nil {
-		t.Error("expected error when not in mock mode")
+func (f *fakeSQS) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.")
+	var req map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.requests[action] = req
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	switch action {
+	case "ReceiveMessage":
+		if len(f.bodies) == 0 {
+			w.Write([]byte(`{}`))
+			return
+		}
+		body := f.bodies[0]
+		f.bodies = f.bodies[1:]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Messages": []map[string]string{{
+				"MessageId":     "msg-1",
+				"ReceiptHandle": "receipt-1",
+				"Body":          body,
+			}},
+		})
+	case "DeleteMessage":
+		f.deleted = append(f.deleted, req["ReceiptHandle"].(string))
+		w.Write([]byte(`{}`))
+	case "ChangeMessageVisibility":
+		w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"com.amazonaws.sqs#InvalidAction","message":"unsupported"}`))
 	}
 }
 
-func TestInjectTestMessageFromJSON(t *testing.T) {
-	cfg := config.SQSConfig{UseMock: true}
-	client := NewClient(cfg)
-
-	jsonStr := `{
-		"issue_number": 123,
-		"repository": "owner/repo",
-		"title": "JSON test",
-		"body": "Body from JSON"
-	}`
-
-	err := client.InjectTestMessageFromJSON(jsonStr)
-	if err != nil {
-		t.Fatalf("InjectTestMessageFromJSON failed: %v", err)
-	}
-
-	if client.QueueLength() != 1 {
-		t.Errorf("expected queue length 1, got %d", client.QueueLength())
-	}
+func newTestClient(t *testing.T, endpoint string) *Client {
+	t.Helper()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	return NewClient(config.SQSConfig{
+		QueueURL:          endpoint + "/000000000000/codingworker-tasks",
+		Region:            "ap-northeast-1",
+		Endpoint:          endpoint,
+		WaitTimeSeconds:   1,
+		VisibilityTimeout: 300,
+	})
 }
 
-func TestInjectTestMessageFromJSON_InvalidJSON(t *testing.T) {
-	cfg := config.SQSConfig{UseMock: true}
-	client := NewClient(cfg)
-
-	err := client.InjectTestMessageFromJSON("invalid json")
-	if err == nil {
-		t.Error("expected error for invalid JSON")
-	}
-}
+func TestReceive(t *testing.T) {
+	fake, srv := newFakeSQS(t, `{"issue_number": 7, "repository": "owner/repo", "title": "AWS test", "labels": ["ai-task"]}`)
+	client := newTestClient(t, srv.URL)
 
-func TestReceiveMessage_Mock(t *testing.T) {
-	cfg := config.SQSConfig{
-		UseMock:         true,
-		WaitTimeSeconds: 1,
+	received, err := client.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
 	}
-	client := NewClient(cfg)
-
-	// Inject a message first
-	msg := &Message{
-		IssueNumber: 99,
-		Repository:  "test/repo",
-		Title:       "Receive test",
+	if received == nil {
+		t.Fatal("expected to receive a message")
 	}
-	if err := client.InjectTestMessage(msg); err != nil {
-		t.Fatalf("failed to inject: %v", err)
+	if received.IssueNumber != 7 || received.Repository != "owner/repo" {
+		t.Errorf("unexpected message: %+v", received)
 	}
-
-	// Receive it
-	ctx := context.Background()
-	received, err := client.ReceiveMessage(ctx)
-	if err != nil {
-		t.Fatalf("ReceiveMessage failed: %v", err)
+	if received.ReceiptHandle != "receipt-1" {
+		t.Errorf("expected receipt handle 'receipt-1', got %q", received.ReceiptHandle)
 	}
 
-	if received == nil {
-		t.Fatal("expected to receive a message")
+	req := fake.requests["ReceiveMessage"]
+	if req["WaitTimeSeconds"] != float64(1) {
+		t.Errorf("expected WaitTimeSeconds 1, got %v", req["WaitTimeSeconds"])
 	}
-	if received.IssueNumber != 99 {
-		t.Errorf("expected issue 99, got %d", received.IssueNumber)
+	if req["VisibilityTimeout"] != float64(300) {
+		t.Errorf("expected VisibilityTimeout 300, got %v", req["VisibilityTimeout"])
 	}
-	if received.Repository != "test/repo" {
-		t.Errorf("expected 'test/repo', got %s", received.Repository)
+	if !strings.HasSuffix(req["QueueUrl"].(string), "/codingworker-tasks") {
+		t.Errorf("unexpected QueueUrl: %v", req["QueueUrl"])
 	}
 }
 
-func TestReceiveMessage_NoMessage(t *testing.T) {
-	cfg := config.SQSConfig{
-		UseMock:         true,
-		WaitTimeSeconds: 1, // Short timeout for test
-	}
-	client := NewClient(cfg)
+func TestReceive_Empty(t *testing.T) {
+	_, srv := newFakeSQS(t)
+	client := newTestClient(t, srv.URL)
 
-	ctx := context.Background()
-	received, err := client.ReceiveMessage(ctx)
+	received, err := client.Receive(context.Background())
 	if err != nil {
-		t.Fatalf("ReceiveMessage failed: %v", err)
+		t.Fatalf("Receive failed: %v", err)
 	}
-
 	if received != nil {
-		t.Error("expected nil when no message available")
+		t.Error("expected nil when queue is empty")
 	}
 }
 
-func TestReceiveMessage_ContextCancelled(t *testing.T) {
-	cfg := config.SQSConfig{
-		UseMock:         true,
-		WaitTimeSeconds: 10, // Long timeout
-	}
-	client := NewClient(cfg)
-
-	ctx, cancel := context.WithCancel(context.Background())
+func TestReceive_InvalidBody(t *testing.T) {
+	_, srv := newFakeSQS(t, "not json")
+	client := newTestClient(t, srv.URL)
 
-	// Cancel after short delay
-	go func() {
-		time.Sleep(50 * time.Millisecond)
-		cancel()
-	}()
-
-	received, err := client.ReceiveMessage(ctx)
-	if err != context.Canceled {
-		t.Errorf("expected context.Canceled, got %v", err)
-	}
-	if received != nil {
-		t.Error("expected nil message on cancellation")
+	if _, err := client.Receive(context.Background()); err == nil {
+		t.Error("expected error for invalid message body")
 	}
 }
 
-func TestDeleteMessage_Mock(t *testing.T) {
-	cfg := config.SQSConfig{UseMock: true}
-	client := NewClient(cfg)
+func TestReceive_MissingQueueURL(t *testing.T) {
+	client := NewClient(config.SQSConfig{Region: "ap-northeast-1"})
 
-	err := client.DeleteMessage(context.Background(), "mock-receipt-123")
-	if err != nil {
-		t.Errorf("DeleteMessage failed: %v", err)
+	if _, err := client.Receive(context.Background()); err == nil {
+		t.Error("expected error when queue_url is empty")
 	}
 }
 
-func TestQueueLength(t *testing.T) {
-	cfg := config.SQSConfig{UseMock: true}
-	client := NewClient(cfg)
+func TestExtendLease(t *testing.T) {
+	fake, srv := newFakeSQS(t)
+	client := newTestClient(t, srv.URL)
 
-	if client.QueueLength() != 0 {
-		t.Errorf("expected 0, got %d", client.QueueLength())
+	if err := client.ExtendLease(context.Background(), "receipt-9", 10*time.Minute); err != nil {
+		t.Fatalf("ExtendLease failed: %v", err)
 	}
 
-	// Add messages
-	for i := 1; i <= 3; i++ {
-		msg := &Message{IssueNumber: i, Repository: "test/repo", Title: "Test"}
-		client.InjectTestMessage(msg)
+	req := fake.requests["ChangeMessageVisibility"]
+	if req["ReceiptHandle"] != "receipt-9" {
+		t.Errorf("unexpected ReceiptHandle: %v", req["ReceiptHandle"])
 	}
-
-	if client.QueueLength() != 3 {
-		t.Errorf("expected 3, got %d", client.QueueLength())
+	if req["VisibilityTimeout"] != float64(600) {
+		t.Errorf("expected VisibilityTimeout 600, got %v", req["VisibilityTimeout"])
 	}
 }
 
-func TestCreateTestMessage(t *testing.T) {
-	msg := CreateTestMessage("owner/repo", 42, "Task title", "Task body")
+func TestNack(t *testing.T) {
+	fake, srv := newFakeSQS(t)
+	client := newTestClient(t, srv.URL)
 
-	if msg.IssueNumber != 42 {
-		t.Errorf("expected issue 42, got %d", msg.IssueNumber)
-	}
-	if msg.Repository != "owner/repo" {
-		t.Errorf("expected 'owner/repo', got %s", msg.Repository)
-	}
-	if msg.Title != "Task title" {
-		t.Errorf("expected 'Task title', got %s", msg.Title)
+	if err := client.Nack(context.Background(), "receipt-3"); err != nil {
+		t.Fatalf("Nack failed: %v", err)
 	}
-	if msg.Body != "Task body" {
-		t.Errorf("expected 'Task body', got %s", msg.Body)
+
+	req, ok := fake.requests["ChangeMessageVisibility"]
+	if !ok {
+		t.Fatal("expected a ChangeMessageVisibility request")
 	}
-	if len(msg.Labels) != 1 || msg.Labels[0] != LabelTrigger {
-		t.Errorf("expected [%s], got %v", LabelTrigger, msg.Labels)
+	if req["ReceiptHandle"] != "receipt-3" {
+		t.Errorf("unexpected ReceiptHandle: %v", req["ReceiptHandle"])
 	}
-	if msg.CreatedAt == "" {
-		t.Error("expected CreatedAt to be set")
+	if req["VisibilityTimeout"] != float64(0) {
+		t.Errorf("expected VisibilityTimeout 0, got %v", req["VisibilityTimeout"])
 	}
 }
 
-func TestMessage_JSONMarshal(t *testing.T) {
-	msg := &Message{
-		IssueNumber:   1,
-		Repository:    "test/repo",
-		Title:         "Test",
-		Body:          "Body",
-		Labels:        []string{"ai-task"},
-		CreatedAt:     "2024-01-01T00:00:00Z",
-		ReceiptHandle: "should-not-appear",
-	}
-
-	data, err := json.Marshal(msg)
-	if err != nil {
-		t.Fatalf("Marshal failed: %v", err)
-	}
+func TestDelete(t *testing.T) {
+	fake, srv := newFakeSQS(t)
+	client := newTestClient(t, srv.URL)
 
-	// ReceiptHandle should be omitted (json:"-")
-	var parsed map[string]interface{}
-	if err := json.Unmarshal(data, &parsed); err != nil {
-		t.Fatalf("Unmarshal failed: %v", err)
+	if err := client.Delete(context.Background(), "receipt-42"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
 	}
-
-	if _, exists := parsed["ReceiptHandle"]; exists {
-		t.Error("ReceiptHandle should not be in JSON output")
+	if len(fake.deleted) != 1 || fake.deleted[0] != "receipt-42" {
+		t.Errorf("expected receipt-42 to be deleted, got %v", fake.deleted)
 	}
 }
@@ -0,0 +1,74 @@
+package sqs
+
+import "time"
+
+// Message represents a task message from SQS
+type Message struct {
+	Type          string   `json:"type,omitempty"` // See the Type constants ("" = issue)
+	IssueNumber   int      `json:"issue_number"`
+	Repository    string   `json:"repository"`
+	Title         string   `json:"title"`
+	Body          string   `json:"body"`
+	Labels        []string `json:"labels"`
+	CreatedAt     string   `json:"created_at"`
+	ReceiptHandle string   `json:"-"`
+	Model         string   `json:"model,omitempty"` // Run with this model only (set by /codingworker model)
+
+	// Follow-up messages (pr_comment / pr_review)
+	PRNumber      int    `json:"pr_number,omitempty"`
+	BranchName    string `json:"branch_name,omitempty"`
+	CommentID     int64  `json:"comment_id,omitempty"` // Trigger comment; also deduplicates deliveries
+	CommentAuthor string `json:"comment_author,omitempty"`
+	ReviewID      int64  `json:"review_id,omitempty"` // pr_review: the review whose comments to address
+
+	// Command messages ("/codingworker <command> [arg]" on an issue)
+	Command    string `json:"command,omitempty"` // See the Command constants
+	CommandArg string `json:"command_arg,omitempty"`
+}
+
+// Message types
+const (
+	TypeIssue     = "issue"      // New task from an issue
+	TypePRComment = "pr_comment" // "/codingworker fix" comment on a worker PR
+	TypePRReview  = "pr_review"  // "Changes requested" review on a worker PR
+	TypeCommand   = "command"    // "/codingworker <command>" comment on an issue
+)
+
+// Commands accepted in command messages
+const (
+	CommandRetry  = "retry"  // Run the issue's task again
+	CommandCancel = "cancel" // Abort the issue's running task
+	CommandModel  = "model"  // Run the task again with the model in CommandArg
+	CommandStatus = "status" // Report the task's state on the issue
+)
+
+// IsFollowUp reports whether the message asks for changes to an existing PR
+func (m *Message) IsFollowUp() bool {
+	return m.Type == TypePRComment || m.Type == TypePRReview
+}
+
+// IsCommand reports whether the message is a command for an issue's task
+func (m *Message) IsCommand() bool {
+	return m.Type == TypeCommand
+}
+
+// Label constants
+const (
+	LabelTrigger    = "ai-task"             // Triggers worker processing
+	LabelInProgress = "ai-task-in-progress" // Added while a worker processes the task
+	LabelFailed     = "ai-task-failed"      // Added on failure
+	LabelDone       = "ai-task-done"        // Added on success (ai-task removed)
+	LabelCancel     = "ai-task-cancel"      // Added by /codingworker cancel; the worker running the task stops it
+)
+
+// CreateTestMessage is a helper to create a test message
+func CreateTestMessage(repo string, issueNumber int, title, body string) *Message {
+	return &Message{
+		IssueNumber: issueNumber,
+		Repository:  repo,
+		Title:       title,
+		Body:        body,
+		Labels:      []string{LabelTrigger},
+		CreatedAt:   time.Now().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,76 @@
+package sqs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCreateTestMessage(t *testing.T) {
+	msg := CreateTestMessage("owner/repo", 42, "Task title", "Task body")
+
+	if msg.IssueNumber != 42 {
+		t.Errorf("expected issue 42, got %d", msg.IssueNumber)
+	}
+	if msg.Repository != "owner/repo" {
+		t.Errorf("expected 'owner/repo', got %s", msg.Repository)
+	}
+	if msg.Title != "Task title" {
+		t.Errorf("expected 'Task title', got %s", msg.Title)
+	}
+	if msg.Body != "Task body" {
+		t.Errorf("expected 'Task body', got %s", msg.Body)
+	}
+	if len(msg.Labels) != 1 || msg.Labels[0] != LabelTrigger {
+		t.Errorf("expected [%s], got %v", LabelTrigger, msg.Labels)
+	}
+	if msg.CreatedAt == "" {
+		t.Error("expected CreatedAt to be set")
+	}
+}
+
+func TestMessage_JSONMarshal(t *testing.T) {
+	msg := &Message{
+		IssueNumber:   1,
+		Repository:    "test/repo",
+		Title:         "Test",
+		Body:          "Body",
+		Labels:        []string{"ai-task"},
+		CreatedAt:     "2024-01-01T00:00:00Z",
+		ReceiptHandle: "should-not-appear",
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// ReceiptHandle should be omitted (json:"-")
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if _, exists := parsed["ReceiptHandle"]; exists {
+		t.Error("ReceiptHandle should not be in JSON output")
+	}
+}
+
+func TestMessage_IsFollowUp(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want bool
+	}{
+		{"", false},
+		{TypeIssue, false},
+		{TypePRComment, true},
+		{TypePRReview, true},
+		{TypeCommand, false},
+	}
+
+	for _, tt := range tests {
+		msg := &Message{Type: tt.typ}
+		if got := msg.IsFollowUp(); got != tt.want {
+			t.Errorf("IsFollowUp() for type %q = %v, want %v", tt.typ, got, tt.want)
+		}
+	}
+}